@@ -8,6 +8,19 @@ type JournalRepeater interface {
 	WriteBatch(records []*Record) error
 }
 
+// JournalBoot is a boot found in the journal with its first entry.
+type JournalBoot struct {
+	Id          string
+	FirstUsec   uint64
+	FirstCursor string
+}
+
+// FiniteJournal is implemented by journals whose input can end, e.g. an export stream on
+// standard input. The runner stops once Done is true instead of waiting for new entries.
+type FiniteJournal interface {
+	Done() bool
+}
+
 type Journal interface {
 	// Close closes a journal opened with NewJournal.
 	Close() error
@@ -53,6 +66,22 @@ type Journal interface {
 	// SeekCursor seeks to a concrete journal cursor.
 	SeekCursor(cursor string) error
 
+	// SeekRealtimeUsec seeks to the entry with the specified realtime (wallclock)
+	// timestamp, i.e. CLOCK_REALTIME. Note that the realtime clock is not
+	// necessarily monotonic. If a realtime timestamp is ambiguous, it is not
+	// defined which position is sought to.
+	SeekRealtimeUsec(usec uint64) error
+
+	// AddMatch adds a match by which to filter the entries of the journal, e.g.
+	// _SYSTEMD_UNIT=sshd.service. Matches of the same field are ORed, matches of
+	// different fields are ANDed.
+	AddMatch(match string) error
+
+	// ListBoots lists the boots in the journal, oldest first, by matching every
+	// _BOOT_ID and reading its first entry. The matches of the journal are not
+	// changed.
+	ListBoots() ([]JournalBoot, error)
+
 	// Wait will synchronously wait until the journal gets changed. The maximum time
 	// this call sleeps may be controlled with the timeout parameter.  If
 	// sdjournal.IndefiniteWait is passed as the timeout parameter, Wait will
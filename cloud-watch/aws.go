@@ -1,12 +1,14 @@
 package cloud_watch
 
 import (
+	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	awsCredentials "github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
-	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	awsSession "github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"os"
 	"strings"
 	lg "github.com/advantageous/go-logback/logging"
@@ -14,120 +16,190 @@ import (
 
 var awsLogger = lg.NewSimpleLogger("aws")
 
-func NewAWSSession(cfg *Config) *awsSession.Session {
+func NewAWSSession(cfg *Config) (*awsSession.Session, error) {
 
-	metaDataClient, session := getClient(cfg)
-	credentials := getCredentials(metaDataClient)
+	session := getSession(cfg)
 
-	if credentials != nil {
-		awsConfig := &aws.Config{
-			Credentials: getCredentials(metaDataClient),
-			Region:      aws.String(getRegion(metaDataClient, cfg, session)),
-			MaxRetries:  aws.Int(3),
+	var provider InstanceMetadataProvider
+	var err error
+	if !cfg.Local {
+		provider, err = NewInstanceMetadataProvider(cfg)
+		if err != nil {
+			return nil, err
 		}
-		return awsSession.New(awsConfig)
-	} else {
-		return awsSession.New(&aws.Config{
-			Region:     aws.String(getRegion(metaDataClient, cfg, session)),
-			MaxRetries: aws.Int(3),
-		})
 	}
 
+	region, err := getRegion(provider, cfg, session)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := getCredentials(cfg, provider, region)
+
+	return awsSession.New(&aws.Config{
+		Credentials: credentials,
+		Region:      aws.String(region),
+		MaxRetries:  aws.Int(3),
+	}), nil
+
 }
 
-func getClient(config *Config) (*ec2metadata.EC2Metadata, *awsSession.Session) {
+// getSession returns the session used to look up EC2 tags, there is none in local mode.
+func getSession(config *Config) *awsSession.Session {
 	if !config.Local {
-		awsLogger.Debug("Config NOT set to local using meta-data client to find local")
-		var session = awsSession.New(&aws.Config{})
-		return ec2metadata.New(session), session
+		awsLogger.Debug("Config NOT set to local using instance metadata")
+		return awsSession.New(&aws.Config{})
 	} else {
 		awsLogger.Info("Config set to local")
-		return nil, nil
+		return nil
 	}
 }
 
-func getRegion(client *ec2metadata.EC2Metadata, config *Config, session *awsSession.Session) string {
+func getRegion(provider InstanceMetadataProvider, config *Config, session *awsSession.Session) (string, error) {
+
+	var region string
 
-	if client == nil {
+	if provider == nil {
 		awsLogger.Info("Client missing using config to set region")
 		if config.AWSRegion == "" {
 			awsLogger.Info("AWSRegion missing using default region us-west-2")
-			return "us-west-2"
+			region = "us-west-2"
 		} else {
-			return config.AWSRegion
+			region = config.AWSRegion
 		}
 	} else {
-		region, err := client.Region()
+		discovery := newMetadataDiscovery(provider, config)
+
+		var err error
+		region, err = discovery.lookup(METADATA_REGION, config.AWSRegion)
 		if err != nil {
-			awsLogger.Errorf("Unable to get region from aws meta client : %s %v", err.Error(), err)
-			os.Exit(3)
+			return "", err
 		}
-
 		config.AWSRegion = region
-		config.EC2InstanceId, err = client.GetMetadata("instance-id")
+
+		config.EC2InstanceId, err = discovery.lookup(METADATA_INSTANCE_ID, config.EC2InstanceId)
 		if err != nil {
-			awsLogger.Errorf("Unable to get instance id from aws meta client : %s %v", err.Error(), err)
-			os.Exit(4)
+			return "", err
 		}
+	}
 
-		if config.LogStreamName == "" {
-			var az, name, ip string
-			az = findAZ(client)
-			ip = findLocalIp(client)
-
-			name = findInstanceName(config.EC2InstanceId, config.AWSRegion, session)
-			config.LogStreamName = name + "-" + strings.Replace(ip, ".", "-", -1) + "-" + az
-			awsLogger.Infof("LogStreamName was not set so using %s \n", config.LogStreamName)
+	template := config.LogStreamName
+	if template == "" {
+		if provider == nil {
+			template = DEFAULT_LOCAL_LOG_STREAM
+		} else {
+			template = DEFAULT_LOG_STREAM
 		}
+	}
 
-		return region
+	if strings.Contains(template, "{") {
+		logStreamName, err := newLogStreamNamer(provider, config, session).name(template)
+		if err != nil {
+			return "", err
+		}
+		config.LogStreamName = logStreamName
+		awsLogger.Infof("LogStreamName %s set from template %s \n", config.LogStreamName, template)
 	}
 
+	return region, nil
+
 }
-func findLocalIp(metaClient *ec2metadata.EC2Metadata) string {
-	ip, err := metaClient.GetMetadata("local-ipv4")
 
-	if err != nil {
-		awsLogger.Errorf("Unable to get private ip address from aws meta client : %s %v", err.Error(), err)
-		os.Exit(6)
-	}
+// getCredentials builds the credential chain: environment, shared credentials file (first when
+// aws_profile is set), web identity token (EKS service accounts) and, with the imds metadata
+// provider, the EC2 instance role.
+// When role_arn is set the chain is only used to assume that role, the assumed role credentials
+// are refreshed before they expire.
+func getCredentials(config *Config, provider InstanceMetadataProvider, region string) *awsCredentials.Credentials {
 
-	return ip
+	stsClient := sts.New(awsSession.New(stsConfig(config, region)))
 
-}
+	profile := &awsCredentials.SharedCredentialsProvider{
+		Filename: config.AWSCredentialsFile,
+		Profile:  config.AWSProfile,
+	}
+	providers := []awsCredentials.Provider{&awsCredentials.EnvProvider{}, profile}
+	if config.AWSProfile != "" {
+		// An explicit profile wins over credentials left in the environment.
+		providers = []awsCredentials.Provider{profile, &awsCredentials.EnvProvider{}}
+	}
 
-func getCredentials(client *ec2metadata.EC2Metadata) *awsCredentials.Credentials {
+	tokenFile := config.WebIdentityTokenFile
+	if tokenFile == "" {
+		tokenFile = os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+	webIdentityRole := os.Getenv("AWS_ROLE_ARN")
+	if webIdentityRole == "" {
+		webIdentityRole = config.RoleArn
+	}
+	if tokenFile != "" && webIdentityRole != "" {
+		awsLogger.Infof("Using web identity token %s for role %s", tokenFile, webIdentityRole)
+		providers = append(providers, stscreds.NewWebIdentityRoleProvider(stsClient,
+			webIdentityRole, config.RoleSessionName, tokenFile))
+	}
 
-	if client == nil {
-		awsLogger.Infof("Client missing credentials not looked up")
-		return nil
+	if imds, ok := provider.(*IMDSMetadataProvider); ok {
+		providers = append(providers, NewIMDSRoleProvider(imds))
 	} else {
-		return awsCredentials.NewChainCredentials([]awsCredentials.Provider{
-			&awsCredentials.EnvProvider{},
-			&ec2rolecreds.EC2RoleProvider{
-				Client: client,
-			},
-		})
+		awsLogger.Infof("No IMDS metadata provider, EC2 role credentials not looked up")
 	}
 
+	credentials := awsCredentials.NewChainCredentials(providers)
+
+	if config.RoleArn == "" || (tokenFile != "" && config.RoleArn == webIdentityRole) {
+		return credentials
+	}
+
+	awsLogger.Infof("Assuming role %s", config.RoleArn)
+	roleConfig := stsConfig(config, region).WithCredentials(credentials)
+	return stscreds.NewCredentials(awsSession.New(roleConfig), config.RoleArn, func(provider *stscreds.AssumeRoleProvider) {
+		provider.RoleSessionName = config.RoleSessionName
+		if config.RoleExternalId != "" {
+			provider.ExternalID = aws.String(config.RoleExternalId)
+		}
+	})
+
 }
 
-func findAZ(metaClient *ec2metadata.EC2Metadata) string {
+func stsConfig(config *Config, region string) *aws.Config {
+	awsConfig := aws.NewConfig().WithRegion(region)
+	if config.STSEndpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(config.STSEndpoint)
+	}
+	return awsConfig
+}
 
-	az, err := metaClient.GetMetadata("placement/availability-zone")
+func findInstanceName(instanceId string, region string, session *awsSession.Session) string {
 
+	name, err := findInstanceTag(instanceId, region, "Name", session)
 	if err != nil {
-		awsLogger.Errorf("Unable to get az from aws meta client : %s %v", err.Error(), err)
-		os.Exit(5)
+		awsLogger.Errorf("Unable to get instance name tag : %s %v", err.Error(), err)
+		return "NO_NAME"
 	}
+	return name
+}
+
+// findInstanceTag reads an instance tag with the EC2 API, for instances that do not have
+// tags in the instance metadata.
+func findInstanceTag(instanceId string, region string, key string, session *awsSession.Session) (string, error) {
 
-	return az
+	instance, _, err := describeInstance(instanceId, region, session)
+	if err != nil {
+		return "", err
+	}
+
+	if value, found := instanceTag(instance, key); found {
+		return value, nil
+	}
+	return "", fmt.Errorf("instance %s has no %s tag", instanceId, key)
 }
 
-func findInstanceName(instanceId string, region string, session *awsSession.Session) string {
+// describeInstance returns the instance and the id of the account that owns it.
+func describeInstance(instanceId string, region string, session *awsSession.Session) (*ec2.Instance, string, error) {
 
-	var name = "NO_NAME"
-	var err error
+	if session == nil {
+		return nil, "", errors.New("no EC2 session in local mode")
+	}
 
 	ec2Service := ec2.New(session, aws.NewConfig().WithRegion(region))
 
@@ -141,25 +213,22 @@ func findInstanceName(instanceId string, region string, session *awsSession.Sess
 	resp, err := ec2Service.DescribeInstances(params)
 
 	if err != nil {
-		awsLogger.Errorf("Unable to get instance name tag DescribeInstances failed : %s %v", err.Error(), err)
-		return name
+		return nil, "", fmt.Errorf("DescribeInstances failed : %s %v", err.Error(), err)
 	}
 
-	if len(resp.Reservations) > 0 && len(resp.Reservations[0].Instances) > 0 {
-		var instance = resp.Reservations[0].Instances[0]
-		if len(instance.Tags) > 0 {
+	if len(resp.Reservations) == 0 || len(resp.Reservations[0].Instances) == 0 {
+		return nil, "", fmt.Errorf("DescribeInstances did not find instance %s", instanceId)
+	}
 
-			for _, tag := range instance.Tags {
-				if *tag.Key == "Name" {
-					return *tag.Value
-				}
-			}
-		}
-		awsLogger.Errorf("Unable to get find name tag ")
-		return name
+	reservation := resp.Reservations[0]
+	return reservation.Instances[0], aws.StringValue(reservation.OwnerId), nil
+}
 
-	} else {
-		awsLogger.Errorf("Unable to get find name tag ")
-		return name
+func instanceTag(instance *ec2.Instance, key string) (string, bool) {
+	for _, tag := range instance.Tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value), true
+		}
 	}
+	return "", false
 }
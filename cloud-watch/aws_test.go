@@ -0,0 +1,179 @@
+package cloud_watch
+
+import (
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func writeCredentialsFile(t *testing.T) string {
+	file, err := ioutil.TempFile("", "credentials")
+	if err != nil {
+		t.Fatalf("Unable to create credentials file %s", err)
+	}
+	file.WriteString(`
+[default]
+aws_access_key_id = DEFAULTKEY
+aws_secret_access_key = DEFAULTSECRET
+
+[logging]
+aws_access_key_id = LOGGINGKEY
+aws_secret_access_key = LOGGINGSECRET
+`)
+	file.Close()
+	return file.Name()
+}
+
+// clearCredentialsEnv unsets the credential variables, the returned function restores them.
+func clearCredentialsEnv() func() {
+	saved := map[string]string{}
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY",
+		"AWS_SECRET_KEY", "AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN"} {
+		if value, found := os.LookupEnv(name); found {
+			saved[name] = value
+		}
+		os.Unsetenv(name)
+	}
+	return func() {
+		for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY",
+			"AWS_SECRET_KEY", "AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN"} {
+			os.Unsetenv(name)
+		}
+		for name, value := range saved {
+			os.Setenv(name, value)
+		}
+	}
+}
+
+func TestCredentialsFromProfile(t *testing.T) {
+
+	defer clearCredentialsEnv()()
+	filename := writeCredentialsFile(t)
+	defer os.Remove(filename)
+
+	config, _ := LoadConfigFromString(`
+local=true
+aws_profile="logging"
+aws_credentials_file="`+filename+`"
+`, nil)
+
+	value, err := getCredentials(config, nil, "us-west-2").Get()
+	if err != nil {
+		t.Fatalf("Unable to get credentials %s", err)
+	}
+	if value.AccessKeyID != "LOGGINGKEY" {
+		t.Errorf("Credentials not read from profile %s", value.AccessKeyID)
+	}
+}
+
+func TestCredentialsProfileBeforeEnvironment(t *testing.T) {
+
+	defer clearCredentialsEnv()()
+	filename := writeCredentialsFile(t)
+	defer os.Remove(filename)
+
+	os.Setenv("AWS_ACCESS_KEY_ID", "ENVKEY")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "ENVSECRET")
+
+	config, _ := LoadConfigFromString(`
+local=true
+aws_profile="logging"
+aws_credentials_file="`+filename+`"
+`, nil)
+
+	value, err := getCredentials(config, nil, "us-west-2").Get()
+	if err != nil || value.AccessKeyID != "LOGGINGKEY" {
+		t.Errorf("Expected aws_profile to be used before the environment %s %v", value.AccessKeyID, err)
+	}
+
+	config, _ = LoadConfigFromString(`
+local=true
+aws_credentials_file="`+filename+`"
+`, nil)
+
+	value, err = getCredentials(config, nil, "us-west-2").Get()
+	if err != nil || value.AccessKeyID != "ENVKEY" {
+		t.Errorf("Expected the environment to be used without aws_profile %s %v", value.AccessKeyID, err)
+	}
+}
+
+func TestCredentialsAssumeRole(t *testing.T) {
+
+	defer clearCredentialsEnv()()
+	filename := writeCredentialsFile(t)
+	defer os.Remove(filename)
+
+	var form map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = map[string]string{}
+		for key := range r.PostForm {
+			form[key] = r.PostForm.Get(key)
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(`<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASSUMEDKEY</AccessKeyId>
+      <SecretAccessKey>ASSUMEDSECRET</SecretAccessKey>
+      <SessionToken>ASSUMEDTOKEN</SessionToken>
+      <Expiration>2099-01-01T00:00:00Z</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`))
+	}))
+	defer server.Close()
+
+	config, _ := LoadConfigFromString(`
+local=true
+aws_credentials_file="`+filename+`"
+role_arn="arn:aws:iam::123456789012:role/central-logging"
+role_external_id="journal"
+sts_endpoint="`+server.URL+`"
+`, nil)
+
+	value, err := getCredentials(config, nil, "us-west-2").Get()
+	if err != nil {
+		t.Fatalf("Unable to assume role %s", err)
+	}
+
+	if value.AccessKeyID != "ASSUMEDKEY" || value.SessionToken != "ASSUMEDTOKEN" {
+		t.Errorf("Assumed role credentials not used %s", value.AccessKeyID)
+	}
+
+	if form["Action"] != "AssumeRole" || form["RoleArn"] != "arn:aws:iam::123456789012:role/central-logging" {
+		t.Errorf("Wrong STS request %v", form)
+	}
+
+	if form["ExternalId"] != "journal" || form["RoleSessionName"] != "systemd-cloud-watch" {
+		t.Errorf("External id or session name not sent %v", form)
+	}
+}
+
+func TestCloudWatchEndpoint(t *testing.T) {
+
+	config, _ := LoadConfigFromString(`
+local=true
+log_stream="test-stream"
+log_group="test-group"
+cloudwatch_endpoint="http://localhost:4566"
+`, nil)
+
+	session, err := NewAWSSession(config)
+	if err != nil {
+		t.Fatalf("Unable to create session %s", err)
+	}
+
+	repeater, err := NewCloudWatchJournalRepeater(session, nil, config)
+	if err != nil {
+		t.Fatalf("Unable to create repeater %s", err)
+	}
+
+	endpoint := repeater.conn.(*cloudwatchlogs.CloudWatchLogs).Endpoint
+	if endpoint != "http://localhost:4566" {
+		t.Errorf("Endpoint not used %s", endpoint)
+	}
+}
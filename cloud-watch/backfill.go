@@ -0,0 +1,204 @@
+package cloud_watch
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+	lg "github.com/advantageous/go-logback/logging"
+)
+
+const BOOT_CURRENT = "current"
+
+// timeLayouts are the absolute times ParseTimeSpec accepts, in local time unless they have a zone.
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// BackfillOptions selects the journal entries Backfill sends. A zero Since starts at the
+// oldest entry and a zero Until reads to the end of the journal.
+type BackfillOptions struct {
+	Since  time.Time
+	Until  time.Time
+	BootId string
+	Units  []string
+}
+
+// ParseTimeSpec parses a backfill bound or the since setting. It accepts now, a duration
+// relative to now like -2h, a duration without a sign like 1h which is also in the past, @
+// followed by unix seconds, RFC 3339 and "2006-01-02 15:04:05" style dates.
+func ParseTimeSpec(spec string, now time.Time) (time.Time, error) {
+
+	spec = strings.TrimSpace(spec)
+
+	switch {
+	case spec == "":
+		return time.Time{}, nil
+	case spec == "now":
+		return now, nil
+	case strings.HasPrefix(spec, "-") || strings.HasPrefix(spec, "+"):
+		duration, err := time.ParseDuration(spec)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid relative time %q, expected e.g. -2h or -30m", spec)
+		}
+		return now.Add(duration), nil
+	case strings.HasPrefix(spec, "@"):
+		seconds, err := strconv.ParseInt(spec[1:], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid unix time %q, expected e.g. @1480459022", spec)
+		}
+		return time.Unix(seconds, 0), nil
+	}
+
+	if duration, err := time.ParseDuration(spec); err == nil {
+		return now.Add(-duration), nil
+	}
+
+	for _, layout := range timeLayouts {
+		if parsed, err := time.ParseInLocation(layout, spec, now.Location()); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected now, -2h, 1h, @1480459022, 2016-11-29 22:37:02 or RFC 3339", spec)
+}
+
+// ParseBootId returns the journal _BOOT_ID for a boot flag, current or 0 is the running boot.
+func ParseBootId(boot string) (string, error) {
+
+	boot = strings.ToLower(strings.TrimSpace(boot))
+
+	switch boot {
+	case "":
+		return "", nil
+	case BOOT_CURRENT, "0":
+		data, err := ioutil.ReadFile(bootIdFile)
+		if err != nil {
+			return "", fmt.Errorf("unable to read the current boot id: %s %v", err.Error(), err)
+		}
+		boot = strings.ToLower(strings.TrimSpace(string(data)))
+	}
+
+	// The journal stores boot ids without dashes.
+	boot = strings.Replace(boot, "-", "", -1)
+	if _, err := hex.DecodeString(boot); err != nil || len(boot) != 32 {
+		return "", fmt.Errorf("boot must be current or a 32 character boot id, not %q", boot)
+	}
+	return boot, nil
+}
+
+// unitName adds .service to a unit without a type like journalctl -u does.
+func unitName(unit string) string {
+	if strings.Contains(unit, ".") {
+		return unit
+	}
+	return unit + ".service"
+}
+
+// addBackfillMatches filters the journal by boot and units. They are added before the
+// priority filters of AddLogFilters so all of them are ANDed.
+func addBackfillMatches(journal Journal, options BackfillOptions) error {
+
+	if options.BootId != "" {
+		if err := journal.AddMatch("_BOOT_ID=" + options.BootId); err != nil {
+			return fmt.Errorf("unable to filter by boot %s: %s %v", options.BootId, err.Error(), err)
+		}
+	}
+
+	for _, unit := range options.Units {
+		if err := journal.AddMatch("_SYSTEMD_UNIT=" + unitName(unit)); err != nil {
+			return fmt.Errorf("unable to filter by unit %s: %s %v", unit, err.Error(), err)
+		}
+	}
+	return nil
+}
+
+// Backfill sends the journal entries between options.Since and options.Until through the
+// repeater in batches of buffer_size and returns the number of records sent. It reads the
+// journal directly, without the queue the runner uses, and stops at the end bound or the end
+// of the journal instead of waiting for new entries. The journal must come from
+// CreateBackfillJournal so the boot and unit filters are applied.
+func Backfill(journal Journal, repeater JournalRepeater, logger lg.Logger, config *Config, options BackfillOptions) (int, error) {
+
+	if logger == nil {
+		logger = lg.GetSimpleLogger("BACKFILL_DEBUG", "backfill")
+	}
+
+	if !options.Since.IsZero() && !options.Until.IsZero() && options.Until.Before(options.Since) {
+		return 0, fmt.Errorf("until %s is before since %s", options.Until.Format(time.RFC3339),
+			options.Since.Format(time.RFC3339))
+	}
+
+	since := uint64(0)
+	if options.Since.IsZero() {
+		if err := journal.SeekHead(); err != nil {
+			return 0, fmt.Errorf("unable to seek to head of systemd journal: %s %v", err.Error(), err)
+		}
+	} else {
+		since = uint64(options.Since.UnixNano() / 1000)
+		if err := journal.SeekRealtimeUsec(since); err != nil {
+			return 0, fmt.Errorf("unable to seek systemd journal to %s: %s %v",
+				options.Since.Format(time.RFC3339), err.Error(), err)
+		}
+	}
+
+	until := uint64(0)
+	if !options.Until.IsZero() {
+		until = uint64(options.Until.UnixNano() / 1000)
+	}
+
+	records := make([]*Record, 0, config.CloudWatchBufferSize)
+	sent := 0
+
+	send := func() error {
+		if len(records) == 0 {
+			return nil
+		}
+		err := repeater.WriteBatch(records)
+		if err != nil {
+			return fmt.Errorf("unable to send backfill batch after %d records: %s %v", sent, err.Error(), err)
+		}
+		sent += len(records)
+		records = make([]*Record, 0, config.CloudWatchBufferSize)
+		return nil
+	}
+
+	for {
+		count, err := journal.Next()
+		if err != nil {
+			return sent, fmt.Errorf("unable to read systemd journal: %s %v", err.Error(), err)
+		}
+		if count == 0 {
+			break
+		}
+
+		timestamp, err := journal.GetRealtimeUsec()
+		if err != nil {
+			return sent, fmt.Errorf("unable to read the time of a journal entry: %s %v", err.Error(), err)
+		}
+		if timestamp < since {
+			continue
+		}
+		if until != 0 && timestamp > until {
+			break
+		}
+
+		record, err := NewRecord(journal, logger, config)
+		if err != nil {
+			return sent, fmt.Errorf("error unmarshalling record: %s %v", err.Error(), err)
+		}
+		record.InstanceId = config.EC2InstanceId
+
+		records = append(records, record)
+		if len(records) >= config.CloudWatchBufferSize {
+			if err := send(); err != nil {
+				return sent, err
+			}
+		}
+	}
+
+	if err := send(); err != nil {
+		return sent, err
+	}
+	logger.Infof("Backfill sent %d records", sent)
+	return sent, nil
+}
@@ -0,0 +1,227 @@
+package cloud_watch
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rangeJournal is a journal of messages one minute apart, starting at start.
+type rangeJournal struct {
+	Journal
+	start    time.Time
+	messages []string
+	position int
+	matches  []string
+}
+
+func newRangeJournal(start time.Time, messages ...string) *rangeJournal {
+	return &rangeJournal{start: start, messages: messages, position: -1}
+}
+
+func (journal *rangeJournal) usec(index int) uint64 {
+	return uint64(journal.start.Add(time.Duration(index)*time.Minute).UnixNano() / 1000)
+}
+
+func (journal *rangeJournal) SeekHead() error {
+	journal.position = -1
+	return nil
+}
+
+func (journal *rangeJournal) SeekRealtimeUsec(usec uint64) error {
+	journal.position = len(journal.messages) - 1
+	for index := range journal.messages {
+		if journal.usec(index) >= usec {
+			journal.position = index - 1
+			break
+		}
+	}
+	return nil
+}
+
+func (journal *rangeJournal) AddMatch(match string) error {
+	journal.matches = append(journal.matches, match)
+	return nil
+}
+
+func (journal *rangeJournal) Next() (uint64, error) {
+	if journal.position+1 >= len(journal.messages) {
+		return 0, nil
+	}
+	journal.position++
+	return 1, nil
+}
+
+func (journal *rangeJournal) GetRealtimeUsec() (uint64, error) {
+	return journal.usec(journal.position), nil
+}
+
+func (journal *rangeJournal) GetMonotonicUsec() (uint64, error) {
+	return uint64(journal.position) * 60000000, nil
+}
+
+func (journal *rangeJournal) GetCursor() (string, error) {
+	return "i=" + strconv.Itoa(journal.position), nil
+}
+
+// SeekCursor positions before the entry of the cursor so Next reads it like sd_journal does.
+func (journal *rangeJournal) SeekCursor(cursor string) error {
+	index, err := strconv.Atoi(strings.TrimPrefix(cursor, "i="))
+	journal.position = index - 1
+	return err
+}
+
+func (journal *rangeJournal) GetDataValue(field string) (string, error) {
+	switch field {
+	case "MESSAGE":
+		return journal.messages[journal.position], nil
+	case "__REALTIME_TIMESTAMP":
+		return strconv.FormatUint(journal.usec(journal.position), 10), nil
+	}
+	return "", nil
+}
+
+type batchRepeater struct {
+	batches [][]*Record
+}
+
+func (repeater *batchRepeater) Close() error {
+	return nil
+}
+
+func (repeater *batchRepeater) WriteBatch(records []*Record) error {
+	repeater.batches = append(repeater.batches, records)
+	return nil
+}
+
+func TestParseTimeSpec(t *testing.T) {
+
+	now := time.Date(2016, 11, 29, 22, 37, 2, 0, time.UTC)
+
+	for spec, expected := range map[string]time.Time{
+		"now":                  now,
+		"-2h":                  now.Add(-2 * time.Hour),
+		"-1h30m":               now.Add(-90 * time.Minute),
+		"1h":                   now.Add(-time.Hour),
+		"@1480459022":          time.Unix(1480459022, 0),
+		"2016-11-29 20:00:00":  time.Date(2016, 11, 29, 20, 0, 0, 0, time.UTC),
+		"2016-11-29":           time.Date(2016, 11, 29, 0, 0, 0, 0, time.UTC),
+		"2016-11-29T20:00:00Z": time.Date(2016, 11, 29, 20, 0, 0, 0, time.UTC),
+	} {
+		parsed, err := ParseTimeSpec(spec, now)
+		if err != nil {
+			t.Errorf("Unable to parse %s %s", spec, err)
+		} else if !parsed.Equal(expected) {
+			t.Errorf("%s parsed as %s not %s", spec, parsed, expected)
+		}
+	}
+
+	for _, spec := range []string{"yesterday", "-2 hours", "@now"} {
+		if _, err := ParseTimeSpec(spec, now); err == nil {
+			t.Errorf("Expected %s to be invalid", spec)
+		}
+	}
+}
+
+func TestParseBootId(t *testing.T) {
+
+	file, err := ioutil.TempFile("", "boot_id")
+	if err != nil {
+		t.Fatalf("Unable to create boot id file %s", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("923DEF06-48b1-422a-a28a-8846072481f2\n")
+	file.Close()
+
+	saved := bootIdFile
+	bootIdFile = file.Name()
+	defer func() { bootIdFile = saved }()
+
+	boot, err := ParseBootId("current")
+	if err != nil || boot != "923def0648b1422aa28a8846072481f2" {
+		t.Errorf("Current boot not read %s %v", boot, err)
+	}
+
+	boot, err = ParseBootId("923def0648b1422aa28a8846072481f2")
+	if err != nil || boot != "923def0648b1422aa28a8846072481f2" {
+		t.Errorf("Boot id not used %s %v", boot, err)
+	}
+
+	if _, err = ParseBootId("-1"); err == nil {
+		t.Errorf("Expected boot offsets to be rejected")
+	}
+}
+
+func TestBackfillTimeRange(t *testing.T) {
+
+	start := time.Date(2016, 11, 29, 20, 0, 0, 0, time.UTC)
+	journal := newRangeJournal(start, "one", "two", "three", "four", "five", "six")
+	repeater := &batchRepeater{}
+
+	config, _ := LoadConfigFromString(`buffer_size=2`, nil)
+
+	sent, err := Backfill(journal, repeater, nil, config, BackfillOptions{
+		Since: start.Add(time.Minute),
+		Until: start.Add(4 * time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("Backfill failed %s", err)
+	}
+
+	if sent != 4 || len(repeater.batches) != 2 {
+		t.Fatalf("Expected 4 records in 2 batches, sent %d in %d", sent, len(repeater.batches))
+	}
+
+	if repeater.batches[0][0].Message != "two" || repeater.batches[1][1].Message != "five" {
+		t.Errorf("Wrong records sent %s %s", repeater.batches[0][0].Message, repeater.batches[1][1].Message)
+	}
+}
+
+func TestBackfillToEndOfJournal(t *testing.T) {
+
+	start := time.Date(2016, 11, 29, 20, 0, 0, 0, time.UTC)
+	journal := newRangeJournal(start, "one", "two", "three")
+	repeater := &batchRepeater{}
+
+	config, _ := LoadConfigFromString(``, nil)
+
+	sent, err := Backfill(journal, repeater, nil, config, BackfillOptions{})
+	if err != nil {
+		t.Fatalf("Backfill failed %s", err)
+	}
+	if sent != 3 || len(repeater.batches) != 1 {
+		t.Errorf("Expected the whole journal in 1 batch, sent %d in %d", sent, len(repeater.batches))
+	}
+
+	_, err = Backfill(journal, repeater, nil, config, BackfillOptions{Since: start, Until: start.Add(-time.Hour)})
+	if err == nil {
+		t.Errorf("Expected until before since to fail")
+	}
+}
+
+func TestBackfillMatches(t *testing.T) {
+
+	journal := newRangeJournal(time.Now())
+
+	err := addBackfillMatches(journal, BackfillOptions{
+		BootId: "923def0648b1422aa28a8846072481f2",
+		Units:  []string{"sshd", "docker.socket"},
+	})
+	if err != nil {
+		t.Fatalf("Unable to add matches %s", err)
+	}
+
+	expected := []string{"_BOOT_ID=923def0648b1422aa28a8846072481f2", "_SYSTEMD_UNIT=sshd.service",
+		"_SYSTEMD_UNIT=docker.socket"}
+	if len(journal.matches) != len(expected) {
+		t.Fatalf("Wrong matches %v", journal.matches)
+	}
+	for i := range expected {
+		if journal.matches[i] != expected[i] {
+			t.Errorf("Match %d is %s not %s", i, journal.matches[i], expected[i])
+		}
+	}
+}
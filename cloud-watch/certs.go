@@ -0,0 +1,23 @@
+package cloud_watch
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// loadCertPool reads a PEM file of CA certificates, used to verify TLS servers that are not
+// signed by a system root.
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA file %s: %s %v", caFile, err.Error(), err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", caFile)
+	}
+	return pool, nil
+}
@@ -0,0 +1,203 @@
+package cloud_watch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	lg "github.com/advantageous/go-logback/logging"
+)
+
+var (
+	// checkpointBackoff is the wait before the first retry of a batch, it doubles with every
+	// retry up to checkpointMaxBackoff.
+	checkpointBackoff    = time.Second
+	checkpointMaxBackoff = 30 * time.Second
+)
+
+// CheckpointJournalRepeater saves the cursor of the last record of every batch that was sent
+// to state_file, keyed by the destination of the repeater. On startup the runner resumes after
+// that cursor. A batch is only checkpointed after it was sent, so delivery is at least once: a
+// crash in between sends it again. The CloudWatch repeater skips the records already in its
+// stream, other destinations get the duplicates with the entryId that identifies them.
+type CheckpointJournalRepeater struct {
+	repeater    JournalRepeater
+	stateFile   string
+	destination string
+	checkpoints map[string]string
+	closed      chan struct{}
+	closeOnce   sync.Once
+	// stalled is set once a batch was not sent, later batches must not move the checkpoint past it.
+	stalled bool
+	logger  lg.Logger
+}
+
+// NewCheckpointJournalRepeater wraps repeater and reads the checkpoints of state_file.
+func NewCheckpointJournalRepeater(repeater JournalRepeater, logger lg.Logger, config *Config) (*CheckpointJournalRepeater, error) {
+
+	if logger == nil {
+		if !config.Debug {
+			logger = lg.GetSimpleLogger("CHECKPOINT_REPEATER_DEBUG", "checkpoint-repeater")
+		} else {
+			logger = lg.NewSimpleDebugLogger("checkpoint-repeater")
+		}
+	}
+
+	checkpoints, err := readCheckpoints(config.StateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CheckpointJournalRepeater{
+		repeater:    repeater,
+		stateFile:   config.StateFile,
+		destination: checkpointDestination(config),
+		checkpoints: checkpoints,
+		closed:      make(chan struct{}),
+		logger:      logger,
+	}, nil
+}
+
+// checkpointDestination names where the repeater sends records, e.g. cloudwatch:group/stream.
+// Each destination has its own checkpoint so switching repeaters does not skip entries.
+func checkpointDestination(config *Config) string {
+	switch config.RepeaterType {
+	case REPEATER_ELASTICSEARCH:
+		return REPEATER_ELASTICSEARCH + ":" + config.ElasticSearchURL + "/" + config.ElasticSearchIndex
+	case REPEATER_LOKI:
+		return REPEATER_LOKI + ":" + config.LokiURL
+	case REPEATER_SYSLOG:
+		return REPEATER_SYSLOG + ":" + config.SyslogAddress
+	case REPEATER_KAFKA:
+		return REPEATER_KAFKA + ":" + strings.Join(config.KafkaBrokers, ",") + "/" + config.KafkaTopic
+	case REPEATER_MOCK, REPEATER_STDOUT:
+		return config.RepeaterType
+	}
+	return REPEATER_CLOUDWATCH + ":" + config.LogGroupName + "/" + config.LogStreamName
+}
+
+// Cursor is the cursor of the last entry sent to the destination, empty when none was.
+func (repeater *CheckpointJournalRepeater) Cursor() string {
+	return repeater.checkpoints[repeater.destination]
+}
+
+// Destination is the key of the checkpoint in state_file.
+func (repeater *CheckpointJournalRepeater) Destination() string {
+	return repeater.destination
+}
+
+// Stop ends the retries of a batch that is being sent, the batch is not checkpointed. The
+// runner stops the repeater on SIGINT and SIGTERM so it does not wait for a destination that
+// is down.
+func (repeater *CheckpointJournalRepeater) Stop() {
+	repeater.closeOnce.Do(func() {
+		close(repeater.closed)
+	})
+}
+
+// Close stops the repeater and closes the repeater it wraps.
+func (repeater *CheckpointJournalRepeater) Close() error {
+	repeater.Stop()
+	return repeater.repeater.Close()
+}
+
+// WriteBatch sends the batch and checkpoints its last cursor once it was sent. A batch that
+// fails is retried with a backoff until it is sent or the repeater is closed, so the checkpoint
+// never moves past entries that were not sent. Once a batch was given up no batch is
+// checkpointed any more, the next start sends it again.
+func (repeater *CheckpointJournalRepeater) WriteBatch(records []*Record) error {
+
+	backoff := checkpointBackoff
+	err := repeater.repeater.WriteBatch(records)
+	for retry := 1; err != nil; retry++ {
+		repeater.logger.Warnf("Unable to send batch of %d records, retry %d in %s : %s %v",
+			len(records), retry, backoff, err.Error(), err)
+		select {
+		case <-time.After(backoff):
+		case <-repeater.closed:
+			repeater.stalled = true
+			return fmt.Errorf("batch of %d records not sent, it is sent again on the next start: %s %v",
+				len(records), err.Error(), err)
+		}
+		if backoff *= 2; backoff > checkpointMaxBackoff {
+			backoff = checkpointMaxBackoff
+		}
+		err = repeater.repeater.WriteBatch(records)
+	}
+
+	if repeater.stalled {
+		repeater.logger.Warnf("Batch of %d records sent but not checkpointed, an earlier batch was not sent", len(records))
+		return nil
+	}
+
+	for index := len(records) - 1; index >= 0; index-- {
+		if records[index].Cursor != "" {
+			return repeater.save(records[index].Cursor)
+		}
+	}
+	return nil
+}
+
+func (repeater *CheckpointJournalRepeater) save(cursor string) error {
+	repeater.checkpoints[repeater.destination] = cursor
+	if err := writeCheckpoints(repeater.stateFile, repeater.checkpoints); err != nil {
+		return fmt.Errorf("batch sent but not checkpointed: %s %v", err.Error(), err)
+	}
+	return nil
+}
+
+// readCheckpoints reads the JSON object of destinations and cursors in state_file. A missing
+// or empty file has no checkpoints.
+func readCheckpoints(stateFile string) (map[string]string, error) {
+
+	checkpoints := map[string]string{}
+	data, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return checkpoints, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read state_file %s: %s %v", stateFile, err.Error(), err)
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return checkpoints, nil
+	}
+	if err := json.Unmarshal(data, &checkpoints); err != nil {
+		return nil, fmt.Errorf("unable to parse state_file %s: %s %v", stateFile, err.Error(), err)
+	}
+	return checkpoints, nil
+}
+
+// writeCheckpoints writes a synced temporary file and renames it to state_file, so a crash
+// leaves either the old or the new checkpoints.
+func writeCheckpoints(stateFile string, checkpoints map[string]string) error {
+
+	data, err := json.Marshal(checkpoints)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(stateFile)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(dir, filepath.Base(stateFile)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), stateFile)
+}
@@ -0,0 +1,263 @@
+package cloud_watch
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	lg "github.com/advantageous/go-logback/logging"
+)
+
+// failingRepeater fails its first failures writes and every write of a batch starting with a
+// message in failing.
+type failingRepeater struct {
+	mutex    sync.Mutex
+	failures int
+	failing  map[string]bool
+	writes   int
+}
+
+func (repeater *failingRepeater) Close() error {
+	return nil
+}
+
+func (repeater *failingRepeater) WriteBatch(records []*Record) error {
+	repeater.mutex.Lock()
+	defer repeater.mutex.Unlock()
+	repeater.writes++
+	if repeater.failures > 0 || repeater.failing[records[0].Message] {
+		repeater.failures--
+		return errors.New("destination unavailable")
+	}
+	return nil
+}
+
+func (repeater *failingRepeater) writeCount() int {
+	repeater.mutex.Lock()
+	defer repeater.mutex.Unlock()
+	return repeater.writes
+}
+
+func newStateFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir %s", err)
+	}
+	return filepath.Join(dir, "state"), func() { os.RemoveAll(dir) }
+}
+
+func TestCheckpointRepeater(t *testing.T) {
+
+	stateFile, cleanup := newStateFile(t)
+	defer cleanup()
+
+	err := writeCheckpoints(stateFile, map[string]string{"loki:http://loki:3100": "s=other;i=9"})
+	if err != nil {
+		t.Fatalf("Unable to write state file %s", err)
+	}
+
+	config, _ := LoadConfigFromString(`log_group="journal"
+log_stream="web-1"
+state_file="`+stateFile+`"`, nil)
+
+	repeater, err := NewCheckpointJournalRepeater(NewMockJournalRepeater(), nil, config)
+	if err != nil {
+		t.Fatalf("Unable to create repeater %s", err)
+	}
+	if repeater.Destination() != "cloudwatch:journal/web-1" || repeater.Cursor() != "" {
+		t.Errorf("Unexpected destination %s or cursor %s", repeater.Destination(), repeater.Cursor())
+	}
+
+	err = repeater.WriteBatch([]*Record{{Message: "one", Cursor: "s=a;i=1"}, {Message: "two", Cursor: "s=a;i=2"}})
+	if err != nil {
+		t.Fatalf("Unable to write batch %s", err)
+	}
+
+	checkpoints, err := readCheckpoints(stateFile)
+	if err != nil {
+		t.Fatalf("Unable to read state file %s", err)
+	}
+	if checkpoints["cloudwatch:journal/web-1"] != "s=a;i=2" || checkpoints["loki:http://loki:3100"] != "s=other;i=9" {
+		t.Errorf("Unexpected checkpoints %v", checkpoints)
+	}
+
+	checkpointBackoff = time.Millisecond
+	defer func() { checkpointBackoff = time.Second }()
+
+	failing := &failingRepeater{failures: 2}
+	repeater, _ = NewCheckpointJournalRepeater(failing, nil, config)
+	if repeater.Cursor() != "s=a;i=2" {
+		t.Errorf("Expected the saved cursor, got %s", repeater.Cursor())
+	}
+	if err = repeater.WriteBatch([]*Record{{Message: "three", Cursor: "s=a;i=3"}}); err != nil {
+		t.Errorf("Expected the batch to be retried until it was sent %s", err)
+	}
+	if failing.writeCount() != 3 {
+		t.Errorf("Expected 3 writes, got %d", failing.writeCount())
+	}
+	if checkpoints, _ = readCheckpoints(stateFile); checkpoints["cloudwatch:journal/web-1"] != "s=a;i=3" {
+		t.Errorf("Expected the retried batch to be checkpointed %v", checkpoints)
+	}
+}
+
+func TestCheckpointStopsAtFailedBatch(t *testing.T) {
+
+	stateFile, cleanup := newStateFile(t)
+	defer cleanup()
+
+	checkpointBackoff = time.Millisecond
+	defer func() { checkpointBackoff = time.Second }()
+
+	config, _ := LoadConfigFromString(`state_file="`+stateFile+`"`, nil)
+	failing := &failingRepeater{failing: map[string]bool{"n": true}}
+	repeater, _ := NewCheckpointJournalRepeater(failing, nil, config)
+
+	if err := repeater.WriteBatch([]*Record{{Message: "n-1", Cursor: "s=a;i=1"}}); err != nil {
+		t.Fatalf("Unable to write batch %s", err)
+	}
+
+	result := make(chan error)
+	go func() {
+		result <- repeater.WriteBatch([]*Record{{Message: "n", Cursor: "s=a;i=2"}})
+	}()
+	for failing.writeCount() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	repeater.Close()
+	if err := <-result; err == nil {
+		t.Error("Expected the batch to fail once the repeater was closed")
+	}
+
+	if err := repeater.WriteBatch([]*Record{{Message: "n+1", Cursor: "s=a;i=3"}}); err != nil {
+		t.Fatalf("Unable to write batch %s", err)
+	}
+	if checkpoints, _ := readCheckpoints(stateFile); checkpoints[repeater.Destination()] != "s=a;i=1" {
+		t.Errorf("Expected the checkpoint to stay before the failed batch %v", checkpoints)
+	}
+}
+
+func TestSourceCursors(t *testing.T) {
+
+	if cursors := sourceCursors("s=a;i=1"); len(cursors) != 1 || cursors[0] != "s=a;i=1" {
+		t.Errorf("Expected the cursor of a single journal, got %v", cursors)
+	}
+
+	cursors := sourceCursors(`{"host":"s=a;i=1","namespace:audit":"s=b;i=2"}`)
+	if len(cursors) != 2 || (cursors[0] != "s=b;i=2" && cursors[1] != "s=b;i=2") {
+		t.Errorf("Expected the cursor of every source, got %v", cursors)
+	}
+}
+
+func TestRunnerResumesAfterCheckpoint(t *testing.T) {
+
+	stateFile, cleanup := newStateFile(t)
+	defer cleanup()
+
+	journal, config := openExportSample(t, exportJournalSamples[0], `state_file="`+stateFile+`"`)
+	defer journal.Close()
+
+	// The fourth entry was the last one sent.
+	cursor := ""
+	for entryId(cursor) != "cebf68fa8fd94375b59c66407dad2967-4" {
+		if count, err := journal.Next(); count == 0 || err != nil {
+			t.Fatalf("Unable to read the sample %s", err)
+		}
+		cursor, _ = journal.GetCursor()
+	}
+	if err := writeCheckpoints(stateFile, map[string]string{checkpointDestination(config): cursor}); err != nil {
+		t.Fatalf("Unable to write state file %s", err)
+	}
+
+	collecting := &collectingRepeater{}
+	repeater, err := NewCheckpointJournalRepeater(collecting, nil, config)
+	if err != nil {
+		t.Fatalf("Unable to create repeater %s", err)
+	}
+
+	done := make(chan bool)
+	go func() {
+		NewRunner(journal, repeater, lg.NewSimpleLogger("checkpoint-test"), config)
+		done <- true
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the runner to stop at the end of the input")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(collecting.collected()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	records := collecting.collected()
+	if len(records) != 3 || records[0].EntryId != "cebf68fa8fd94375b59c66407dad2967-5" ||
+		records[2].EntryId != "cebf68fa8fd94375b59c66407dad2967-7" {
+		t.Fatalf("Expected the entries after the checkpoint, got %+v", records)
+	}
+
+	deadline = time.Now().Add(2 * time.Second)
+	checkpoints, _ := readCheckpoints(stateFile)
+	for checkpoints[checkpointDestination(config)] != records[2].Cursor && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		checkpoints, _ = readCheckpoints(stateFile)
+	}
+	if checkpoints[checkpointDestination(config)] != records[2].Cursor {
+		t.Errorf("Expected the last entry to be checkpointed, got %v", checkpoints)
+	}
+}
+
+func TestRunnerStopsWhileDestinationIsDown(t *testing.T) {
+
+	stateFile, cleanup := newStateFile(t)
+	defer cleanup()
+
+	checkpointBackoff = time.Millisecond
+	defer func() { checkpointBackoff = time.Second }()
+
+	journal, config := openExportSample(t, exportJournalSamples[0], `state_file="`+stateFile+`"`)
+	defer journal.Close()
+
+	failing := &failingRepeater{failures: math.MaxInt32}
+	repeater, err := NewCheckpointJournalRepeater(failing, nil, config)
+	if err != nil {
+		t.Fatalf("Unable to create repeater %s", err)
+	}
+
+	done := make(chan bool)
+	go func() {
+		NewRunner(journal, repeater, lg.NewSimpleLogger("checkpoint-test"), config)
+		done <- true
+	}()
+
+	for failing.writeCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected SIGTERM to stop the runner while the batch was retried")
+	}
+
+	// The retries end, the write count stops growing.
+	writes := -1
+	deadline := time.Now().Add(2 * time.Second)
+	for writes != failing.writeCount() && time.Now().Before(deadline) {
+		writes = failing.writeCount()
+		time.Sleep(50 * time.Millisecond)
+	}
+	if writes != failing.writeCount() {
+		t.Error("Expected SIGTERM to end the retries of the batch")
+	}
+	if checkpoints, _ := readCheckpoints(stateFile); len(checkpoints) != 0 {
+		t.Errorf("Expected the failed batch not to be checkpointed %v", checkpoints)
+	}
+}
@@ -8,22 +8,60 @@ import (
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	awsSession "github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"io"
 	lg "github.com/advantageous/go-logback/logging"
 )
 
-var messageId = int64(0)
+const (
+	// MAX_EVENT_SIZE is the largest event message PutLogEvents accepts, 256 KB less the 26
+	// bytes CloudWatch counts for every event.
+	MAX_EVENT_SIZE = 256*1024 - EVENT_OVERHEAD
+	// MAX_BATCH_BYTES is the largest PutLogEvents call, counting the messages and their overhead.
+	MAX_BATCH_BYTES = 1024 * 1024
+	EVENT_OVERHEAD  = 26
+
+	EVENT_SIZE_POLICY_TRUNCATE = "truncate"
+	EVENT_SIZE_POLICY_SPLIT    = "split"
+)
 
 type CloudWatchJournalRepeater struct {
-	conn              *cloudwatchlogs.CloudWatchLogs
+	conn              cloudwatchlogsiface.CloudWatchLogsAPI
 	logGroupName      string
 	logStreamName     string
 	nextSequenceToken string
 	logger            lg.Logger
 	config            *Config
+
+	// failedBatch is the first record of the batch whose PutLogEvents call failed and accepted
+	// the number of its events sent before, a retry of the batch resumes after them.
+	failedBatch *Record
+	accepted    int
+
+	// resume is set until the last event of the stream was looked up, lastEvent is that event
+	// until a batch was sent. After a crash between sending a batch and checkpointing it, the
+	// records up to lastEvent are not sent again.
+	resume    bool
+	lastEvent *sentEvent
+}
+
+// sentEvent is the part of an event message that identifies the record it was encoded from.
+type sentEvent struct {
+	EntryId string `json:"entryId"`
+	Part    int    `json:"part"`
+	Parts   int    `json:"parts"`
 }
 
 func NewCloudWatchJournalRepeater(sess *awsSession.Session, logger lg.Logger, config *Config) (*CloudWatchJournalRepeater, error) {
-	conn := cloudwatchlogs.New(sess)
+	awsConfig := aws.NewConfig()
+	if config.CloudWatchEndpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(config.CloudWatchEndpoint)
+	}
+	return newCloudWatchJournalRepeater(cloudwatchlogs.New(sess, awsConfig), logger, config)
+}
+
+func newCloudWatchJournalRepeater(conn cloudwatchlogsiface.CloudWatchLogsAPI, logger lg.Logger, config *Config) (*CloudWatchJournalRepeater, error) {
+
 	if logger == nil {
 		if !config.Debug {
 			logger = lg.GetSimpleLogger("CLOUD_WATCH_REPEATER_DEBUG", "repeater")
@@ -32,43 +70,329 @@ func NewCloudWatchJournalRepeater(sess *awsSession.Session, logger lg.Logger, co
 		}
 	}
 
-	return &CloudWatchJournalRepeater{
+	if config.RetentionDays != 0 && !validRetentionDays(config.RetentionDays) {
+		return nil, fmt.Errorf("retention_days %d is not supported by CloudWatch Logs, use one of %v",
+			config.RetentionDays, retentionDays)
+	}
+
+	repeater := &CloudWatchJournalRepeater{
 		conn:              conn,
 		logGroupName:      config.LogGroupName,
 		logStreamName:     config.LogStreamName,
 		nextSequenceToken: "",
 		logger:            logger,
 		config:            config,
-	}, nil
+		resume:            config.StateFile != "" && !config.DryRun,
+	}
+
+	if config.ReconcileLogGroup {
+		err := repeater.reconcileLogGroup()
+		if err != nil {
+			logger.Errorf("Unable to reconcile log group %s : %s %v", config.LogGroupName, err.Error(), err)
+		}
+	}
+
+	return repeater, nil
+}
+
+// retentionDays are the retention periods PutRetentionPolicy accepts.
+var retentionDays = []int{1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1096, 1827, 2192,
+	2557, 2922, 3288, 3653}
+
+func validRetentionDays(days int) bool {
+	for _, valid := range retentionDays {
+		if days == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// reconcileLogGroup applies retention_days, kms_key_id and log_group_tags to an existing log group.
+// A log group that does not exist yet is configured when WriteBatch creates it.
+func (repeater *CloudWatchJournalRepeater) reconcileLogGroup() error {
+
+	output, err := repeater.conn.DescribeLogGroups(&cloudwatchlogs.DescribeLogGroupsInput{
+		LogGroupNamePrefix: aws.String(repeater.logGroupName),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, logGroup := range output.LogGroups {
+		if aws.StringValue(logGroup.LogGroupName) != repeater.logGroupName {
+			continue
+		}
+
+		tags := map[string]*string{}
+		if len(repeater.config.LogGroupTags) > 0 {
+			tagsOutput, err := repeater.conn.ListTagsLogGroup(&cloudwatchlogs.ListTagsLogGroupInput{
+				LogGroupName: aws.String(repeater.logGroupName),
+			})
+			if err != nil {
+				return err
+			}
+			tags = tagsOutput.Tags
+		}
+		return repeater.configureLogGroup(logGroup, tags)
+	}
+
+	repeater.logger.Infof("Log group %s does not exist yet, it is configured when it is created", repeater.logGroupName)
+	return nil
+}
+
+// configureLogGroup brings the retention, KMS key and tags of the log group in line with the config.
+// current and tags describe the log group as it is, both are empty for a log group that was just created.
+func (repeater *CloudWatchJournalRepeater) configureLogGroup(current *cloudwatchlogs.LogGroup, tags map[string]*string) error {
+
+	config := repeater.config
+	logGroupName := aws.String(repeater.logGroupName)
+
+	if config.RetentionDays != 0 && aws.Int64Value(current.RetentionInDays) != int64(config.RetentionDays) {
+		repeater.logger.Infof("Setting retention of log group %s to %d days", repeater.logGroupName, config.RetentionDays)
+		_, err := repeater.conn.PutRetentionPolicy(&cloudwatchlogs.PutRetentionPolicyInput{
+			LogGroupName:    logGroupName,
+			RetentionInDays: aws.Int64(int64(config.RetentionDays)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set retention: %s %v", err.Error(), err)
+		}
+	}
+
+	if config.KMSKeyId != "" && aws.StringValue(current.KmsKeyId) != config.KMSKeyId {
+		repeater.logger.Infof("Associating log group %s with KMS key %s", repeater.logGroupName, config.KMSKeyId)
+		_, err := repeater.conn.AssociateKmsKey(&cloudwatchlogs.AssociateKmsKeyInput{
+			LogGroupName: logGroupName,
+			KmsKeyId:     aws.String(config.KMSKeyId),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to associate KMS key: %s %v", err.Error(), err)
+		}
+	}
+
+	missing := map[string]*string{}
+	for key, value := range config.LogGroupTags {
+		if tags[key] == nil || *tags[key] != value {
+			missing[key] = aws.String(value)
+		}
+	}
+	if len(missing) > 0 {
+		repeater.logger.Infof("Tagging log group %s", repeater.logGroupName)
+		_, err := repeater.conn.TagLogGroup(&cloudwatchlogs.TagLogGroupInput{
+			LogGroupName: logGroupName,
+			Tags:         missing,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to tag log group: %s %v", err.Error(), err)
+		}
+	}
+
+	return nil
 }
 
 func (repeater *CloudWatchJournalRepeater) Close() error {
+	if closer, ok := repeater.conn.(io.Closer); ok {
+		return closer.Close()
+	}
 	return nil
 }
 
 func (repeater *CloudWatchJournalRepeater) WriteBatch(records []*Record) error {
 
-	debug := repeater.config.Debug
-	logger := repeater.logger
+	if repeater.resume {
+		if err := repeater.lookupLastEvent(); err != nil {
+			return err
+		}
+	}
+	if repeater.lastEvent != nil {
+		records = repeater.skipSentRecords(records)
+		if len(records) == 0 {
+			repeater.lastEvent = nil
+			return nil
+		}
+	}
 
 	events := make([]*cloudwatchlogs.InputLogEvent, 0, len(records))
 	for _, record := range records {
 
-		messageId++
-		record.SeqId = messageId
-
-		jsonDataBytes, err := json.MarshalIndent(*record, "", "  ")
+		messages, err := encodeEventMessages(record, repeater.config.EventSizePolicy)
 		if err != nil {
 			return err
 		}
-		jsonData := string(jsonDataBytes)
 
-		events = append(events, &cloudwatchlogs.InputLogEvent{
-			Message:   aws.String(jsonData),
-			Timestamp: aws.Int64(int64(record.TimeUsec)),
-		})
+		for _, message := range messages {
+			events = append(events, &cloudwatchlogs.InputLogEvent{
+				Message:   aws.String(message),
+				Timestamp: aws.Int64(record.TimeUsec / 1000),
+			})
+		}
 	}
 
+	sent := 0
+	if len(records) > 0 && records[0] == repeater.failedBatch {
+		sent = repeater.accepted
+	}
+	repeater.failedBatch = nil
+
+	for sent < len(events) {
+		count := eventBatchCount(events[sent:])
+		if err := repeater.putLogEvents(events[sent : sent+count]); err != nil {
+			repeater.failedBatch = records[0]
+			repeater.accepted = sent
+			return err
+		}
+		sent += count
+	}
+	repeater.lastEvent = nil
+	return nil
+}
+
+// lookupLastEvent reads the newest event of the stream. A stream that does not exist yet has
+// no events.
+func (repeater *CloudWatchJournalRepeater) lookupLastEvent() error {
+
+	output, err := repeater.conn.GetLogEvents(&cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(repeater.logGroupName),
+		LogStreamName: aws.String(repeater.logStreamName),
+		StartFromHead: aws.Bool(false),
+		Limit:         aws.Int64(1),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == cloudwatchlogs.ErrCodeResourceNotFoundException {
+		repeater.resume = false
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read the last event of log stream %s: %s %v", repeater.logStreamName, err.Error(), err)
+	}
+
+	repeater.resume = false
+	if len(output.Events) == 0 {
+		return nil
+	}
+	event := &sentEvent{}
+	if err := json.Unmarshal([]byte(aws.StringValue(output.Events[0].Message)), event); err != nil || event.EntryId == "" {
+		repeater.logger.Warnf("Last event of log stream %s has no entryId, it is not used to resume", repeater.logStreamName)
+		return nil
+	}
+	repeater.lastEvent = event
+	return nil
+}
+
+// skipSentRecords drops the records up to the last event of the stream, they were sent before
+// a crash. A record split into parts is only dropped when its last part was sent.
+func (repeater *CloudWatchJournalRepeater) skipSentRecords(records []*Record) []*Record {
+	for index, record := range records {
+		if record.EntryId != repeater.lastEvent.EntryId {
+			continue
+		}
+		if repeater.lastEvent.Part < repeater.lastEvent.Parts {
+			index--
+		}
+		repeater.logger.Infof("Skipping %d records already in log stream %s", index+1, repeater.logStreamName)
+		return records[index+1:]
+	}
+	return records
+}
+
+// eventBatchCount returns how many of the events fit in one PutLogEvents call.
+func eventBatchCount(events []*cloudwatchlogs.InputLogEvent) int {
+	size := 0
+	for count, event := range events {
+		size += len(*event.Message) + EVENT_OVERHEAD
+		if count == MAX_BATCH_SIZE || (count > 0 && size > MAX_BATCH_BYTES) {
+			return count
+		}
+	}
+	return len(events)
+}
+
+// encodeEventMessages encodes a record as the JSON message of a CloudWatch event. A record
+// larger than MAX_EVENT_SIZE has its message truncated, or split over several events that
+// number the parts with the event_size_policy split.
+func encodeEventMessages(record *Record, policy string) ([]string, error) {
+
+	data, err := json.MarshalIndent(newTimestampedRecord(record), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= MAX_EVENT_SIZE {
+		return []string{string(data)}, nil
+	}
+
+	if policy != EVENT_SIZE_POLICY_SPLIT {
+		message, _, err := fitEventMessage(*record, record.Message, TRUNCATION_MARKER)
+		if err != nil {
+			return nil, err
+		}
+		return []string{message}, nil
+	}
+
+	// The number of parts is only known at the end, so they are fitted with a placeholder
+	// that encodes at least as long.
+	part := *record
+	part.Parts = MAX_BATCH_SIZE
+	lengths := []int{}
+	for remaining := record.Message; remaining != ""; {
+		part.Part = len(lengths) + 1
+		_, length, err := fitEventMessage(part, remaining, "")
+		if err != nil {
+			return nil, err
+		}
+		if length == 0 {
+			return nil, fmt.Errorf("no room for the message in a CloudWatch event of %d bytes", MAX_EVENT_SIZE)
+		}
+		lengths = append(lengths, length)
+		remaining = remaining[length:]
+	}
+
+	messages := make([]string, 0, len(lengths))
+	offset := 0
+	for index, length := range lengths {
+		part.Part = index + 1
+		part.Parts = len(lengths)
+		part.Message = record.Message[offset : offset+length]
+		offset += length
+		data, err := json.MarshalIndent(newTimestampedRecord(&part), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, string(data))
+	}
+	return messages, nil
+}
+
+// fitEventMessage encodes the record with the longest start of message, followed by suffix,
+// that fits in MAX_EVENT_SIZE. It returns the encoding and the length of the start used.
+func fitEventMessage(record Record, message string, suffix string) (string, int, error) {
+
+	length := len(message)
+	for {
+		start := truncateUTF8(message, length)
+		record.Message = start + suffix
+		data, err := json.MarshalIndent(newTimestampedRecord(&record), "", "  ")
+		if err != nil {
+			return "", 0, err
+		}
+		if len(data) <= MAX_EVENT_SIZE {
+			return string(data), len(start), nil
+		}
+		if start == "" {
+			return "", 0, fmt.Errorf("record without its message is %d bytes, more than the CloudWatch event limit of %d",
+				len(data)-len(suffix), MAX_EVENT_SIZE)
+		}
+		// Every byte removed from the message removes at least one byte of its encoding.
+		length = len(start) - (len(data) - MAX_EVENT_SIZE)
+		if length < 0 {
+			length = 0
+		}
+	}
+}
+
+func (repeater *CloudWatchJournalRepeater) putLogEvents(events []*cloudwatchlogs.InputLogEvent) error {
+
+	debug := repeater.config.Debug
+	logger := repeater.logger
+
 	putEvents := func() error {
 		request := &cloudwatchlogs.PutLogEventsInput{
 			LogEvents:     events,
@@ -87,7 +411,8 @@ func (repeater *CloudWatchJournalRepeater) WriteBatch(records []*Record) error {
 		return nil
 	}
 
-	getNextToken := func() error {
+	// lookupToken reads the sequence token of the stream without sending the events.
+	lookupToken := func() error {
 		limit := int64(1)
 		describeRequest := &cloudwatchlogs.DescribeLogStreamsInput{
 			LogGroupName:        &repeater.logGroupName,
@@ -107,15 +432,22 @@ func (repeater *CloudWatchJournalRepeater) WriteBatch(records []*Record) error {
 			if debug {
 				logger.Debug("Next Token ", repeater.nextSequenceToken)
 			}
-
-			err = putEvents()
-			if err != nil {
-				return fmt.Errorf("failed to put events after sequence lookup: : %s %v", err.Error(), err)
-			}
 			return nil
 		}
 
-		return errors.New("failed to put events after looking for next sequence")
+		return errors.New("no log stream found looking for next sequence")
+	}
+
+	getNextToken := func() error {
+		err := lookupToken()
+		if err != nil {
+			return err
+		}
+		err = putEvents()
+		if err != nil {
+			return fmt.Errorf("failed to put events after sequence lookup: : %s %v", err.Error(), err)
+		}
+		return nil
 	}
 
 	createStream := func() error {
@@ -142,7 +474,16 @@ func (repeater *CloudWatchJournalRepeater) WriteBatch(records []*Record) error {
 			LogGroupName: &repeater.logGroupName,
 		}
 		_, err := repeater.conn.CreateLogGroup(request)
-		return err
+		if err != nil {
+			return err
+		}
+
+		// Logs are still sent when the log group can not be configured.
+		err = repeater.configureLogGroup(&cloudwatchlogs.LogGroup{}, nil)
+		if err != nil {
+			logger.Errorf("Unable to configure log group %s : %s %v", repeater.logGroupName, err.Error(), err)
+		}
+		return nil
 	}
 
 	recoverResourceNotFound := func(awsErr awserr.Error) error {
@@ -176,8 +517,9 @@ func (repeater *CloudWatchJournalRepeater) WriteBatch(records []*Record) error {
 
 	}
 
+	// Only the token is looked up here, putting the events as well would send them twice.
 	if repeater.nextSequenceToken == "" {
-		getNextToken()
+		lookupToken()
 	}
 
 	var originalErr error
@@ -191,9 +533,9 @@ func (repeater *CloudWatchJournalRepeater) WriteBatch(records []*Record) error {
 					return err
 				}
 			} else if awsErr.Code() == "DataAlreadyAcceptedException" {
-				// This batch was already sent?
+				// The batch was already accepted, only the token is refreshed so it is not sent twice.
 				repeater.logger.Errorf("DataAlreadyAcceptedException from putEvents : %s %v", err.Error(), err)
-				err = getNextToken()
+				err = lookupToken()
 				if err != nil {
 					return fmt.Errorf("Next token failed after DataAlreadyAcceptedException :  %s %v", err.Error(), err)
 				}
@@ -207,6 +549,9 @@ func (repeater *CloudWatchJournalRepeater) WriteBatch(records []*Record) error {
 				repeater.logger.Errorf("Error from putEvents : %s %v", originalErr.Error(), originalErr)
 				return fmt.Errorf("failed to put events: : %s %v", originalErr.Error(), originalErr)
 			}
+		} else {
+			repeater.logger.Errorf("Error from putEvents : %s %v", originalErr.Error(), originalErr)
+			return fmt.Errorf("failed to put events: : %s %v", originalErr.Error(), originalErr)
 		}
 
 	} else {
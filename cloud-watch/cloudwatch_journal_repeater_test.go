@@ -1,6 +1,12 @@
 package cloud_watch
 
 import (
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
 	"strings"
 	"testing"
 	"time"
@@ -17,7 +23,10 @@ log_group="test-group"
 	`
 
 	config, _ := LoadConfigFromString(config_data, nil)
-	session := NewAWSSession(config)
+	session, err := NewAWSSession(config)
+	if err != nil {
+		t.Fatalf("Unable to create session %s", err)
+	}
 
 	repeater, err := NewCloudWatchJournalRepeater(session, nil, config)
 
@@ -32,8 +41,8 @@ log_group="test-group"
 	}
 
 	records := []*Record{
-		{Message: "Hello mom", TimeUsec: time.Now().Unix() * 1000},
-		{Message: "Hello dad", TimeUsec: time.Now().Unix() * 1000},
+		{Message: "Hello mom", TimeUsec: time.Now().UnixNano() / 1000},
+		{Message: "Hello dad", TimeUsec: time.Now().UnixNano() / 1000},
 	}
 	err = repeater.WriteBatch(records)
 
@@ -47,3 +56,341 @@ log_group="test-group"
 	}
 
 }
+
+// fakeCloudWatchLogs has no log group until CreateLogGroup is called and records the
+// configuration calls.
+type fakeCloudWatchLogs struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+	logGroup  *cloudwatchlogs.LogGroup
+	tags      map[string]*string
+	stream    bool
+	retention *cloudwatchlogs.PutRetentionPolicyInput
+	kms       *cloudwatchlogs.AssociateKmsKeyInput
+	tagged    *cloudwatchlogs.TagLogGroupInput
+	events    int
+	calls     int
+	token     string
+	// failCall fails that call of PutLogEvents once, with an error that is not an awserr.Error.
+	failCall int
+	// last is the message of the newest event in the stream.
+	last string
+}
+
+func notFound() error {
+	return awserr.New("ResourceNotFoundException", "The specified resource does not exist.", nil)
+}
+
+func (fake *fakeCloudWatchLogs) DescribeLogStreams(*cloudwatchlogs.DescribeLogStreamsInput) (*cloudwatchlogs.DescribeLogStreamsOutput, error) {
+	if fake.token == "" {
+		return nil, notFound()
+	}
+	return &cloudwatchlogs.DescribeLogStreamsOutput{
+		LogStreams: []*cloudwatchlogs.LogStream{{UploadSequenceToken: aws.String(fake.token)}},
+	}, nil
+}
+
+func (fake *fakeCloudWatchLogs) PutLogEvents(input *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	if !fake.stream {
+		return nil, notFound()
+	}
+	if fake.failCall == fake.calls+1 {
+		fake.failCall = 0
+		return nil, errors.New("connection reset by peer")
+	}
+	fake.events += len(input.LogEvents)
+	fake.calls++
+	fake.last = aws.StringValue(input.LogEvents[len(input.LogEvents)-1].Message)
+	return &cloudwatchlogs.PutLogEventsOutput{NextSequenceToken: aws.String("token")}, nil
+}
+
+func (fake *fakeCloudWatchLogs) GetLogEvents(*cloudwatchlogs.GetLogEventsInput) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	if !fake.stream {
+		return nil, notFound()
+	}
+	output := &cloudwatchlogs.GetLogEventsOutput{}
+	if fake.last != "" {
+		output.Events = []*cloudwatchlogs.OutputLogEvent{{Message: aws.String(fake.last)}}
+	}
+	return output, nil
+}
+
+func (fake *fakeCloudWatchLogs) CreateLogStream(*cloudwatchlogs.CreateLogStreamInput) (*cloudwatchlogs.CreateLogStreamOutput, error) {
+	if fake.logGroup == nil {
+		return nil, notFound()
+	}
+	fake.stream = true
+	return &cloudwatchlogs.CreateLogStreamOutput{}, nil
+}
+
+func (fake *fakeCloudWatchLogs) CreateLogGroup(input *cloudwatchlogs.CreateLogGroupInput) (*cloudwatchlogs.CreateLogGroupOutput, error) {
+	fake.logGroup = &cloudwatchlogs.LogGroup{LogGroupName: input.LogGroupName}
+	return &cloudwatchlogs.CreateLogGroupOutput{}, nil
+}
+
+func (fake *fakeCloudWatchLogs) DescribeLogGroups(*cloudwatchlogs.DescribeLogGroupsInput) (*cloudwatchlogs.DescribeLogGroupsOutput, error) {
+	output := &cloudwatchlogs.DescribeLogGroupsOutput{}
+	if fake.logGroup != nil {
+		output.LogGroups = []*cloudwatchlogs.LogGroup{fake.logGroup}
+	}
+	return output, nil
+}
+
+func (fake *fakeCloudWatchLogs) ListTagsLogGroup(*cloudwatchlogs.ListTagsLogGroupInput) (*cloudwatchlogs.ListTagsLogGroupOutput, error) {
+	return &cloudwatchlogs.ListTagsLogGroupOutput{Tags: fake.tags}, nil
+}
+
+func (fake *fakeCloudWatchLogs) PutRetentionPolicy(input *cloudwatchlogs.PutRetentionPolicyInput) (*cloudwatchlogs.PutRetentionPolicyOutput, error) {
+	fake.retention = input
+	return &cloudwatchlogs.PutRetentionPolicyOutput{}, nil
+}
+
+func (fake *fakeCloudWatchLogs) AssociateKmsKey(input *cloudwatchlogs.AssociateKmsKeyInput) (*cloudwatchlogs.AssociateKmsKeyOutput, error) {
+	fake.kms = input
+	return &cloudwatchlogs.AssociateKmsKeyOutput{}, nil
+}
+
+func (fake *fakeCloudWatchLogs) TagLogGroup(input *cloudwatchlogs.TagLogGroupInput) (*cloudwatchlogs.TagLogGroupOutput, error) {
+	fake.tagged = input
+	return &cloudwatchlogs.TagLogGroupOutput{}, nil
+}
+
+const logGroupConfig = `
+log_group="journal"
+log_stream="test-stream"
+retention_days=30
+kms_key_id="arn:aws:kms:us-west-2:123456789012:key/abc"
+log_group_tags {
+	team = "platform"
+	env = "prod"
+}
+`
+
+func TestLogGroupConfiguredOnCreate(t *testing.T) {
+
+	config, _ := LoadConfigFromString(logGroupConfig, nil)
+	fake := &fakeCloudWatchLogs{}
+
+	repeater, err := newCloudWatchJournalRepeater(fake, nil, config)
+	if err != nil {
+		t.Fatalf("Unable to create repeater %s", err)
+	}
+
+	err = repeater.WriteBatch([]*Record{{Message: "hello", TimeUsec: 1480459022025000}})
+	if err != nil {
+		t.Fatalf("Unable to write batch %s", err)
+	}
+
+	if fake.events != 1 {
+		t.Errorf("Expected the event to be sent after creating the log group, sent %d", fake.events)
+	}
+
+	if fake.retention == nil || *fake.retention.RetentionInDays != 30 {
+		t.Error("Retention not set on the new log group")
+	}
+
+	if fake.kms == nil || *fake.kms.KmsKeyId != config.KMSKeyId {
+		t.Error("KMS key not associated with the new log group")
+	}
+
+	if fake.tagged == nil || *fake.tagged.Tags["team"] != "platform" || *fake.tagged.Tags["env"] != "prod" {
+		t.Error("Tags not set on the new log group")
+	}
+}
+
+func TestLogGroupReconcile(t *testing.T) {
+
+	config, _ := LoadConfigFromString(logGroupConfig+`reconcile_log_group=true`, nil)
+	fake := &fakeCloudWatchLogs{
+		logGroup: &cloudwatchlogs.LogGroup{
+			LogGroupName:    aws.String("journal"),
+			RetentionInDays: aws.Int64(7),
+			KmsKeyId:        aws.String(config.KMSKeyId),
+		},
+		tags: map[string]*string{"team": aws.String("platform"), "env": aws.String("dev")},
+	}
+
+	_, err := newCloudWatchJournalRepeater(fake, nil, config)
+	if err != nil {
+		t.Fatalf("Unable to create repeater %s", err)
+	}
+
+	if fake.retention == nil || *fake.retention.RetentionInDays != 30 {
+		t.Error("Retention not reconciled")
+	}
+
+	if fake.kms != nil {
+		t.Error("KMS key already associated, should not be associated again")
+	}
+
+	if fake.tagged == nil || len(fake.tagged.Tags) != 1 || *fake.tagged.Tags["env"] != "prod" {
+		t.Errorf("Only the changed tag should be set %v", fake.tagged)
+	}
+}
+
+func TestInvalidRetentionDays(t *testing.T) {
+
+	config := loadUncheckedConfig(t, `retention_days=10`)
+	_, err := newCloudWatchJournalRepeater(&fakeCloudWatchLogs{}, nil, config)
+	if err == nil {
+		t.Error("Expected an error for retention_days=10")
+	}
+}
+
+func TestOversizedEvents(t *testing.T) {
+
+	record := &Record{Message: strings.Repeat("abcdefgh", 100*1024), TimeUsec: 1480459022025000, Hostname: "web-1"}
+
+	truncated, err := encodeEventMessages(record, EVENT_SIZE_POLICY_TRUNCATE)
+	if err != nil {
+		t.Fatalf("Unable to encode %s", err)
+	}
+	if len(truncated) != 1 || len(truncated[0]) > MAX_EVENT_SIZE || len(truncated[0]) < MAX_EVENT_SIZE-8 ||
+		!strings.Contains(truncated[0], TRUNCATION_MARKER+"\"") {
+		t.Errorf("Expected one truncated event, got %d of %d bytes", len(truncated), len(truncated[0]))
+	}
+
+	parts, err := encodeEventMessages(record, EVENT_SIZE_POLICY_SPLIT)
+	if err != nil {
+		t.Fatalf("Unable to encode %s", err)
+	}
+	if len(parts) != 4 {
+		t.Fatalf("Expected 4 parts, got %d", len(parts))
+	}
+	message := ""
+	for index, data := range parts {
+		part := Record{}
+		if err := json.Unmarshal([]byte(data), &part); err != nil {
+			t.Fatalf("Part %d is not JSON %s", index, err)
+		}
+		if len(data) > MAX_EVENT_SIZE || part.Part != index+1 || part.Parts != 4 || part.Hostname != "web-1" {
+			t.Errorf("Wrong part %d of %d bytes: %d of %d", index, len(data), part.Part, part.Parts)
+		}
+		message += part.Message
+	}
+	if message != record.Message {
+		t.Errorf("The parts do not add up to the message")
+	}
+
+	small, _ := encodeEventMessages(&Record{Message: "hello"}, EVENT_SIZE_POLICY_SPLIT)
+	if len(small) != 1 || strings.Contains(small[0], "part") {
+		t.Errorf("Expected small records to be sent as is %v", small)
+	}
+}
+
+func TestSplitEventsAreBatchedBySize(t *testing.T) {
+
+	config, _ := LoadConfigFromString(logGroupConfig+`event_size_policy="split"`, nil)
+	fake := &fakeCloudWatchLogs{logGroup: &cloudwatchlogs.LogGroup{}, stream: true}
+
+	repeater, err := newCloudWatchJournalRepeater(fake, nil, config)
+	if err != nil {
+		t.Fatalf("Unable to create repeater %s", err)
+	}
+
+	records := []*Record{
+		{Message: strings.Repeat("x", 600*1024), TimeUsec: 1480459022025000},
+		{Message: strings.Repeat("y", 600*1024), TimeUsec: 1480459022026000},
+	}
+	if err = repeater.WriteBatch(records); err != nil {
+		t.Fatalf("Unable to write batch %s", err)
+	}
+
+	// Each record is 3 events of up to 256 KB, at most 4 of which fit in a 1 MB call.
+	if fake.events != 6 || fake.calls != 2 {
+		t.Errorf("Expected 6 events in 2 calls, sent %d in %d", fake.events, fake.calls)
+	}
+}
+
+func TestFirstBatchIsSentOnce(t *testing.T) {
+
+	config, _ := LoadConfigFromString(logGroupConfig, nil)
+	fake := &fakeCloudWatchLogs{logGroup: &cloudwatchlogs.LogGroup{}, stream: true, token: "token"}
+
+	repeater, err := newCloudWatchJournalRepeater(fake, nil, config)
+	if err != nil {
+		t.Fatalf("Unable to create repeater %s", err)
+	}
+
+	if err = repeater.WriteBatch([]*Record{{Message: "hello", TimeUsec: 1480459022025000}}); err != nil {
+		t.Fatalf("Unable to write batch %s", err)
+	}
+
+	if fake.events != 1 || fake.calls != 1 {
+		t.Errorf("Expected the sequence token lookup not to send the batch, sent %d in %d calls", fake.events, fake.calls)
+	}
+}
+
+func TestRetryResumesAfterAcceptedEvents(t *testing.T) {
+
+	config, _ := LoadConfigFromString(logGroupConfig+`event_size_policy="split"`, nil)
+	fake := &fakeCloudWatchLogs{logGroup: &cloudwatchlogs.LogGroup{}, stream: true, failCall: 2}
+
+	repeater, err := newCloudWatchJournalRepeater(fake, nil, config)
+	if err != nil {
+		t.Fatalf("Unable to create repeater %s", err)
+	}
+
+	records := []*Record{
+		{Message: strings.Repeat("x", 600*1024), TimeUsec: 1480459022025000},
+		{Message: strings.Repeat("y", 600*1024), TimeUsec: 1480459022026000},
+	}
+	if err = repeater.WriteBatch(records); err == nil {
+		t.Fatal("Expected the failed call to fail the batch")
+	}
+	if fake.events != 4 {
+		t.Fatalf("Expected the events of the first call to be accepted, got %d", fake.events)
+	}
+
+	if err = repeater.WriteBatch(records); err != nil {
+		t.Fatalf("Unable to retry batch %s", err)
+	}
+	if fake.events != 6 || fake.calls != 2 {
+		t.Errorf("Expected the retry to send only the 2 remaining events, sent %d in %d calls", fake.events, fake.calls)
+	}
+}
+
+func TestResumeSkipsRecordsInStream(t *testing.T) {
+
+	config, _ := LoadConfigFromString(logGroupConfig+`state_file="/var/lib/journald-cloudwatch-logs/state"`, nil)
+	fake := &fakeCloudWatchLogs{logGroup: &cloudwatchlogs.LogGroup{}, stream: true, token: "token",
+		last: `{"message":"two","entryId":"boot-2"}`}
+
+	repeater, err := newCloudWatchJournalRepeater(fake, nil, config)
+	if err != nil {
+		t.Fatalf("Unable to create repeater %s", err)
+	}
+
+	// The batch of boot-1 and boot-2 was sent before a crash, but not checkpointed.
+	records := []*Record{
+		{Message: "one", EntryId: "boot-1", TimeUsec: 1480459022025000},
+		{Message: "two", EntryId: "boot-2", TimeUsec: 1480459022026000},
+		{Message: "three", EntryId: "boot-3", TimeUsec: 1480459022027000},
+	}
+	if err = repeater.WriteBatch(records); err != nil {
+		t.Fatalf("Unable to write batch %s", err)
+	}
+	if fake.events != 1 || !strings.Contains(fake.last, `"entryId": "boot-3"`) {
+		t.Errorf("Expected only the record after the last event of the stream, sent %d events", fake.events)
+	}
+
+	// Later batches are sent as they are.
+	if err = repeater.WriteBatch(records[:1]); err != nil || fake.events != 2 {
+		t.Errorf("Expected the next batch to be sent %d %v", fake.events, err)
+	}
+}
+
+func TestResumeResendsPartlySentRecord(t *testing.T) {
+
+	config, _ := LoadConfigFromString(logGroupConfig+`state_file="/var/lib/journald-cloudwatch-logs/state"`, nil)
+	fake := &fakeCloudWatchLogs{logGroup: &cloudwatchlogs.LogGroup{}, stream: true, token: "token",
+		last: `{"message":"tw","entryId":"boot-2","part":1,"parts":2}`}
+
+	repeater, _ := newCloudWatchJournalRepeater(fake, nil, config)
+	err := repeater.WriteBatch([]*Record{
+		{Message: "one", EntryId: "boot-1", TimeUsec: 1480459022025000},
+		{Message: "two", EntryId: "boot-2", TimeUsec: 1480459022026000},
+	})
+	if err != nil || fake.events != 1 || !strings.Contains(fake.last, `"entryId": "boot-2"`) {
+		t.Errorf("Expected the partly sent record to be sent again, sent %d events %v", fake.events, err)
+	}
+}
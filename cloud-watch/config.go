@@ -2,7 +2,6 @@ package cloud_watch
 
 import (
 	"github.com/hashicorp/hcl"
-	"io/ioutil"
 	lg "github.com/advantageous/go-logback/logging"
 )
 
@@ -13,24 +12,124 @@ type Config struct {
 	LogStreamName        string   `hcl:"log_stream"`
 	LogPriority          string   `hcl:"log_priority"`
 	JournalDir           string   `hcl:"journal_dir"`
+	JournalDirs          []string `hcl:"journal_dirs"`
+	JournalNamespaces    []string `hcl:"journal_namespaces"`
+	JournalReader        string   `hcl:"journal_reader"`
+	JournalInput         string   `hcl:"journal_input"`
+	RemoteListen         string   `hcl:"remote_listen"`
+	RemoteCertFile       string   `hcl:"remote_cert_file"`
+	RemoteKeyFile        string   `hcl:"remote_key_file"`
+	RemoteCAFile         string   `hcl:"remote_ca_file"`
 	QueueChannelSize     int      `hcl:"queue_channel_size"`
-	QueuePollDurationMS  uint64   `hcl:"queue_poll_duration_ms"`
-	FlushLogEntries      uint64   `hcl:"queue_flush_log_ms"`
+	QueuePollDurationMS  int      `hcl:"queue_poll_duration_ms"`
+	FlushLogEntries      int      `hcl:"queue_flush_log_ms"`
 	QueueBatchSize       int      `hcl:"queue_batch_size"`
 	CloudWatchBufferSize int      `hcl:"buffer_size"`
 	Debug                bool     `hcl:"debug"`
 	Tail                 bool     `hcl:"tail"`
 	Rewind               int      `hcl:"rewind"`
+	StartPosition        string   `hcl:"start_position"`
+	Since                string   `hcl:"since"`
+	StateFile            string   `hcl:"state_file"`
 	Local                bool     `hcl:"local"`
 	AllowedFields        []string `hcl:"fields"`
 	OmitFields           []string `hcl:"omit_fields"`
 	logPriority          int
 	fields               map[string]struct{}
 	omitFields           map[string]struct{}
-	FieldLength          int  `hcl:"field_length"`
-	MockCloudWatch       bool `hcl:"mock-cloud-watch"`
+	FieldLength          int    `hcl:"field_length"`
+	MessageLength        int    `hcl:"message_length"`
+	EventSizePolicy      string `hcl:"event_size_policy"`
+	MockCloudWatch       bool   `hcl:"mock-cloud-watch"`
+	RepeaterType         string `hcl:"repeater"`
+	DryRun               bool   `hcl:"dry_run"`
+
+	MetadataProvider        string `hcl:"metadata_provider"`
+	MetadataEndpoint        string `hcl:"metadata_endpoint"`
+	MetadataFile            string `hcl:"metadata_file"`
+	MetadataTimeoutMS       int    `hcl:"metadata_timeout_ms"`
+	MetadataTokenTTLSeconds int    `hcl:"metadata_token_ttl_seconds"`
+	MetadataRetries         int    `hcl:"metadata_retries"`
+	MetadataRetryBackoffMS  int    `hcl:"metadata_retry_backoff_ms"`
+
+	AWSProfile           string `hcl:"aws_profile"`
+	AWSCredentialsFile   string `hcl:"aws_credentials_file"`
+	RoleArn              string `hcl:"role_arn"`
+	RoleExternalId       string `hcl:"role_external_id"`
+	RoleSessionName      string `hcl:"role_session_name"`
+	WebIdentityTokenFile string `hcl:"web_identity_token_file"`
+	STSEndpoint          string `hcl:"sts_endpoint"`
+	CloudWatchEndpoint   string `hcl:"cloudwatch_endpoint"`
+
+	RetentionDays     int               `hcl:"retention_days"`
+	KMSKeyId          string            `hcl:"kms_key_id"`
+	LogGroupTags      map[string]string `hcl:"log_group_tags"`
+	ReconcileLogGroup bool              `hcl:"reconcile_log_group"`
+
+	EnrichFields         []string `hcl:"enrich_fields"`
+	EnrichRefreshSeconds int      `hcl:"enrich_refresh_seconds"`
+
+	ElasticSearchURL            string `hcl:"elasticsearch_url"`
+	ElasticSearchIndex          string `hcl:"elasticsearch_index"`
+	ElasticSearchUsername       string `hcl:"elasticsearch_username"`
+	ElasticSearchPassword       string `hcl:"elasticsearch_password"`
+	ElasticSearchSignRequests   bool   `hcl:"elasticsearch_sign_requests"`
+	ElasticSearchService        string `hcl:"elasticsearch_service"`
+	ElasticSearchMaxRetries     int    `hcl:"elasticsearch_max_retries"`
+	ElasticSearchRetryBackoffMS int    `hcl:"elasticsearch_retry_backoff_ms"`
+	ElasticSearchTimeoutMS      int    `hcl:"elasticsearch_timeout_ms"`
+
+	LokiURL       string   `hcl:"loki_url"`
+	LokiLabels    []string `hcl:"loki_labels"`
+	LokiFormat    string   `hcl:"loki_format"`
+	LokiTenantId  string   `hcl:"loki_tenant_id"`
+	LokiTimeoutMS int      `hcl:"loki_timeout_ms"`
+
+	SyslogAddress            string   `hcl:"syslog_address"`
+	SyslogProtocol           string   `hcl:"syslog_protocol"`
+	SyslogFraming            string   `hcl:"syslog_framing"`
+	SyslogCAFile             string   `hcl:"syslog_ca_file"`
+	SyslogTlsServerName      string   `hcl:"syslog_tls_server_name"`
+	SyslogStructuredData     []string `hcl:"syslog_structured_data"`
+	SyslogStructuredDataId   string   `hcl:"syslog_structured_data_id"`
+	SyslogMaxRetries         int      `hcl:"syslog_max_retries"`
+	SyslogReconnectBackoffMS int      `hcl:"syslog_reconnect_backoff_ms"`
+	SyslogTimeoutMS          int      `hcl:"syslog_timeout_ms"`
+
+	KafkaBrokers       []string `hcl:"kafka_brokers"`
+	KafkaTopic         string   `hcl:"kafka_topic"`
+	KafkaKey           string   `hcl:"kafka_key"`
+	KafkaAcks          string   `hcl:"kafka_acks"`
+	KafkaCompression   string   `hcl:"kafka_compression"`
+	KafkaIdempotent    bool     `hcl:"kafka_idempotent"`
+	KafkaVersion       string   `hcl:"kafka_version"`
+	KafkaClientId      string   `hcl:"kafka_client_id"`
+	KafkaMaxRetries    int      `hcl:"kafka_max_retries"`
+	KafkaSaslMechanism string   `hcl:"kafka_sasl_mechanism"`
+	KafkaSaslUsername  string   `hcl:"kafka_sasl_username"`
+	KafkaSaslPassword  string   `hcl:"kafka_sasl_password"`
+	KafkaTls           bool     `hcl:"kafka_tls"`
+	KafkaCAFile        string   `hcl:"kafka_ca_file"`
 }
 
+const (
+	REPEATER_CLOUDWATCH    = "cloudwatch"
+	REPEATER_MOCK          = "mock"
+	REPEATER_ELASTICSEARCH = "elasticsearch"
+	REPEATER_LOKI          = "loki"
+	REPEATER_SYSLOG        = "syslog"
+	REPEATER_KAFKA         = "kafka"
+	REPEATER_STDOUT        = "stdout"
+)
+
+const (
+	START_POSITION_HEAD          = "head"
+	START_POSITION_TAIL          = "tail"
+	START_POSITION_SINCE         = "since"
+	START_POSITION_CURRENT_BOOT  = "current_boot"
+	START_POSITION_PREVIOUS_BOOT = "previous_boot"
+)
+
 func (config *Config) GetJournalDLogPriority() Priority {
 
 	logLevels := map[Priority][]string{
@@ -87,6 +186,7 @@ func arrayToMap(array []string) map[string]struct{} {
 	return theMap
 }
 
+// LoadConfigFromString loads and validates a config from a string.
 func LoadConfigFromString(data string, logger lg.Logger) (*Config, error) {
 
 	if logger == nil {
@@ -95,10 +195,26 @@ func LoadConfigFromString(data string, logger lg.Logger) (*Config, error) {
 	config := &Config{}
 
 	logger.Debug("Loading log...")
-	err := hcl.Decode(&config, data)
+	problems, locations, valid, err := checkConfigSource("config", data)
+	if err != nil {
+		return nil, err
+	}
+	err = hcl.DecodeObject(&config, valid)
 	if err != nil {
 		return nil, err
 	}
+
+	problems = append(problems, checkConfigValues(config, locations)...)
+	if len(problems) > 0 {
+		return nil, &ConfigError{Problems: problems}
+	}
+	config.applyDefaults(logger)
+	return config, nil
+}
+
+// applyDefaults sets every key that was not configured to its default.
+func (config *Config) applyDefaults(logger lg.Logger) {
+
 	config.fields = arrayToMap(config.AllowedFields)
 	config.omitFields = arrayToMap(config.OmitFields)
 
@@ -132,27 +248,172 @@ func LoadConfigFromString(data string, logger lg.Logger) (*Config, error) {
 		config.FieldLength = 255
 	}
 
+	if config.MessageLength == 0 {
+		config.MessageLength = 64 * 1024
+	}
+
+	if config.EventSizePolicy == "" {
+		config.EventSizePolicy = EVENT_SIZE_POLICY_TRUNCATE
+	}
+
 	if config.LogPriority == "" {
 		logger.Debug("Loading log... LogPriority not set, setting to debug")
 		config.LogPriority = "debug"
 	}
 
-	if config.Tail {
+	if config.RepeaterType == "" {
+		if config.MockCloudWatch {
+			config.RepeaterType = REPEATER_MOCK
+		} else {
+			config.RepeaterType = REPEATER_CLOUDWATCH
+		}
+	}
+
+	if config.MetadataProvider == "" {
+		config.MetadataProvider = METADATA_PROVIDER_IMDS
+	}
+
+	if config.MetadataEndpoint == "" && config.MetadataProvider == METADATA_PROVIDER_IMDS {
+		config.MetadataEndpoint = "http://169.254.169.254"
+	}
+
+	if config.MetadataTimeoutMS == 0 {
+		config.MetadataTimeoutMS = 1000
+	}
+
+	if config.MetadataTokenTTLSeconds == 0 {
+		config.MetadataTokenTTLSeconds = 21600
+	}
+
+	if config.MetadataRetries == 0 {
+		config.MetadataRetries = 5
+	}
+
+	if config.MetadataRetryBackoffMS == 0 {
+		config.MetadataRetryBackoffMS = 200
+	}
+
+	if config.RoleSessionName == "" {
+		config.RoleSessionName = "systemd-cloud-watch"
+	}
+
+	if config.EnrichRefreshSeconds == 0 {
+		config.EnrichRefreshSeconds = 900
+	}
+
+	if config.ElasticSearchIndex == "" {
+		config.ElasticSearchIndex = "journal-{date:2006.01.02}"
+	}
+
+	if config.ElasticSearchService == "" {
+		config.ElasticSearchService = "es"
+	}
+
+	if config.ElasticSearchMaxRetries == 0 {
+		config.ElasticSearchMaxRetries = 3
+	}
+
+	if config.ElasticSearchRetryBackoffMS == 0 {
+		config.ElasticSearchRetryBackoffMS = 200
+	}
+
+	if config.ElasticSearchTimeoutMS == 0 {
+		config.ElasticSearchTimeoutMS = 30000
+	}
+
+	if len(config.LokiLabels) == 0 {
+		config.LokiLabels = []string{"unit", "hostname", "priority"}
+	}
+
+	if config.LokiFormat == "" {
+		config.LokiFormat = "json"
+	}
+
+	if config.LokiTimeoutMS == 0 {
+		config.LokiTimeoutMS = 30000
+	}
+
+	if config.SyslogProtocol == "" {
+		config.SyslogProtocol = "udp"
+	}
+
+	if config.SyslogFraming == "" {
+		config.SyslogFraming = "octet-counting"
+	}
+
+	if config.SyslogStructuredDataId == "" {
+		config.SyslogStructuredDataId = "journal@32473"
+	}
+
+	if config.SyslogMaxRetries == 0 {
+		config.SyslogMaxRetries = 5
+	}
+
+	if config.SyslogReconnectBackoffMS == 0 {
+		config.SyslogReconnectBackoffMS = 500
+	}
+
+	if config.SyslogTimeoutMS == 0 {
+		config.SyslogTimeoutMS = 10000
+	}
+
+	if config.KafkaTopic == "" {
+		config.KafkaTopic = "journal"
+	}
+
+	if config.KafkaAcks == "" {
+		config.KafkaAcks = "all"
+	}
+
+	if config.KafkaCompression == "" {
+		config.KafkaCompression = "none"
+	}
+
+	if config.KafkaVersion == "" {
+		config.KafkaVersion = "1.0.0"
+	}
+
+	if config.KafkaClientId == "" {
+		config.KafkaClientId = "systemd-cloud-watch"
+	}
+
+	if config.KafkaMaxRetries == 0 {
+		config.KafkaMaxRetries = 3
+	}
+
+	if config.JournalReader == "" {
+		config.JournalReader = JOURNAL_READER_SDJOURNAL
+	}
+
+	if config.JournalReader == JOURNAL_READER_EXPORT && config.JournalInput == "" {
+		config.JournalInput = JOURNAL_INPUT_STDIN
+	}
+
+	if config.JournalReader == JOURNAL_READER_REMOTE && config.RemoteListen == "" {
+		config.RemoteListen = ":19532"
+	}
+
+	if config.StartPosition == "" {
+		switch {
+		case config.Tail:
+			config.StartPosition = START_POSITION_TAIL
+		case config.Since != "":
+			config.StartPosition = START_POSITION_SINCE
+		default:
+			config.StartPosition = START_POSITION_HEAD
+		}
+	}
+
+	if config.StartPosition == START_POSITION_TAIL {
 		if config.Rewind == 0 {
 			logger.Debug("Loading log... Rewind not set, but Tail is so setting to 10")
 			config.Rewind = 10
 		}
 	}
 
-	return config, nil
-
 }
-func LoadConfig(filename string, logger lg.Logger) (*Config, error) {
-	logger.Printf("Loading config %s", filename)
 
-	configBytes, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, err
-	}
-	return LoadConfigFromString(string(configBytes), logger)
+// LoadConfig loads and validates a config file.
+func LoadConfig(filename string, logger lg.Logger) (*Config, error) {
+	return LoadConfigWithOverrides(filename, nil, nil, logger)
 }
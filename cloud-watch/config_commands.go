@@ -0,0 +1,134 @@
+package cloud_watch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	CONFIG_FORMAT_HCL  = "hcl"
+	CONFIG_FORMAT_JSON = "json"
+)
+
+// secretValue hides passwords when the config is printed.
+const secretValue = "********"
+
+func isSecretKey(key string) bool {
+	return strings.HasSuffix(key, "password")
+}
+
+// PrintConfig writes every config key with its resolved value, defaults included, as HCL
+// that can be used as a config file or as JSON. Passwords are masked.
+func PrintConfig(config *Config, format string, out io.Writer) error {
+
+	switch format {
+	case CONFIG_FORMAT_HCL:
+		_, err := out.Write(configHCL(config))
+		return err
+	case CONFIG_FORMAT_JSON:
+		values := map[string]interface{}{}
+		for _, key := range configKeys() {
+			field, _ := configField(config, key)
+			if isSecretKey(key) && field.String() != "" {
+				values[key] = secretValue
+			} else {
+				values[key] = field.Interface()
+			}
+		}
+		data, err := json.MarshalIndent(values, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(append(data, '\n'))
+		return err
+	default:
+		return fmt.Errorf("config format must be %s or %s, not %s", CONFIG_FORMAT_HCL, CONFIG_FORMAT_JSON, format)
+	}
+}
+
+func configHCL(config *Config) []byte {
+
+	var out bytes.Buffer
+
+	for _, key := range configKeys() {
+		field, _ := configField(config, key)
+
+		switch field.Kind() {
+		case reflect.String:
+			value := field.String()
+			if isSecretKey(key) && value != "" {
+				value = secretValue
+			}
+			fmt.Fprintf(&out, "%s = %s\n", key, strconv.Quote(value))
+		case reflect.Slice:
+			items := make([]string, field.Len())
+			for i := range items {
+				items[i] = strconv.Quote(field.Index(i).String())
+			}
+			fmt.Fprintf(&out, "%s = [%s]\n", key, strings.Join(items, ", "))
+		case reflect.Map:
+			names := make([]string, 0, field.Len())
+			for _, name := range field.MapKeys() {
+				names = append(names, name.String())
+			}
+			sort.Strings(names)
+			fmt.Fprintf(&out, "%s {\n", key)
+			for _, name := range names {
+				value := field.MapIndex(reflect.ValueOf(name)).String()
+				fmt.Fprintf(&out, "  %s = %s\n", strconv.Quote(name), strconv.Quote(value))
+			}
+			out.WriteString("}\n")
+		default:
+			fmt.Fprintf(&out, "%s = %v\n", key, field.Interface())
+		}
+	}
+	return out.Bytes()
+}
+
+// CheckAWSAccess checks that the credentials can read the log group with DescribeLogStreams.
+// A log group that does not exist is not an error, it is created on the first write.
+func CheckAWSAccess(config *Config) (string, error) {
+
+	session, err := NewAWSSession(config)
+	if err != nil {
+		return "", err
+	}
+
+	awsConfig := aws.NewConfig()
+	if config.CloudWatchEndpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(config.CloudWatchEndpoint)
+	}
+	return checkLogGroupAccess(cloudwatchlogs.New(session, awsConfig), config)
+}
+
+func checkLogGroupAccess(conn cloudwatchlogsiface.CloudWatchLogsAPI, config *Config) (string, error) {
+
+	if config.LogGroupName == "" {
+		return "", fmt.Errorf("log_group is not set")
+	}
+
+	_, err := conn.DescribeLogStreams(&cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName:        aws.String(config.LogGroupName),
+		LogStreamNamePrefix: aws.String(config.LogStreamName),
+		Limit:               aws.Int64(1),
+	})
+
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "ResourceNotFoundException" {
+		return fmt.Sprintf("log group %s does not exist yet, it is created on the first write "+
+			"(needs logs:CreateLogGroup)", config.LogGroupName), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("DescribeLogStreams on %s failed: %s %v", config.LogGroupName, err.Error(), err)
+	}
+	return fmt.Sprintf("log group %s is readable", config.LogGroupName), nil
+}
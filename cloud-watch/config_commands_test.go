@@ -0,0 +1,77 @@
+package cloud_watch
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPrintConfigRoundTrip(t *testing.T) {
+
+	config, _ := LoadConfigFromString(`
+log_group="journal"
+fields=["MESSAGE", "_PID"]
+log_group_tags { team = "platform" }
+elasticsearch_password="secret"
+`, nil)
+
+	var out bytes.Buffer
+	err := PrintConfig(config, CONFIG_FORMAT_HCL, &out)
+	if err != nil {
+		t.Fatalf("Unable to print config %s", err)
+	}
+
+	if strings.Contains(out.String(), "secret") {
+		t.Error("Password should be masked")
+	}
+
+	if !strings.Contains(out.String(), "queue_batch_size = 10000\n") {
+		t.Errorf("Defaults should be printed\n%s", out.String())
+	}
+
+	printed, err := loadConfigFile(t, out.String(), nil)
+	if err != nil {
+		t.Fatalf("Printed config should be a valid config %s", err)
+	}
+
+	if printed.LogGroupName != "journal" || len(printed.AllowedFields) != 2 ||
+		printed.LogGroupTags["team"] != "platform" || printed.RepeaterType != REPEATER_CLOUDWATCH {
+		t.Errorf("Printed config does not match %v", printed)
+	}
+}
+
+func TestPrintConfigJSON(t *testing.T) {
+
+	config, _ := LoadConfigFromString(`log_group="journal"`, nil)
+
+	var out bytes.Buffer
+	err := PrintConfig(config, CONFIG_FORMAT_JSON, &out)
+	if err != nil {
+		t.Fatalf("Unable to print config %s", err)
+	}
+
+	values := map[string]interface{}{}
+	err = json.Unmarshal(out.Bytes(), &values)
+	if err != nil {
+		t.Fatalf("Invalid JSON %s", err)
+	}
+
+	if values["log_group"] != "journal" || values["buffer_size"] != float64(50) {
+		t.Errorf("Unexpected values %v", values)
+	}
+
+	if PrintConfig(config, "yaml", &out) == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestCheckLogGroupAccess(t *testing.T) {
+
+	config, _ := LoadConfigFromString(`log_group="journal"`, nil)
+
+	result, err := checkLogGroupAccess(&fakeCloudWatchLogs{}, config)
+	if err != nil || !strings.Contains(result, "does not exist yet") {
+		t.Errorf("Missing log group should not be an error %s %v", result, err)
+	}
+}
@@ -0,0 +1,219 @@
+package cloud_watch
+
+import (
+	"flag"
+	"fmt"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	lg "github.com/advantageous/go-logback/logging"
+)
+
+const CONFIG_ENV_PREFIX = "SCW_"
+
+// ConfigOverrides holds config values by key, as set on the command line.
+type ConfigOverrides map[string]string
+
+// configField finds the Config field for an hcl key.
+func configField(config *Config, key string) (reflect.Value, bool) {
+
+	value := reflect.ValueOf(config).Elem()
+	valueType := value.Type()
+
+	for i := 0; i < valueType.NumField(); i++ {
+		if valueType.Field(i).Tag.Get("hcl") == key {
+			return value.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// configKeys lists every hcl key of Config in declaration order.
+func configKeys() []string {
+
+	configType := reflect.TypeOf(Config{})
+	keys := make([]string, 0, configType.NumField())
+
+	for i := 0; i < configType.NumField(); i++ {
+		if key := configType.Field(i).Tag.Get("hcl"); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// ConfigEnvName is the environment variable for a config key, log_group is SCW_LOG_GROUP.
+func ConfigEnvName(key string) string {
+	return CONFIG_ENV_PREFIX + strings.ToUpper(strings.Replace(key, "-", "_", -1))
+}
+
+// setConfigValue parses a flag or environment value into a config field. Lists are comma
+// separated and maps are comma separated key=value pairs.
+func setConfigValue(field reflect.Value, value string) error {
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		boolValue, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(boolValue)
+	case reflect.Int, reflect.Int64:
+		intValue, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(intValue)
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		items := map[string]string{}
+		for _, item := range strings.Split(value, ",") {
+			if strings.TrimSpace(item) == "" {
+				continue
+			}
+			pair := strings.SplitN(item, "=", 2)
+			if len(pair) != 2 {
+				return fmt.Errorf("expected key=value not %q", item)
+			}
+			items[strings.TrimSpace(pair[0])] = strings.TrimSpace(pair[1])
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Kind())
+	}
+	return nil
+}
+
+// configFlag is a command line flag for a config key. Values are checked when the flag is
+// parsed and applied by LoadConfigWithOverrides.
+type configFlag struct {
+	key       string
+	isBool    bool
+	overrides ConfigOverrides
+}
+
+func (configFlag *configFlag) String() string {
+	return ""
+}
+
+func (configFlag *configFlag) Set(value string) error {
+	field, _ := configField(&Config{}, configFlag.key)
+	err := setConfigValue(reflect.New(field.Type()).Elem(), value)
+	if err != nil {
+		return err
+	}
+	configFlag.overrides[configFlag.key] = value
+	return nil
+}
+
+func (configFlag *configFlag) IsBoolFlag() bool {
+	return configFlag.isBool
+}
+
+// RegisterConfigFlags adds a flag for every config key to flags, named like the key,
+// e.g. -log_group=my-group. The returned overrides are filled in when flags are parsed.
+func RegisterConfigFlags(flags *flag.FlagSet) ConfigOverrides {
+
+	overrides := ConfigOverrides{}
+	for _, key := range configKeys() {
+		if flags.Lookup(key) != nil {
+			continue
+		}
+		field, _ := configField(&Config{}, key)
+		flags.Var(&configFlag{key: key, isBool: field.Kind() == reflect.Bool, overrides: overrides}, key,
+			fmt.Sprintf("config key %s, environment %s", key, ConfigEnvName(key)))
+	}
+	return overrides
+}
+
+// LoadConfigWithOverrides loads the config file, which may be empty to run without one, then
+// applies SCW_* variables from environ (os.Environ() format) and then the command line
+// overrides. The precedence is flag, environment, file and then default.
+func LoadConfigWithOverrides(filename string, environ []string, overrides ConfigOverrides, logger lg.Logger) (*Config, error) {
+
+	if logger == nil {
+		logger = lg.NewSimpleLogger("SYSTEMD_CONFIG_DEBUG")
+	}
+	config := &Config{}
+	problems := []string{}
+	locations := map[string]string{}
+
+	if filename != "" {
+		logger.Printf("Loading config %s", filename)
+		configBytes, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		var valid *ast.ObjectList
+		problems, locations, valid, err = checkConfigSource(filename, string(configBytes))
+		if err != nil {
+			return nil, err
+		}
+
+		// Only settings without problems are decoded so the value checks below still run.
+		err = hcl.DecodeObject(&config, valid)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		logger.Info("No config file, using environment and flags")
+	}
+
+	env := map[string]string{}
+	for _, variable := range environ {
+		pair := strings.SplitN(variable, "=", 2)
+		if len(pair) == 2 && strings.HasPrefix(pair[0], CONFIG_ENV_PREFIX) {
+			env[pair[0]] = pair[1]
+		}
+	}
+
+	known := map[string]bool{}
+	for _, key := range configKeys() {
+		known[ConfigEnvName(key)] = true
+	}
+	for _, variable := range environ {
+		name := strings.SplitN(variable, "=", 2)[0]
+		if strings.HasPrefix(name, CONFIG_ENV_PREFIX) && !known[name] {
+			problems = append(problems, unknownKeyProblem(name, strings.ToLower(strings.TrimPrefix(name, CONFIG_ENV_PREFIX))))
+		}
+	}
+
+	for _, key := range configKeys() {
+		value, found := overrides[key]
+		source := "flag -" + key
+		if !found {
+			value, found = env[ConfigEnvName(key)]
+			source = ConfigEnvName(key)
+		}
+		if !found {
+			continue
+		}
+		locations[key] = source
+		field, _ := configField(config, key)
+		err := setConfigValue(field, value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: invalid value for %s: %s", source, key, err.Error()))
+		}
+	}
+
+	problems = append(problems, checkConfigValues(config, locations)...)
+	if len(problems) > 0 {
+		return nil, &ConfigError{Problems: problems}
+	}
+
+	config.applyDefaults(logger)
+	return config, nil
+}
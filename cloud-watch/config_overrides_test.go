@@ -0,0 +1,98 @@
+package cloud_watch
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestConfigOverridePrecedence(t *testing.T) {
+
+	file, _ := ioutil.TempFile("", "config")
+	defer os.Remove(file.Name())
+	file.WriteString(`
+log_group="from-file"
+log_stream="from-file"
+buffer_size=20
+`)
+	file.Close()
+
+	environ := []string{
+		"SCW_LOG_STREAM=from-env",
+		"SCW_BUFFER_SIZE=30",
+		"SCW_FIELDS=MESSAGE, _PID",
+		"SCW_LOG_GROUP_TAGS=team=platform,env=prod",
+		"PATH=/usr/bin",
+	}
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	overrides := RegisterConfigFlags(flags)
+	err := flags.Parse([]string{"-buffer_size=40", "-debug", "-mock-cloud-watch"})
+	if err != nil {
+		t.Fatalf("Unable to parse flags %s", err)
+	}
+
+	config, err := LoadConfigWithOverrides(file.Name(), environ, overrides, nil)
+	if err != nil {
+		t.Fatalf("Unable to load config %s", err)
+	}
+
+	if config.LogGroupName != "from-file" {
+		t.Errorf("File value should be kept without an override %s", config.LogGroupName)
+	}
+
+	if config.LogStreamName != "from-env" {
+		t.Errorf("Environment should override file %s", config.LogStreamName)
+	}
+
+	if config.CloudWatchBufferSize != 40 {
+		t.Errorf("Flag should override environment %d", config.CloudWatchBufferSize)
+	}
+
+	if !config.Debug || config.RepeaterType != REPEATER_MOCK {
+		t.Error("Bool flags not applied before defaults")
+	}
+
+	if len(config.AllowedFields) != 2 || !config.AllowField("_PID") {
+		t.Errorf("List not read from environment %v", config.AllowedFields)
+	}
+
+	if config.LogGroupTags["env"] != "prod" {
+		t.Errorf("Map not read from environment %v", config.LogGroupTags)
+	}
+
+	if config.QueueBatchSize != 10000 {
+		t.Error("Defaults should still apply")
+	}
+}
+
+func TestConfigWithoutFile(t *testing.T) {
+
+	config, err := LoadConfigWithOverrides("", []string{"SCW_LOG_GROUP=env-only", "SCW_LOCAL=true"}, nil, nil)
+	if err != nil {
+		t.Fatalf("Unable to load config %s", err)
+	}
+
+	if config.LogGroupName != "env-only" || !config.Local {
+		t.Errorf("Config not read from environment %s %v", config.LogGroupName, config.Local)
+	}
+
+	_, err = LoadConfigWithOverrides("", []string{"SCW_BUFFER_SIZE=lots"}, nil, nil)
+	if err == nil {
+		t.Error("Expected an error for a bad number")
+	}
+
+	_, err = LoadConfigWithOverrides("", []string{"SCW_LOG_GRUOP=typo", "PATH=/bin"}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "SCW_LOG_GRUOP: unknown key log_gruop, did you mean log_group?") {
+		t.Errorf("Expected an unknown environment variable error %v", err)
+	}
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	RegisterConfigFlags(flags)
+	if flags.Parse([]string{"-queue_batch_size=lots"}) == nil {
+		t.Error("Expected a flag error for a bad number")
+	}
+}
@@ -1,6 +1,8 @@
 package cloud_watch
 
 import (
+	"github.com/hashicorp/hcl"
+	"strings"
 	"testing"
 	lg "github.com/advantageous/go-logback/logging"
 )
@@ -60,3 +62,29 @@ func TestLogOmitField(t *testing.T) {
 	}
 
 }
+
+// loadUncheckedConfig decodes a config without checking it, for tests of values the config
+// checks reject.
+func loadUncheckedConfig(t *testing.T, data string) *Config {
+	config := &Config{}
+	if err := hcl.Decode(&config, data); err != nil {
+		t.Fatalf("Unable to decode config %s", err)
+	}
+	config.applyDefaults(lg.NewSimpleLogger("test"))
+	return config
+}
+
+func TestLoadConfigFromStringChecksConfig(t *testing.T) {
+
+	_, err := LoadConfigFromString(`log_group="journal"
+batchSize=100
+buffer_size=20000`, nil)
+
+	configErr, ok := err.(*ConfigError)
+	if !ok || len(configErr.Problems) != 2 {
+		t.Fatalf("Expected the unknown key and the buffer_size to be reported, got %v", err)
+	}
+	if !strings.Contains(configErr.Problems[0], "config:2: unknown key batchSize") {
+		t.Errorf("Unexpected problem %s", configErr.Problems[0])
+	}
+}
@@ -0,0 +1,360 @@
+package cloud_watch
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/token"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// MAX_BATCH_SIZE is the most events PutLogEvents accepts in one call.
+const MAX_BATCH_SIZE = 10000
+
+// ConfigError lists every problem found in a config so they can all be fixed at once.
+type ConfigError struct {
+	Problems []string
+}
+
+func (err *ConfigError) Error() string {
+	return fmt.Sprintf("invalid config:\n  %s", strings.Join(err.Problems, "\n  "))
+}
+
+// numberKeys are string keys that also accept a number.
+var numberKeys = map[string]bool{"log_priority": true}
+
+// checkConfigSource checks the keys and value types of a config file. It returns the
+// problems found, the line of every key so later checks can point at it and the settings
+// without problems, which can be decoded.
+func checkConfigSource(filename string, data string) ([]string, map[string]string, *ast.ObjectList, error) {
+
+	file, err := hcl.Parse(data)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	problems := []string{}
+	locations := map[string]string{}
+	valid := &ast.ObjectList{}
+
+	list, ok := file.Node.(*ast.ObjectList)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("%s: expected a list of key = value settings", filename)
+	}
+
+	for _, item := range list.Items {
+
+		key := objectKeyName(item.Keys[0])
+		location := fmt.Sprintf("%s:%d", filename, item.Keys[0].Pos().Line)
+
+		field, found := configField(&Config{}, key)
+		if !found {
+			problems = append(problems, unknownKeyProblem(location, key))
+			continue
+		}
+
+		if previous, duplicate := locations[key]; duplicate {
+			problems = append(problems, fmt.Sprintf("%s: %s is already set at %s", location, key, previous))
+			continue
+		}
+		locations[key] = location
+
+		if len(item.Keys) > 1 && field.Kind() != reflect.Map {
+			problems = append(problems, fmt.Sprintf("%s: %s is not a block", location, key))
+			continue
+		}
+
+		if problem := checkConfigType(key, field.Kind(), item.Val); problem != "" {
+			problems = append(problems, fmt.Sprintf("%s: %s", location, problem))
+			continue
+		}
+		valid.Add(item)
+	}
+
+	return problems, locations, valid, nil
+}
+
+func objectKeyName(key *ast.ObjectKey) string {
+	if key.Token.Type == token.STRING {
+		if value, ok := key.Token.Value().(string); ok {
+			return value
+		}
+	}
+	return key.Token.Text
+}
+
+func literalType(node ast.Node) (token.Type, bool) {
+	literal, ok := node.(*ast.LiteralType)
+	if !ok {
+		return token.ILLEGAL, false
+	}
+	if literal.Token.Type == token.HEREDOC {
+		return token.STRING, true
+	}
+	return literal.Token.Type, true
+}
+
+func checkConfigType(key string, kind reflect.Kind, node ast.Node) string {
+
+	valueType, isLiteral := literalType(node)
+
+	switch kind {
+	case reflect.String:
+		if valueType == token.STRING || (valueType == token.NUMBER && numberKeys[key]) {
+			return ""
+		}
+		return fmt.Sprintf("%s must be a string, e.g. %s=\"value\"", key, key)
+	case reflect.Bool:
+		if valueType == token.BOOL {
+			return ""
+		}
+		return fmt.Sprintf("%s must be true or false", key)
+	case reflect.Int, reflect.Int64:
+		if valueType == token.NUMBER {
+			return ""
+		}
+		return fmt.Sprintf("%s must be a whole number", key)
+	case reflect.Slice:
+		list, ok := node.(*ast.ListType)
+		if ok {
+			for _, element := range list.List {
+				if elementType, _ := literalType(element); elementType != token.STRING {
+					return fmt.Sprintf("%s must be a list of strings, e.g. %s=[\"a\", \"b\"]", key, key)
+				}
+			}
+			return ""
+		}
+		if isLiteral && valueType == token.STRING {
+			return fmt.Sprintf("%s must be a list, e.g. %s=[\"a\"]", key, key)
+		}
+		return fmt.Sprintf("%s must be a list of strings", key)
+	case reflect.Map:
+		object, ok := node.(*ast.ObjectType)
+		if !ok {
+			return fmt.Sprintf("%s must be a block, e.g. %s { name = \"value\" }", key, key)
+		}
+		for _, item := range object.List.Items {
+			if elementType, _ := literalType(item.Val); elementType != token.STRING {
+				return fmt.Sprintf("%s.%s must be a string", key, objectKeyName(item.Keys[0]))
+			}
+		}
+		return ""
+	}
+	return ""
+}
+
+// unknownKeyProblem reports an unknown key with the closest keys as suggestions.
+func unknownKeyProblem(location string, key string) string {
+	problem := fmt.Sprintf("%s: unknown key %s", location, key)
+	if suggestions := suggestConfigKeys(key); len(suggestions) > 0 {
+		problem += ", did you mean " + strings.Join(suggestions, " or ") + "?"
+	}
+	return problem
+}
+
+// suggestConfigKeys returns the keys closest to an unknown key, ignoring case, dashes and
+// underscores, so batchSize suggests queue_batch_size.
+func suggestConfigKeys(unknown string) []string {
+
+	normalize := func(key string) string {
+		return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+	}
+	wanted := normalize(unknown)
+
+	type suggestion struct {
+		key      string
+		distance int
+	}
+	suggestions := []suggestion{}
+
+	for _, key := range configKeys() {
+		candidate := normalize(key)
+		distance := editDistance(wanted, candidate)
+		if distance <= len(wanted)/3 || strings.Contains(candidate, wanted) {
+			suggestions = append(suggestions, suggestion{key, distance})
+		}
+	}
+
+	// Insertion sort keeps keys with the same distance in declaration order.
+	for i := 1; i < len(suggestions); i++ {
+		for j := i; j > 0 && suggestions[j].distance < suggestions[j-1].distance; j-- {
+			suggestions[j], suggestions[j-1] = suggestions[j-1], suggestions[j]
+		}
+	}
+
+	keys := []string{}
+	for i := 0; i < len(suggestions) && i < 3; i++ {
+		keys = append(keys, suggestions[i].key)
+	}
+	return keys
+}
+
+func editDistance(a string, b string) int {
+
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// checkConfigValues checks the ranges and combinations of the values that are set, before
+// defaults are applied. locations says where each key was set for the messages.
+func checkConfigValues(config *Config, locations map[string]string) []string {
+
+	problems := []string{}
+	problem := func(key string, format string, args ...interface{}) {
+		location := locations[key]
+		if location == "" {
+			location = key
+		}
+		problems = append(problems, location+": "+fmt.Sprintf(format, args...))
+	}
+
+	for _, key := range []string{"buffer_size", "queue_batch_size"} {
+		field, _ := configField(config, key)
+		if size := field.Int(); size < 0 || size > MAX_BATCH_SIZE {
+			problem(key, "%s must be between 1 and %d, not %d", key, MAX_BATCH_SIZE, size)
+		}
+	}
+
+	for _, key := range []string{"queue_channel_size", "queue_poll_duration_ms", "queue_flush_log_ms", "field_length", "message_length", "rewind", "metadata_retries",
+		"metadata_timeout_ms", "retention_days", "enrich_refresh_seconds"} {
+		field, _ := configField(config, key)
+		if field.Int() < 0 {
+			problem(key, "%s can not be negative", key)
+		}
+	}
+
+	if config.LogPriority != "" && !validLogPriority(config.LogPriority) {
+		problem("log_priority", "log_priority must be 0-7 or one of emerg, alert, crit, err, warning, notice, info, debug, not %q",
+			config.LogPriority)
+	}
+
+	if len(config.AllowedFields) > 0 && len(config.OmitFields) > 0 {
+		problem("omit_fields", "fields and omit_fields can not both be set, use fields to list what is sent or omit_fields to list what is not")
+	}
+
+	if config.RetentionDays != 0 && !validRetentionDays(config.RetentionDays) {
+		problem("retention_days", "retention_days must be one of %v, not %d", retentionDays, config.RetentionDays)
+	}
+
+	positions := []string{START_POSITION_HEAD, START_POSITION_TAIL, START_POSITION_SINCE, START_POSITION_CURRENT_BOOT,
+		START_POSITION_PREVIOUS_BOOT}
+	if config.StartPosition != "" && !containsString(positions, config.StartPosition) {
+		problem("start_position", "start_position must be one of %s, not %q", strings.Join(positions, ", "),
+			config.StartPosition)
+	}
+
+	if config.Since != "" {
+		if _, err := ParseTimeSpec(config.Since, time.Now()); err != nil {
+			problem("since", "%s", err.Error())
+		}
+		if config.StartPosition != "" && config.StartPosition != START_POSITION_SINCE {
+			problem("since", "since only applies to start_position = \"since\", not %q", config.StartPosition)
+		}
+	} else if config.StartPosition == START_POSITION_SINCE {
+		problem("start_position", "start_position = \"since\" needs since, e.g. since = \"1h\"")
+	}
+
+	if config.Tail && config.StartPosition != "" && config.StartPosition != START_POSITION_TAIL {
+		problem("tail", "tail = true conflicts with start_position = %q", config.StartPosition)
+	}
+
+	readers := []string{JOURNAL_READER_SDJOURNAL, JOURNAL_READER_NATIVE, JOURNAL_READER_EXPORT, JOURNAL_READER_REMOTE}
+	if config.JournalReader != "" && !containsString(readers, config.JournalReader) {
+		problem("journal_reader", "journal_reader must be one of %s, not %q", strings.Join(readers, ", "),
+			config.JournalReader)
+	}
+
+	if config.JournalReader == JOURNAL_READER_EXPORT {
+		if len(config.JournalDirs) > 0 || len(config.JournalNamespaces) > 0 {
+			problem("journal_reader", "journal_reader = \"export\" reads journal_input only, not journal_dirs or journal_namespaces")
+		}
+	} else if config.JournalInput != "" {
+		problem("journal_input", "journal_input only applies to journal_reader = \"export\"")
+	}
+
+	if config.JournalReader == JOURNAL_READER_REMOTE {
+		if len(config.JournalDirs) > 0 || len(config.JournalNamespaces) > 0 {
+			problem("journal_reader", "journal_reader = \"remote\" receives uploads only, not journal_dirs or journal_namespaces")
+		}
+		if (config.RemoteCertFile == "") != (config.RemoteKeyFile == "") {
+			problem("remote_cert_file", "remote_cert_file and remote_key_file must be set together")
+		}
+		if config.RemoteCAFile != "" && config.RemoteCertFile == "" {
+			problem("remote_ca_file", "remote_ca_file needs HTTPS, set remote_cert_file and remote_key_file")
+		}
+	} else {
+		for _, key := range []string{"remote_listen", "remote_cert_file", "remote_key_file", "remote_ca_file"} {
+			if field, _ := configField(config, key); field.String() != "" {
+				problem(key, "%s only applies to journal_reader = \"remote\"", key)
+			}
+		}
+	}
+
+	policies := []string{EVENT_SIZE_POLICY_TRUNCATE, EVENT_SIZE_POLICY_SPLIT}
+	if config.EventSizePolicy != "" && !containsString(policies, config.EventSizePolicy) {
+		problem("event_size_policy", "event_size_policy must be one of %s, not %q", strings.Join(policies, ", "),
+			config.EventSizePolicy)
+	}
+
+	repeaters := []string{REPEATER_CLOUDWATCH, REPEATER_MOCK, REPEATER_ELASTICSEARCH, REPEATER_LOKI,
+		REPEATER_SYSLOG, REPEATER_KAFKA, REPEATER_STDOUT}
+	if config.RepeaterType != "" && !containsString(repeaters, config.RepeaterType) {
+		problem("repeater", "repeater must be one of %s, not %q", strings.Join(repeaters, ", "), config.RepeaterType)
+	}
+
+	if config.KafkaIdempotent && config.KafkaAcks != "" && config.KafkaAcks != "all" {
+		problem("kafka_idempotent", "kafka_idempotent = true needs kafka_acks = \"all\", not %q", config.KafkaAcks)
+	}
+
+	providers := []string{METADATA_PROVIDER_IMDS, METADATA_PROVIDER_ECS, METADATA_PROVIDER_FILE}
+	if config.MetadataProvider != "" && !containsString(providers, config.MetadataProvider) {
+		problem("metadata_provider", "metadata_provider must be one of %s, not %q", strings.Join(providers, ", "),
+			config.MetadataProvider)
+	}
+
+	return problems
+}
+
+func validLogPriority(priority string) bool {
+	for _, level := range []string{"0", "1", "2", "3", "4", "5", "6", "7",
+		"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"} {
+		if priority == level {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package cloud_watch
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func loadConfigFile(t *testing.T, data string, environ []string) (*Config, error) {
+	file, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatalf("Unable to create config file %s", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(data)
+	file.Close()
+	return LoadConfigWithOverrides(file.Name(), environ, nil, nil)
+}
+
+func TestConfigValidationReportsAllProblems(t *testing.T) {
+
+	_, err := loadConfigFile(t, `log_group="test"
+batchSize=5
+debug="yes"
+buffer_size=20000
+fields=["MESSAGE"]
+omit_fields=["_PID"]
+log_priority="error"
+log_group_tags { team = 1 }
+`, []string{"SCW_RETENTION_DAYS=10"})
+
+	configErr, ok := err.(*ConfigError)
+	if !ok {
+		t.Fatalf("Expected a ConfigError got %v", err)
+	}
+
+	expected := []string{
+		":2: unknown key batchSize, did you mean queue_batch_size?",
+		":3: debug must be true or false",
+		":4: buffer_size must be between 1 and 10000, not 20000",
+		":6: fields and omit_fields can not both be set",
+		":7: log_priority must be 0-7",
+		":8: log_group_tags.team must be a string",
+		"SCW_RETENTION_DAYS: retention_days must be one of",
+	}
+
+	message := configErr.Error()
+	for _, problem := range expected {
+		if !strings.Contains(message, problem) {
+			t.Errorf("Expected %q in\n%s", problem, message)
+		}
+	}
+
+	if len(configErr.Problems) != len(expected) {
+		t.Errorf("Expected %d problems got %d\n%s", len(expected), len(configErr.Problems), message)
+	}
+}
+
+func TestConfigValidationStartPosition(t *testing.T) {
+
+	for data, expected := range map[string]string{
+		`start_position="oldest"`:                    ":1: start_position must be one of head, tail, since",
+		`start_position="since"`:                     ":1: start_position = \"since\" needs since",
+		`since="yesterday"`:                          ":1: invalid time \"yesterday\"",
+		"start_position=\"tail\"\nsince=\"1h\"":      ":2: since only applies to start_position = \"since\"",
+		"tail=true\nstart_position=\"current_boot\"": ":1: tail = true conflicts with start_position = \"current_boot\"",
+	} {
+		_, err := loadConfigFile(t, data, nil)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q for %s got %v", expected, data, err)
+		}
+	}
+
+	config, err := loadConfigFile(t, `since="1h"`, nil)
+	if err != nil {
+		t.Fatalf("Unable to load config %s", err)
+	}
+	if config.StartPosition != START_POSITION_SINCE {
+		t.Errorf("since did not set start_position %s", config.StartPosition)
+	}
+}
+
+func TestConfigValidationJournalReaders(t *testing.T) {
+
+	for data, expected := range map[string]string{
+		`journal_input="entries.export"`:                           ":1: journal_input only applies to journal_reader = \"export\"",
+		"journal_reader=\"export\"\njournal_dirs=[\"/var/log/x\"]": ":1: journal_reader = \"export\" reads journal_input only",
+		`remote_listen=":19532"`:                                   ":1: remote_listen only applies to journal_reader = \"remote\"",
+		"journal_reader=\"remote\"\nremote_cert_file=\"a.pem\"":    ":2: remote_cert_file and remote_key_file must be set together",
+	} {
+		_, err := loadConfigFile(t, data, nil)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q for %s got %v", expected, data, err)
+		}
+	}
+
+	config, err := loadConfigFile(t, `journal_reader="export"`, nil)
+	if err != nil {
+		t.Fatalf("Unable to load config %s", err)
+	}
+	if config.JournalInput != JOURNAL_INPUT_STDIN {
+		t.Errorf("journal_input did not default to stdin %s", config.JournalInput)
+	}
+}
+
+func TestConfigValidationKafkaIdempotent(t *testing.T) {
+
+	_, err := loadConfigFile(t, "kafka_idempotent=true\nkafka_acks=\"local\"", nil)
+	if err == nil || !strings.Contains(err.Error(), ":1: kafka_idempotent = true needs kafka_acks = \"all\", not \"local\"") {
+		t.Errorf("Expected kafka_acks to conflict with kafka_idempotent got %v", err)
+	}
+
+	if _, err = loadConfigFile(t, `kafka_idempotent=true`, nil); err != nil {
+		t.Errorf("Expected kafka_idempotent to use the default kafka_acks %s", err)
+	}
+}
+
+func TestConfigValidationAcceptsSample(t *testing.T) {
+
+	data, err := ioutil.ReadFile("../samples/sample.conf")
+	if err != nil {
+		t.Fatalf("Unable to read sample %s", err)
+	}
+
+	config, err := loadConfigFile(t, string(data), nil)
+	if err != nil {
+		t.Fatalf("Sample config should be valid %s", err)
+	}
+
+	if config.LogPriority != "7" || config.CloudWatchBufferSize != 5 {
+		t.Errorf("Sample config not read %s %d", config.LogPriority, config.CloudWatchBufferSize)
+	}
+}
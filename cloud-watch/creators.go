@@ -1,47 +1,180 @@
 package cloud_watch
 
-import 	lg "github.com/advantageous/go-logback/logging"
+import (
+	"fmt"
+	awsSession "github.com/aws/aws-sdk-go/aws/session"
+	"os"
+	lg "github.com/advantageous/go-logback/logging"
+)
 
-func CreateConfig(configFilename string, logger lg.Logger) *Config {
+// CreateConfig loads the config file, which may be empty, with SCW_* environment variables
+// and command line overrides applied.
+func CreateConfig(configFilename string, overrides ConfigOverrides, logger lg.Logger) (*Config, error) {
 
-	config, err := LoadConfig(configFilename, logger)
+	config, err := LoadConfigWithOverrides(configFilename, os.Environ(), overrides, logger)
 	if err != nil {
 		logger.Error("Unable to load config", err, configFilename)
-		panic("Unable to create config")
+		return nil, fmt.Errorf("unable to create config: %s %v", err.Error(), err)
 	}
-	return config
+	return config, nil
 }
 
-func CreateJournal(config *Config, logger lg.Logger) Journal {
+// openJournal opens the journal, or a MultiJournal when journal_dirs or journal_namespaces
+// add more sources.
+func openJournal(config *Config) (Journal, error) {
+	if len(config.JournalDirs) == 0 && len(config.JournalNamespaces) == 0 {
+		return newSourceJournal(config)
+	}
+	return NewMultiJournal(config)
+}
+
+// newSourceJournal opens the system journal or journal_dir with the journal_reader, journal_input
+// for the export reader or starts listening for uploads for the remote reader.
+func newSourceJournal(config *Config) (Journal, error) {
+	switch config.JournalReader {
+	case JOURNAL_READER_NATIVE:
+		return NewNativeJournal(config)
+	case JOURNAL_READER_EXPORT:
+		return NewExportJournal(config)
+	case JOURNAL_READER_REMOTE:
+		return NewRemoteJournal(config)
+	}
+	return NewJournal(config)
+}
 
-	journal, err := NewJournal(config)
+func CreateJournal(config *Config, logger lg.Logger) (Journal, error) {
+
+	journal, err := openJournal(config)
 	if err != nil {
 		logger.Error("Unable to load journal", err)
-		panic("Unable to create journal")
+		return nil, fmt.Errorf("unable to create journal: %s %v", err.Error(), err)
 	}
 	journal.AddLogFilters(config)
-	return journal
+	return journal, nil
+
+}
 
+// CreateBackfillJournal opens the journal for Backfill with the boot and unit filters of the
+// options added to the priority filters of the config.
+func CreateBackfillJournal(config *Config, options BackfillOptions, logger lg.Logger) (Journal, error) {
+
+	journal, err := openJournal(config)
+	if err != nil {
+		logger.Error("Unable to load journal", err)
+		return nil, fmt.Errorf("unable to create journal: %s %v", err.Error(), err)
+	}
+	err = addBackfillMatches(journal, options)
+	if err != nil {
+		journal.Close()
+		return nil, err
+	}
+	journal.AddLogFilters(config)
+	return journal, nil
 }
 
-func CreateRepeater(config *Config, logger lg.Logger) JournalRepeater {
+func CreateRepeater(config *Config, logger lg.Logger) (JournalRepeater, error) {
 
 	var repeater JournalRepeater
+	var session *awsSession.Session
 	var err error
 
-	if !config.MockCloudWatch {
-		logger.Info("Creating repeater that is conneting to AWS cloud watch")
-		session := NewAWSSession(config)
-		repeater, err = NewCloudWatchJournalRepeater(session, nil, config)
+	if config.DryRun && config.RepeaterType != REPEATER_CLOUDWATCH {
+		logger.Warn("dry_run only applies to the cloudwatch repeater, using ", config.RepeaterType)
+	}
 
-	} else {
+	switch config.RepeaterType {
+	case REPEATER_MOCK:
 		logger.Warn("Creating MOCK repeater")
 		repeater = NewMockJournalRepeater()
+	case REPEATER_STDOUT:
+		logger.Info("Creating repeater that writes JSON lines to stdout")
+		repeater = NewStdoutJournalRepeater()
+	case REPEATER_ELASTICSEARCH:
+		logger.Info("Creating repeater that is connecting to elasticsearch ", config.ElasticSearchURL)
+		if config.ElasticSearchSignRequests {
+			session, err = NewAWSSession(config)
+			if err != nil {
+				return nil, err
+			}
+		}
+		repeater, err = NewElasticSearchJournalRepeater(session, nil, config)
+	case REPEATER_LOKI:
+		logger.Info("Creating repeater that is connecting to loki ", config.LokiURL)
+		repeater, err = NewLokiJournalRepeater(nil, config)
+	case REPEATER_SYSLOG:
+		logger.Info("Creating repeater that is connecting to syslog ", config.SyslogAddress)
+		repeater, err = NewSyslogJournalRepeater(nil, config)
+	case REPEATER_KAFKA:
+		logger.Info("Creating repeater that is connecting to kafka ", config.KafkaBrokers)
+		repeater, err = NewKafkaJournalRepeater(nil, config)
+	default:
+		logger.Info("Creating repeater that is conneting to AWS cloud watch")
+		session, err = NewAWSSession(config)
+		if err != nil {
+			return nil, err
+		}
+		if config.DryRun {
+			logger.Warn("Dry run, CloudWatch batches are printed to stdout and not sent")
+			repeater, err = newCloudWatchJournalRepeater(NewDryRunCloudWatchLogs(os.Stdout), nil, config)
+		} else {
+			repeater, err = NewCloudWatchJournalRepeater(session, nil, config)
+		}
 	}
 
 	if err != nil {
-		panic("Unable to create repeater " + err.Error())
+		return nil, fmt.Errorf("unable to create repeater: %s %v", err.Error(), err)
+	}
+
+	if len(config.EnrichFields) > 0 {
+		repeater, err = createEnrichingRepeater(repeater, session, config, logger)
+		if err != nil {
+			return nil, err
+		}
 	}
-	return repeater
+	return repeater, nil
 
 }
+
+// CreateCheckpointRepeater checkpoints the batches the repeater sent in state_file, so the
+// runner resumes after them. Without state_file, or for a dry run, the repeater is returned.
+func CreateCheckpointRepeater(repeater JournalRepeater, config *Config, logger lg.Logger) (JournalRepeater, error) {
+
+	if config.StateFile == "" {
+		return repeater, nil
+	}
+	if config.DryRun && config.RepeaterType == REPEATER_CLOUDWATCH {
+		logger.Warn("Dry run, batches are not checkpointed in ", config.StateFile)
+		return repeater, nil
+	}
+
+	checkpoint, err := NewCheckpointJournalRepeater(repeater, nil, config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create checkpoint repeater: %s %v", err.Error(), err)
+	}
+	logger.Info("Checkpointing sent entries for ", checkpoint.Destination(), " in ", config.StateFile)
+	return checkpoint, nil
+}
+
+func createEnrichingRepeater(repeater JournalRepeater, session *awsSession.Session,
+	config *Config, logger lg.Logger) (JournalRepeater, error) {
+
+	logger.Info("Enriching records with ", config.EnrichFields)
+
+	if config.Local {
+		return NewEnrichingJournalRepeater(repeater, nil, nil, nil, config), nil
+	}
+
+	var err error
+	if session == nil {
+		session, err = NewAWSSession(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	provider, err := NewInstanceMetadataProvider(config)
+	if err != nil {
+		return nil, err
+	}
+	return NewEnrichingJournalRepeater(repeater, provider, session, nil, config), nil
+}
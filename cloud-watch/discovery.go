@@ -0,0 +1,93 @@
+package cloud_watch
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	METADATA_REGION            = "region"
+	METADATA_INSTANCE_ID       = "instance-id"
+	METADATA_AVAILABILITY_ZONE = "placement/availability-zone"
+	METADATA_LOCAL_IPV4        = "local-ipv4"
+	METADATA_PUBLIC_IPV4       = "public-ipv4"
+)
+
+// MetadataError reports an instance metadata value that could not be discovered and
+// had no fallback in the config.
+type MetadataError struct {
+	Field string
+	Err   error
+}
+
+func (err *MetadataError) Error() string {
+	return fmt.Sprintf("unable to discover %s from instance metadata: %s", err.Field, err.Err.Error())
+}
+
+// metadataDiscovery reads instance metadata, retrying every lookup with exponential backoff
+// so a slow metadata service at boot does not fail the agent.
+type metadataDiscovery struct {
+	provider InstanceMetadataProvider
+	retries  int
+	backoff  time.Duration
+}
+
+func newMetadataDiscovery(provider InstanceMetadataProvider, config *Config) *metadataDiscovery {
+	return &metadataDiscovery{
+		provider: provider,
+		retries:  config.MetadataRetries,
+		backoff:  time.Duration(config.MetadataRetryBackoffMS) * time.Millisecond,
+	}
+}
+
+func (discovery *metadataDiscovery) retry(field string, fetch func() (string, error)) (string, error) {
+
+	backoff := discovery.backoff
+	var err error
+	var value string
+
+	for attempt := 0; attempt <= discovery.retries; attempt++ {
+		if attempt > 0 {
+			awsLogger.Warnf("Retrying %s lookup in %s, attempt %d : %s", field, backoff, attempt, err.Error())
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		value, err = fetch()
+		if err == nil {
+			return value, nil
+		}
+	}
+
+	return "", &MetadataError{Field: field, Err: err}
+}
+
+// lookup returns the metadata value, or the fallback when the metadata service keeps failing.
+func (discovery *metadataDiscovery) lookup(field string, fallback string) (string, error) {
+
+	var fetch func() (string, error)
+
+	switch field {
+	case METADATA_REGION:
+		fetch = discovery.provider.Region
+	case METADATA_INSTANCE_ID:
+		fetch = discovery.provider.InstanceId
+	case METADATA_AVAILABILITY_ZONE:
+		fetch = discovery.provider.AvailabilityZone
+	case METADATA_LOCAL_IPV4:
+		fetch = discovery.provider.LocalIPv4
+	case METADATA_PUBLIC_IPV4:
+		fetch = discovery.provider.PublicIPv4
+	default:
+		return "", fmt.Errorf("unknown metadata field %s", field)
+	}
+
+	value, err := discovery.retry(field, fetch)
+	if err != nil {
+		if fallback != "" {
+			awsLogger.Warnf("%s, using %s from config", err.Error(), fallback)
+			return fallback, nil
+		}
+		return "", err
+	}
+	return value, nil
+}
@@ -0,0 +1,57 @@
+package cloud_watch
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetadataDiscoveryRetries(t *testing.T) {
+
+	imds := newFakeIMDS()
+	imds.failures = 2
+	server := httptest.NewServer(imds)
+	defer server.Close()
+
+	config, _ := LoadConfigFromString(`
+metadata_endpoint="`+server.URL+`"
+metadata_retry_backoff_ms=1
+`, nil)
+	discovery := newMetadataDiscovery(NewIMDSMetadataProvider(config), config)
+
+	value, err := discovery.lookup(METADATA_INSTANCE_ID, "")
+	if err != nil {
+		t.Fatalf("Lookup should succeed after retries %s", err)
+	}
+	if value != "i-0123456789" {
+		t.Errorf("Unexpected instance id %s", value)
+	}
+}
+
+func TestMetadataDiscoveryFallback(t *testing.T) {
+
+	imds := newFakeIMDS()
+	imds.unavailable = true
+	server := httptest.NewServer(imds)
+	defer server.Close()
+
+	config, _ := LoadConfigFromString(`
+metadata_endpoint="`+server.URL+`"
+metadata_retries=1
+metadata_retry_backoff_ms=1
+`, nil)
+	discovery := newMetadataDiscovery(NewIMDSMetadataProvider(config), config)
+
+	value, err := discovery.lookup(METADATA_AVAILABILITY_ZONE, "us-west-2a")
+	if err != nil || value != "us-west-2a" {
+		t.Errorf("Expected config fallback got %s %v", value, err)
+	}
+
+	_, err = discovery.lookup(METADATA_LOCAL_IPV4, "")
+	metadataErr, ok := err.(*MetadataError)
+	if !ok {
+		t.Fatalf("Expected a MetadataError got %v", err)
+	}
+	if metadataErr.Field != METADATA_LOCAL_IPV4 {
+		t.Errorf("Wrong field %s", metadataErr.Field)
+	}
+}
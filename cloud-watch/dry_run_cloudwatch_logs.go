@@ -0,0 +1,69 @@
+package cloud_watch
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"io"
+	"strconv"
+)
+
+// DryRunCloudWatchLogs stands in for the CloudWatch Logs client in dry_run mode. Every
+// PutLogEvents batch is printed instead of sent, Close prints a summary. Log groups and
+// streams are never looked up or created.
+type DryRunCloudWatchLogs struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+	out     io.Writer
+	batches int
+	events  int
+	bytes   int
+}
+
+func NewDryRunCloudWatchLogs(out io.Writer) *DryRunCloudWatchLogs {
+	return &DryRunCloudWatchLogs{out: out}
+}
+
+func (dryRun *DryRunCloudWatchLogs) PutLogEvents(input *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
+
+	size := 0
+	for _, event := range input.LogEvents {
+		size += len(aws.StringValue(event.Message)) + EVENT_OVERHEAD
+	}
+
+	dryRun.batches++
+	dryRun.events += len(input.LogEvents)
+	dryRun.bytes += size
+
+	fmt.Fprintf(dryRun.out, "--- dry run batch %d: group=%s stream=%s events=%d bytes=%d\n", dryRun.batches,
+		aws.StringValue(input.LogGroupName), aws.StringValue(input.LogStreamName), len(input.LogEvents), size)
+	if count := eventBatchCount(input.LogEvents); count < len(input.LogEvents) {
+		fmt.Fprintf(dryRun.out, "--- dry run batch %d is over the PutLogEvents limits, only %d events fit\n",
+			dryRun.batches, count)
+	}
+	for _, event := range input.LogEvents {
+		fmt.Fprintf(dryRun.out, "%d %s\n", aws.Int64Value(event.Timestamp), aws.StringValue(event.Message))
+	}
+
+	return &cloudwatchlogs.PutLogEventsOutput{NextSequenceToken: aws.String(strconv.Itoa(dryRun.batches))}, nil
+}
+
+// DescribeLogStreams finds no stream so the repeater sends without a sequence token.
+func (dryRun *DryRunCloudWatchLogs) DescribeLogStreams(*cloudwatchlogs.DescribeLogStreamsInput) (*cloudwatchlogs.DescribeLogStreamsOutput, error) {
+	return &cloudwatchlogs.DescribeLogStreamsOutput{}, nil
+}
+
+// GetLogEvents finds no events, nothing was sent before.
+func (dryRun *DryRunCloudWatchLogs) GetLogEvents(*cloudwatchlogs.GetLogEventsInput) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	return &cloudwatchlogs.GetLogEventsOutput{}, nil
+}
+
+func (dryRun *DryRunCloudWatchLogs) DescribeLogGroups(*cloudwatchlogs.DescribeLogGroupsInput) (*cloudwatchlogs.DescribeLogGroupsOutput, error) {
+	return &cloudwatchlogs.DescribeLogGroupsOutput{}, nil
+}
+
+func (dryRun *DryRunCloudWatchLogs) Close() error {
+	_, err := fmt.Fprintf(dryRun.out, "--- dry run summary: batches=%d events=%d bytes=%d, nothing was sent\n",
+		dryRun.batches, dryRun.events, dryRun.bytes)
+	return err
+}
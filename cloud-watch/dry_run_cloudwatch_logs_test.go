@@ -0,0 +1,76 @@
+package cloud_watch
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"strings"
+	"testing"
+)
+
+func TestDryRunPrintsBatches(t *testing.T) {
+
+	config, _ := LoadConfigFromString(`
+log_group="journal"
+log_stream="test-stream"
+fields=["MESSAGE", "_HOSTNAME"]
+dry_run=true
+`, nil)
+
+	var out bytes.Buffer
+	repeater, err := newCloudWatchJournalRepeater(NewDryRunCloudWatchLogs(&out), nil, config)
+	if err != nil {
+		t.Fatalf("Unable to create repeater %s", err)
+	}
+
+	record, err := NewRecord(NewJournalWithMap(readTestMap), nil, config)
+	if err != nil {
+		t.Fatalf("Unable to read record %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = repeater.WriteBatch([]*Record{record, record})
+		if err != nil {
+			t.Fatalf("Dry run should not fail %s", err)
+		}
+	}
+	repeater.Close()
+
+	output := out.String()
+
+	if strings.Count(output, "group=journal stream=test-stream events=2") != 2 {
+		t.Errorf("Expected two batches of two events\n%s", output)
+	}
+
+	if !strings.Contains(output, `"message": "Journal started"`) || !strings.Contains(output, `"hostname": "f5076731cfdb"`) {
+		t.Errorf("Encoded messages not printed\n%s", output)
+	}
+
+	if strings.Contains(output, `"pid"`) {
+		t.Errorf("Fields not selected should not be printed\n%s", output)
+	}
+
+	if !strings.Contains(output, "dry run summary: batches=2 events=4") {
+		t.Errorf("Summary not printed\n%s", output)
+	}
+}
+
+func TestDryRunReportsOversizedBatch(t *testing.T) {
+
+	var out bytes.Buffer
+	dryRun := NewDryRunCloudWatchLogs(&out)
+
+	message := strings.Repeat("x", 1000)
+	events := []*cloudwatchlogs.InputLogEvent{}
+	for len(events)*(len(message)+EVENT_OVERHEAD) <= MAX_BATCH_BYTES {
+		events = append(events, &cloudwatchlogs.InputLogEvent{Message: aws.String(message), Timestamp: aws.Int64(1)})
+	}
+	dryRun.PutLogEvents(&cloudwatchlogs.PutLogEventsInput{LogEvents: events})
+
+	size := len(events) * (len(message) + EVENT_OVERHEAD)
+	if !strings.Contains(out.String(), fmt.Sprintf("events=%d bytes=%d\n", len(events), size)) ||
+		!strings.Contains(out.String(), fmt.Sprintf("only %d events fit", len(events)-1)) {
+		t.Errorf("Expected the batch size and the PutLogEvents limit to be reported\n%.200s", out.String())
+	}
+}
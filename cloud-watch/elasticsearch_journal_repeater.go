@@ -0,0 +1,254 @@
+package cloud_watch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	lg "github.com/advantageous/go-logback/logging"
+	awsSession "github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ElasticSearchJournalRepeater writes records to Elasticsearch or OpenSearch with the _bulk API.
+type ElasticSearchJournalRepeater struct {
+	client        *http.Client
+	bulkURL       string
+	indexTemplate string
+	username      string
+	password      string
+	signer        *v4.Signer
+	region        string
+	service       string
+	maxRetries    int
+	retryBackoff  time.Duration
+	logger        lg.Logger
+	config        *Config
+}
+
+type elasticSearchBulkResponse struct {
+	Errors bool                                     `json:"errors"`
+	Items  []map[string]elasticSearchBulkItemResult `json:"items"`
+}
+
+type elasticSearchBulkItemResult struct {
+	Index  string          `json:"_index"`
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error,omitempty"`
+}
+
+// NewElasticSearchJournalRepeater creates a repeater for the cluster at elasticsearch_url.
+// The session is only used when elasticsearch_sign_requests is set, it may be nil otherwise.
+func NewElasticSearchJournalRepeater(sess *awsSession.Session, logger lg.Logger, config *Config) (*ElasticSearchJournalRepeater, error) {
+
+	if config.ElasticSearchURL == "" {
+		return nil, errors.New("elasticsearch_url must be set to use the elasticsearch repeater")
+	}
+
+	if logger == nil {
+		if !config.Debug {
+			logger = lg.GetSimpleLogger("ELASTICSEARCH_REPEATER_DEBUG", "elasticsearch-repeater")
+		} else {
+			logger = lg.NewSimpleDebugLogger("elasticsearch-repeater")
+		}
+	}
+
+	repeater := &ElasticSearchJournalRepeater{
+		client:        &http.Client{Timeout: time.Duration(config.ElasticSearchTimeoutMS) * time.Millisecond},
+		bulkURL:       strings.TrimRight(config.ElasticSearchURL, "/") + "/_bulk",
+		indexTemplate: config.ElasticSearchIndex,
+		username:      config.ElasticSearchUsername,
+		password:      config.ElasticSearchPassword,
+		service:       config.ElasticSearchService,
+		maxRetries:    config.ElasticSearchMaxRetries,
+		retryBackoff:  time.Duration(config.ElasticSearchRetryBackoffMS) * time.Millisecond,
+		logger:        logger,
+		config:        config,
+	}
+
+	if config.ElasticSearchSignRequests {
+		if sess == nil {
+			return nil, errors.New("elasticsearch_sign_requests needs an AWS session")
+		}
+		repeater.signer = v4.NewSigner(sess.Config.Credentials)
+		repeater.region = *sess.Config.Region
+	}
+
+	return repeater, nil
+}
+
+func (repeater *ElasticSearchJournalRepeater) Close() error {
+	return nil
+}
+
+func (repeater *ElasticSearchJournalRepeater) WriteBatch(records []*Record) error {
+
+	pending := make([][]byte, 0, len(records))
+
+	for _, record := range records {
+		item, err := repeater.encodeBulkItem(record)
+		if err != nil {
+			return err
+		}
+		pending = append(pending, item)
+	}
+
+	var lastErr error
+
+	for attempt := 0; attempt <= repeater.maxRetries && len(pending) > 0; attempt++ {
+
+		if attempt > 0 {
+			time.Sleep(repeater.retryBackoff * time.Duration(1<<uint(attempt-1)))
+			repeater.logger.Warnf("Retrying %d bulk items, attempt %d", len(pending), attempt)
+		}
+
+		failed, err := repeater.sendBulk(pending)
+		if err != nil {
+			lastErr = err
+			if retryableError(err) {
+				continue
+			}
+			return err
+		}
+		pending = failed
+		lastErr = nil
+	}
+
+	if lastErr != nil {
+		return lastErr
+	}
+
+	if len(pending) > 0 {
+		return fmt.Errorf("%d bulk items still failing after %d retries", len(pending), repeater.maxRetries)
+	}
+
+	if repeater.config.Debug {
+		repeater.logger.Debug("SENT SUCCESSFULLY")
+	}
+	return nil
+}
+
+// encodeBulkItem creates the action and source lines for one record.
+func (repeater *ElasticSearchJournalRepeater) encodeBulkItem(record *Record) ([]byte, error) {
+
+	index, err := expandTemplate(repeater.indexTemplate, recordLookup(record))
+	if err != nil {
+		return nil, err
+	}
+
+	// The entry id is the document id, so an entry sent again after a restart replaces itself.
+	target := map[string]string{"_index": strings.ToLower(index)}
+	if record.EntryId != "" {
+		target["_id"] = record.EntryId
+	}
+	action, err := json.Marshal(map[string]map[string]string{"index": target})
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := json.Marshal(newTimestampedRecord(record))
+	if err != nil {
+		return nil, err
+	}
+
+	item := make([]byte, 0, len(action)+len(source)+2)
+	item = append(item, action...)
+	item = append(item, '\n')
+	item = append(item, source...)
+	item = append(item, '\n')
+	return item, nil
+}
+
+type httpStatusError struct {
+	status int
+	body   string
+}
+
+func (err *httpStatusError) Error() string {
+	return fmt.Sprintf("bulk request failed with status %d: %s", err.status, err.body)
+}
+
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+func retryableError(err error) bool {
+	if statusErr, ok := err.(*httpStatusError); ok {
+		return retryableStatus(statusErr.status)
+	}
+	return true
+}
+
+// sendBulk sends the items and returns the ones that failed with a retryable status.
+// Items rejected for any other reason are logged and dropped.
+func (repeater *ElasticSearchJournalRepeater) sendBulk(items [][]byte) ([][]byte, error) {
+
+	body := bytes.Join(items, nil)
+
+	request, err := http.NewRequest("POST", repeater.bulkURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-ndjson")
+
+	if repeater.username != "" {
+		request.SetBasicAuth(repeater.username, repeater.password)
+	}
+
+	if repeater.signer != nil {
+		_, err = repeater.signer.Sign(request, bytes.NewReader(body), repeater.service, repeater.region, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("unable to sign bulk request: %s %v", err.Error(), err)
+		}
+	}
+
+	response, err := repeater.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode >= 300 {
+		return nil, &httpStatusError{response.StatusCode, string(responseBody)}
+	}
+
+	bulkResponse := elasticSearchBulkResponse{}
+	err = json.Unmarshal(responseBody, &bulkResponse)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse bulk response: %s %v", err.Error(), err)
+	}
+
+	if !bulkResponse.Errors {
+		return nil, nil
+	}
+
+	if len(bulkResponse.Items) != len(items) {
+		return nil, fmt.Errorf("bulk response has %d items, sent %d", len(bulkResponse.Items), len(items))
+	}
+
+	failed := make([][]byte, 0)
+	for i, result := range bulkResponse.Items {
+		for _, item := range result {
+			if item.Status < 300 {
+				continue
+			}
+			if retryableStatus(item.Status) {
+				failed = append(failed, items[i])
+			} else {
+				repeater.logger.Errorf("Dropping bulk item for index %s status %d : %s",
+					item.Index, item.Status, string(item.Error))
+			}
+		}
+	}
+
+	return failed, nil
+}
@@ -0,0 +1,99 @@
+package cloud_watch
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestElasticSearchRepeaterRetriesFailedItems(t *testing.T) {
+
+	requests := make([][]string, 0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "elastic" || password != "secret" {
+			t.Errorf("Basic auth not sent %s %s", user, password)
+		}
+
+		if r.URL.Path != "/_bulk" {
+			t.Errorf("Wrong path %s", r.URL.Path)
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		requests = append(requests, lines)
+
+		if len(requests) == 1 {
+			w.Write([]byte(`{"errors":true,"items":[
+				{"index":{"_index":"journal-sshd.service-2016.11.29","status":201}},
+				{"index":{"_index":"journal-sshd.service-2016.11.29","status":429,"error":{"type":"es_rejected_execution_exception"}}},
+				{"index":{"_index":"journal-sshd.service-2016.11.29","status":400,"error":{"type":"mapper_parsing_exception"}}}]}`))
+		} else {
+			w.Write([]byte(`{"errors":false,"items":[{"index":{"status":201}}]}`))
+		}
+	}))
+	defer server.Close()
+
+	config, _ := LoadConfigFromString(`
+repeater="elasticsearch"
+elasticsearch_url="`+server.URL+`"
+elasticsearch_index="journal-{systemdUnit}-{date:2006.01.02}"
+elasticsearch_username="elastic"
+elasticsearch_password="secret"
+elasticsearch_retry_backoff_ms=1
+`, nil)
+
+	repeater, err := NewElasticSearchJournalRepeater(nil, nil, config)
+	if err != nil {
+		t.Fatalf("Unable to create repeater %s", err)
+	}
+
+	records := []*Record{
+		{Message: "one", SystemdUnit: "sshd.service", TimeUsec: 1480459022025000},
+		{Message: "two", SystemdUnit: "sshd.service", TimeUsec: 1480459022026000},
+		{Message: "three", SystemdUnit: "sshd.service", TimeUsec: 1480459022027000},
+	}
+
+	err = repeater.WriteBatch(records)
+	if err != nil {
+		t.Errorf("Unable to write batch %s", err)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("Expected two bulk requests got %d", len(requests))
+	}
+
+	if len(requests[0]) != 6 {
+		t.Errorf("Expected three items in the first request got %d lines", len(requests[0]))
+	}
+
+	if !strings.Contains(requests[0][0], `"_index":"journal-sshd.service-2016.11.29"`) {
+		t.Errorf("Index not templated %s", requests[0][0])
+	}
+
+	if len(requests[1]) != 2 || !strings.Contains(requests[1][1], `"message":"two"`) {
+		t.Errorf("Only the rejected item should be retried %v", requests[1])
+	}
+
+	document := map[string]interface{}{}
+	json.NewDecoder(bytes.NewReader([]byte(requests[0][1]))).Decode(&document)
+	if document["@timestamp"] != "2016-11-29T22:37:02.025Z" {
+		t.Errorf("Timestamp not set %v", document["@timestamp"])
+	}
+}
+
+func TestElasticSearchRepeaterNeedsURL(t *testing.T) {
+
+	config, _ := LoadConfigFromString(`repeater="elasticsearch"`, nil)
+
+	_, err := NewElasticSearchJournalRepeater(nil, nil, config)
+	if err == nil {
+		t.Error("Expected an error without elasticsearch_url")
+	}
+}
@@ -0,0 +1,176 @@
+package cloud_watch
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	awsSession "github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"strings"
+	"time"
+	lg "github.com/advantageous/go-logback/logging"
+)
+
+const (
+	ENRICH_INSTANCE_TYPE = "instance_type"
+	ENRICH_AMI_ID        = "ami_id"
+	ENRICH_AZ            = "az"
+	ENRICH_ACCOUNT_ID    = "account_id"
+	ENRICH_ASG           = "asg"
+	ENRICH_TAG_PREFIX    = "tag:"
+)
+
+// EnrichingJournalRepeater attaches instance details (enrich_fields) to every record before
+// passing the batch on. The details are read with DescribeInstances, falling back to the
+// instance metadata, and refreshed every enrich_refresh_seconds so tag changes show up.
+type EnrichingJournalRepeater struct {
+	repeater  JournalRepeater
+	fields    []string
+	refresh   time.Duration
+	provider  InstanceMetadataProvider
+	describe  func() (*ec2.Instance, string, error)
+	values    *Enrichment
+	refreshed time.Time
+	logger    lg.Logger
+}
+
+// NewEnrichingJournalRepeater wraps repeater. The provider and session may be nil, fields
+// that can not be looked up are left out of the records.
+func NewEnrichingJournalRepeater(repeater JournalRepeater, provider InstanceMetadataProvider,
+	session *awsSession.Session, logger lg.Logger, config *Config) *EnrichingJournalRepeater {
+
+	if logger == nil {
+		if !config.Debug {
+			logger = lg.GetSimpleLogger("ENRICHING_REPEATER_DEBUG", "enriching-repeater")
+		} else {
+			logger = lg.NewSimpleDebugLogger("enriching-repeater")
+		}
+	}
+
+	return &EnrichingJournalRepeater{
+		repeater: repeater,
+		fields:   config.EnrichFields,
+		refresh:  time.Duration(config.EnrichRefreshSeconds) * time.Second,
+		provider: provider,
+		describe: func() (*ec2.Instance, string, error) {
+			return describeInstance(config.EC2InstanceId, config.AWSRegion, session)
+		},
+		logger: logger,
+	}
+}
+
+func (repeater *EnrichingJournalRepeater) Close() error {
+	return repeater.repeater.Close()
+}
+
+func (repeater *EnrichingJournalRepeater) WriteBatch(records []*Record) error {
+
+	if repeater.values == nil || time.Since(repeater.refreshed) >= repeater.refresh {
+		repeater.load()
+	}
+
+	// The map is replaced, never changed, on refresh so records in flight can share it.
+	for _, record := range records {
+		record.Enrichment = repeater.values
+	}
+	return repeater.repeater.WriteBatch(records)
+}
+
+// enrichmentKey is the JSON key of a field, tag:Role is written as tag_Role.
+func enrichmentKey(field string) string {
+	return strings.Replace(field, ":", "_", 1)
+}
+
+func (repeater *EnrichingJournalRepeater) load() {
+
+	instance, accountId, err := repeater.describe()
+	if err != nil {
+		repeater.logger.Warnf("Unable to describe instance, using instance metadata : %s %v", err.Error(), err)
+		instance = nil
+	}
+
+	values := make(Enrichment, len(repeater.fields))
+	for _, field := range repeater.fields {
+		value, err := repeater.lookup(field, instance, accountId)
+		if err != nil {
+			repeater.logger.Warnf("Unable to enrich records with %s : %s %v", field, err.Error(), err)
+			if repeater.values != nil {
+				if previous, found := (*repeater.values)[enrichmentKey(field)]; found {
+					values[enrichmentKey(field)] = previous
+				}
+			}
+			continue
+		}
+		values[enrichmentKey(field)] = value
+	}
+
+	repeater.values = &values
+	repeater.refreshed = time.Now()
+}
+
+func (repeater *EnrichingJournalRepeater) lookup(field string, instance *ec2.Instance, accountId string) (string, error) {
+
+	if instance != nil {
+		switch field {
+		case ENRICH_INSTANCE_TYPE:
+			return aws.StringValue(instance.InstanceType), nil
+		case ENRICH_AMI_ID:
+			return aws.StringValue(instance.ImageId), nil
+		case ENRICH_AZ:
+			if instance.Placement != nil {
+				return aws.StringValue(instance.Placement.AvailabilityZone), nil
+			}
+		case ENRICH_ACCOUNT_ID:
+			return accountId, nil
+		case ENRICH_ASG:
+			if value, found := instanceTag(instance, "aws:autoscaling:groupName"); found {
+				return value, nil
+			}
+		default:
+			if strings.HasPrefix(field, ENRICH_TAG_PREFIX) {
+				if value, found := instanceTag(instance, strings.TrimPrefix(field, ENRICH_TAG_PREFIX)); found {
+					return value, nil
+				}
+			}
+		}
+	}
+
+	if repeater.provider == nil {
+		return "", fmt.Errorf("no instance metadata for %s", field)
+	}
+
+	switch field {
+	case ENRICH_INSTANCE_TYPE:
+		return repeater.getMetadata("instance-type")
+	case ENRICH_AMI_ID:
+		return repeater.getMetadata("ami-id")
+	case ENRICH_AZ:
+		return repeater.provider.AvailabilityZone()
+	case ENRICH_ACCOUNT_ID:
+		info, err := repeater.getMetadata("identity-credentials/ec2/info")
+		if err != nil {
+			return "", err
+		}
+		identity := struct {
+			AccountId string `json:"AccountId"`
+		}{}
+		err = json.Unmarshal([]byte(info), &identity)
+		return identity.AccountId, err
+	case ENRICH_ASG:
+		return repeater.provider.Tag("aws:autoscaling:groupName")
+	}
+
+	if strings.HasPrefix(field, ENRICH_TAG_PREFIX) {
+		return repeater.provider.Tag(strings.TrimPrefix(field, ENRICH_TAG_PREFIX))
+	}
+	return "", fmt.Errorf("unknown enrich field %s", field)
+}
+
+// getMetadata reads metadata that only the EC2 instance metadata service has.
+func (repeater *EnrichingJournalRepeater) getMetadata(path string) (string, error) {
+	imds, ok := repeater.provider.(*IMDSMetadataProvider)
+	if !ok {
+		return "", fmt.Errorf("%s is only in the EC2 instance metadata", path)
+	}
+	return imds.GetMetadata(path)
+}
@@ -0,0 +1,107 @@
+package cloud_watch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnrichFromDescribeInstances(t *testing.T) {
+
+	config, _ := LoadConfigFromString(`
+local=true
+enrich_fields=["instance_type", "ami_id", "az", "account_id", "asg", "tag:Role"]
+`, nil)
+
+	var out bytes.Buffer
+	repeater := NewEnrichingJournalRepeater(NewWriterJournalRepeater(&out), nil, nil, nil, config)
+
+	role := "web"
+	describes := 0
+	repeater.describe = func() (*ec2.Instance, string, error) {
+		describes++
+		return &ec2.Instance{
+			InstanceType: aws.String("m5.large"),
+			ImageId:      aws.String("ami-0abc"),
+			Placement:    &ec2.Placement{AvailabilityZone: aws.String("us-west-2a")},
+			Tags: []*ec2.Tag{
+				{Key: aws.String("Role"), Value: aws.String(role)},
+				{Key: aws.String("aws:autoscaling:groupName"), Value: aws.String("web-asg")},
+			},
+		}, "123456789012", nil
+	}
+
+	err := repeater.WriteBatch([]*Record{{Message: "one", TimeUsec: 1480459022025000}})
+	if err != nil {
+		t.Fatalf("Unable to write batch %s", err)
+	}
+
+	written := map[string]interface{}{}
+	json.Unmarshal(out.Bytes(), &written)
+	enrichment, _ := written["aws"].(map[string]interface{})
+
+	expected := map[string]string{"instance_type": "m5.large", "ami_id": "ami-0abc", "az": "us-west-2a",
+		"account_id": "123456789012", "asg": "web-asg", "tag_Role": "web"}
+	for key, value := range expected {
+		if enrichment[key] != value {
+			t.Errorf("Expected %s=%s got %v", key, value, enrichment[key])
+		}
+	}
+
+	role = "worker"
+	repeater.WriteBatch([]*Record{{Message: "two"}})
+	if describes != 1 {
+		t.Errorf("Instance should not be described again before the refresh, described %d times", describes)
+	}
+
+	repeater.refreshed = time.Now().Add(-time.Hour)
+	records := []*Record{{Message: "three"}}
+	repeater.WriteBatch(records)
+	if describes != 2 || (*records[0].Enrichment)["tag_Role"] != "worker" {
+		t.Errorf("Tags not refreshed %d %v", describes, records[0].Enrichment)
+	}
+}
+
+func TestEnrichFromInstanceMetadata(t *testing.T) {
+
+	imds := newFakeIMDS()
+	imds.values["instance-type"] = "t3.micro"
+	imds.values["ami-id"] = "ami-0def"
+	imds.values["identity-credentials/ec2/info"] = `{"Code":"Success","AccountId":"210987654321"}`
+	server := httptest.NewServer(imds)
+	defer server.Close()
+
+	config, _ := LoadConfigFromString(`
+metadata_endpoint="`+server.URL+`"
+enrich_fields=["instance_type", "ami_id", "account_id", "tag:Team", "tag:Missing"]
+`, nil)
+
+	repeater := NewEnrichingJournalRepeater(NewMockJournalRepeater(), NewIMDSMetadataProvider(config), nil, nil, config)
+	repeater.describe = func() (*ec2.Instance, string, error) {
+		return nil, "", errors.New("UnauthorizedOperation")
+	}
+
+	records := []*Record{{Message: "one"}}
+	repeater.WriteBatch(records)
+
+	enrichment := *records[0].Enrichment
+	if enrichment["instance_type"] != "t3.micro" || enrichment["ami_id"] != "ami-0def" ||
+		enrichment["account_id"] != "210987654321" || enrichment["tag_Team"] != "payments" {
+		t.Errorf("Unexpected enrichment %v", enrichment)
+	}
+
+	if _, found := enrichment["tag_Missing"]; found {
+		t.Error("A tag that can not be found should be left out")
+	}
+
+	data, _ := json.Marshal(records[0])
+	if !strings.Contains(string(data), `"aws":{`) {
+		t.Errorf("Enrichment not in JSON %s", string(data))
+	}
+}
@@ -0,0 +1,442 @@
+package cloud_watch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	JOURNAL_READER_EXPORT = "export"
+	// JOURNAL_INPUT_STDIN is the journal_input that reads standard input.
+	JOURNAL_INPUT_STDIN = "-"
+)
+
+// exportEntry is one entry of the export stream with its fields.
+type exportEntry struct {
+	fields    map[string]string
+	realtime  uint64
+	monotonic uint64
+}
+
+// ExportJournal reads entries serialized by journalctl -o export or journalctl -o json from
+// a file or a pipe, e.g. standard input. Both formats are detected for every entry, so the
+// output of several journalctl commands can be concatenated. Only files can seek back, a pipe
+// is read once and the end of its input is the end of the journal.
+type ExportJournal struct {
+	path     string
+	input    io.ReadCloser
+	reader   *bufio.Reader
+	seekable bool
+	done     bool
+	read     bool
+	current  *exportEntry
+	// pending is an entry read by a seek that Next returns next.
+	pending *exportEntry
+	filter  *journalFilter
+}
+
+// NewExportJournal opens journal_input, standard input when it is - or empty.
+func NewExportJournal(config *Config) (*ExportJournal, error) {
+	journal := &ExportJournal{path: config.JournalInput, filter: newJournalFilter()}
+	if err := journal.open(); err != nil {
+		return nil, err
+	}
+	return journal, nil
+}
+
+// newExportJournalFromReader reads the entries of reader, like a pipe.
+func newExportJournalFromReader(reader io.Reader) *ExportJournal {
+	return &ExportJournal{input: ioutil.NopCloser(reader), reader: bufio.NewReader(reader),
+		filter: newJournalFilter()}
+}
+
+func (journal *ExportJournal) open() error {
+
+	if journal.path == "" || journal.path == JOURNAL_INPUT_STDIN {
+		journal.input = os.Stdin
+		journal.reader = bufio.NewReader(os.Stdin)
+		return nil
+	}
+
+	file, err := os.Open(journal.path)
+	if err != nil {
+		return fmt.Errorf("unable to open journal input %s: %s %v", journal.path, err.Error(), err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("unable to open journal input %s: %s %v", journal.path, err.Error(), err)
+	}
+
+	journal.input = file
+	journal.reader = bufio.NewReader(file)
+	journal.seekable = info.Mode().IsRegular()
+	journal.done = false
+	journal.read = false
+	journal.current = nil
+	journal.pending = nil
+	return nil
+}
+
+// Done is true once the end of the input was reached, the runner stops then.
+func (journal *ExportJournal) Done() bool {
+	return journal.done
+}
+
+func (journal *ExportJournal) Close() error {
+	if journal.input == os.Stdin {
+		return nil
+	}
+	return journal.input.Close()
+}
+
+// readEntry reads the next entry of the input, nil at the end of the input.
+func (journal *ExportJournal) readEntry() (*exportEntry, error) {
+
+	if journal.done {
+		return nil, nil
+	}
+
+	// Skip the blank lines between entries.
+	for {
+		first, err := journal.reader.Peek(1)
+		if err == io.EOF {
+			journal.done = true
+			return nil, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to read journal input: %s %v", err.Error(), err)
+		}
+		if first[0] != '\n' && first[0] != '\r' {
+			break
+		}
+		journal.reader.ReadByte()
+	}
+	journal.read = true
+
+	var fields map[string]string
+	var err error
+	if first, _ := journal.reader.Peek(1); first[0] == '{' {
+		fields, err = readJSONEntry(journal.reader)
+	} else {
+		fields, err = readExportEntry(journal.reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &exportEntry{fields: fields}
+	if entry.realtime, err = strconv.ParseUint(fields["__REALTIME_TIMESTAMP"], 10, 64); err != nil {
+		return nil, fmt.Errorf("journal input entry without a valid __REALTIME_TIMESTAMP %q",
+			fields["__REALTIME_TIMESTAMP"])
+	}
+	entry.monotonic, _ = strconv.ParseUint(fields["__MONOTONIC_TIMESTAMP"], 10, 64)
+	return entry, nil
+}
+
+// readExportEntry reads an entry of the journal export format. Text fields are FIELD=value
+// lines, binary fields are the field name on a line, the size as a little endian 64 bit
+// number, the data and a newline. An empty line or the end of the input ends the entry.
+func readExportEntry(reader *bufio.Reader) (map[string]string, error) {
+
+	fields := map[string]string{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("unable to read journal export entry: %s %v", err.Error(), err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return fields, nil
+		}
+
+		if separator := strings.IndexByte(line, '='); separator > 0 {
+			fields[line[:separator]] = line[separator+1:]
+		} else if err == nil {
+			var size uint64
+			if err := binary.Read(reader, binary.LittleEndian, &size); err != nil {
+				return nil, fmt.Errorf("unable to read the size of journal export field %s: %s %v", line, err.Error(), err)
+			}
+			if size > 1<<30 {
+				return nil, fmt.Errorf("journal export field %s of %d bytes is too large", line, size)
+			}
+			data := make([]byte, size+1)
+			if _, err := io.ReadFull(reader, data); err != nil {
+				return nil, fmt.Errorf("unable to read journal export field %s: %s %v", line, err.Error(), err)
+			}
+			if data[size] != '\n' {
+				return nil, fmt.Errorf("journal export field %s does not end with a newline", line)
+			}
+			fields[line] = string(data[:size])
+		} else {
+			return nil, fmt.Errorf("invalid journal export line %q", line)
+		}
+
+		if err == io.EOF {
+			return fields, nil
+		}
+	}
+}
+
+// readJSONEntry reads a line of journalctl -o json. Binary values are arrays of bytes and
+// fields with several values are arrays of values, of which the first is used.
+func readJSONEntry(reader *bufio.Reader) (map[string]string, error) {
+
+	line, err := reader.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("unable to read journal JSON entry: %s %v", err.Error(), err)
+	}
+
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(line, &values); err != nil {
+		return nil, fmt.Errorf("invalid journal JSON entry: %s %v", err.Error(), err)
+	}
+
+	fields := map[string]string{}
+	for field, value := range values {
+		if text, found := jsonFieldValue(value); found {
+			fields[field] = text
+		}
+	}
+	return fields, nil
+}
+
+func jsonFieldValue(value interface{}) (string, bool) {
+	switch value := value.(type) {
+	case string:
+		return value, true
+	case []interface{}:
+		if len(value) == 0 {
+			return "", false
+		}
+		if _, isNumber := value[0].(float64); isNumber {
+			data := make([]byte, 0, len(value))
+			for _, item := range value {
+				number, ok := item.(float64)
+				if !ok {
+					return "", false
+				}
+				data = append(data, byte(number))
+			}
+			return string(data), true
+		}
+		return jsonFieldValue(value[0])
+	}
+	return "", false
+}
+
+// Next advances the read pointer into the journal by one entry.
+func (journal *ExportJournal) Next() (uint64, error) {
+	for {
+		entry := journal.pending
+		journal.pending = nil
+		if entry == nil {
+			var err error
+			if entry, err = journal.readEntry(); err != nil {
+				return 0, err
+			}
+		}
+		if entry == nil {
+			return 0, nil
+		}
+		journal.current = entry
+		if journal.filter.empty() || journal.filter.matches(entry.fields) {
+			return 1, nil
+		}
+	}
+}
+
+// NextSkip advances the read pointer by multiple entries at once,
+// as specified by the skip parameter.
+func (journal *ExportJournal) NextSkip(skip uint64) (uint64, error) {
+	var skipped uint64
+	for ; skipped < skip; skipped++ {
+		count, err := journal.Next()
+		if err != nil || count == 0 {
+			return skipped, err
+		}
+	}
+	return skipped, nil
+}
+
+// Previous does not move, the input is read forward only.
+func (journal *ExportJournal) Previous() (uint64, error) {
+	return 0, nil
+}
+
+// PreviousSkip does not move, the input is read forward only.
+func (journal *ExportJournal) PreviousSkip(skip uint64) (uint64, error) {
+	return 0, nil
+}
+
+// GetDataValue gets the value of a field of the current entry. The __ fields of the export
+// format, e.g. __CURSOR, are not entry fields and not returned, like sd_journal does.
+func (journal *ExportJournal) GetDataValue(field string) (string, error) {
+	if journal.current == nil {
+		return "", fmt.Errorf("no current journal entry")
+	}
+	value, found := journal.current.fields[field]
+	if !found || strings.HasPrefix(field, "__") {
+		return "", fmt.Errorf("field %s not found", field)
+	}
+	return value, nil
+}
+
+// GetRealtimeUsec gets the realtime (wallclock) timestamp of the current
+// journal entry.
+func (journal *ExportJournal) GetRealtimeUsec() (uint64, error) {
+	if journal.current == nil {
+		return 0, fmt.Errorf("no current journal entry")
+	}
+	return journal.current.realtime, nil
+}
+
+// GetMonotonicUsec gets the monotonic timestamp of the current journal entry.
+func (journal *ExportJournal) GetMonotonicUsec() (uint64, error) {
+	if journal.current == nil {
+		return 0, fmt.Errorf("no current journal entry")
+	}
+	return journal.current.monotonic, nil
+}
+
+// GetCursor gets the __CURSOR of the current entry.
+func (journal *ExportJournal) GetCursor() (string, error) {
+	if journal.current == nil {
+		return "", fmt.Errorf("no current journal entry")
+	}
+	cursor, found := journal.current.fields["__CURSOR"]
+	if !found {
+		return "", fmt.Errorf("journal input entry has no __CURSOR")
+	}
+	return cursor, nil
+}
+
+// AddLogFilters adds the log_priority matches.
+func (journal *ExportJournal) AddLogFilters(config *Config) {
+	journal.filter.addLogFilters(config)
+}
+
+// AddMatch adds a match by which to filter the entries of the journal.
+func (journal *ExportJournal) AddMatch(match string) error {
+	return journal.filter.addMatch(match)
+}
+
+// SeekHead reopens a file input. A pipe is already at its head until it is read.
+func (journal *ExportJournal) SeekHead() error {
+	if !journal.read {
+		return nil
+	}
+	if !journal.seekable {
+		return fmt.Errorf("journal input %s can only be read once", journal.name())
+	}
+	journal.input.Close()
+	return journal.open()
+}
+
+// SeekTail reads a file input to its end. A pipe stays where it is, its tail is whatever
+// is written to it next.
+func (journal *ExportJournal) SeekTail() error {
+	if !journal.seekable {
+		return nil
+	}
+	journal.pending = nil
+	for {
+		entry, err := journal.readEntry()
+		if err != nil || entry == nil {
+			return err
+		}
+	}
+}
+
+// SeekRealtimeUsec skips the entries before usec.
+func (journal *ExportJournal) SeekRealtimeUsec(usec uint64) error {
+	return journal.skipTo(func(entry *exportEntry) bool { return entry.realtime >= usec })
+}
+
+// SeekCursor skips the entries up to the entry with the cursor, which Next returns next
+// like sd_journal does. If the cursor is not found the input is read to its end.
+func (journal *ExportJournal) SeekCursor(cursor string) error {
+	return journal.skipTo(func(entry *exportEntry) bool { return entry.fields["__CURSOR"] == cursor })
+}
+
+// skipTo starts from the head and reads entries until found is true for one, which becomes
+// the pending entry.
+func (journal *ExportJournal) skipTo(found func(entry *exportEntry) bool) error {
+	if journal.pending == nil || !found(journal.pending) {
+		if err := journal.SeekHead(); err != nil {
+			return err
+		}
+	}
+	for journal.pending == nil || !found(journal.pending) {
+		entry, err := journal.readEntry()
+		if err != nil {
+			return err
+		}
+		journal.pending = entry
+		if entry == nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// ListBoots lists the boots of a file input by reading all of it. A pipe cannot be read
+// twice, so it has no boots.
+func (journal *ExportJournal) ListBoots() ([]JournalBoot, error) {
+
+	if !journal.seekable {
+		return []JournalBoot{}, nil
+	}
+
+	reader := &ExportJournal{path: journal.path}
+	if err := reader.open(); err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	byId := map[string]JournalBoot{}
+	for {
+		entry, err := reader.readEntry()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			break
+		}
+		id := entry.fields["_BOOT_ID"]
+		if boot, found := byId[id]; id != "" && (!found || entry.realtime < boot.FirstUsec) {
+			byId[id] = JournalBoot{Id: id, FirstUsec: entry.realtime, FirstCursor: entry.fields["__CURSOR"]}
+		}
+	}
+
+	boots := make([]JournalBoot, 0, len(byId))
+	for _, boot := range byId {
+		boots = append(boots, boot)
+	}
+	sort.Slice(boots, func(i, j int) bool { return boots[i].FirstUsec < boots[j].FirstUsec })
+	return boots, nil
+}
+
+// Wait does not wait, Next blocks on the input until an entry or its end is read. It returns
+// 0 (SD_JOURNAL_NOP) once the input ended.
+func (journal *ExportJournal) Wait(timeout time.Duration) int {
+	if journal.done {
+		return 0
+	}
+	return 1
+}
+
+func (journal *ExportJournal) name() string {
+	if journal.path == "" || journal.path == JOURNAL_INPUT_STDIN {
+		return "stdin"
+	}
+	return journal.path
+}
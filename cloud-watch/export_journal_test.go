@@ -0,0 +1,165 @@
+package cloud_watch
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+	lg "github.com/advantageous/go-logback/logging"
+)
+
+var exportJournalSamples = []string{"testdata/export/sample.export", "testdata/export/sample.json"}
+
+func openExportSample(t *testing.T, sample string, extra string) (*ExportJournal, *Config) {
+	config, _ := LoadConfigFromString(`journal_reader="export"
+journal_input="`+sample+`"
+`+extra, nil)
+	journal, err := NewExportJournal(config)
+	if err != nil {
+		t.Fatalf("Unable to open sample %s %s", sample, err)
+	}
+	return journal, config
+}
+
+type collectingRepeater struct {
+	mutex   sync.Mutex
+	records []*Record
+}
+
+func (repeater *collectingRepeater) Close() error {
+	return nil
+}
+
+func (repeater *collectingRepeater) WriteBatch(records []*Record) error {
+	repeater.mutex.Lock()
+	defer repeater.mutex.Unlock()
+	repeater.records = append(repeater.records, records...)
+	return nil
+}
+
+func (repeater *collectingRepeater) collected() []*Record {
+	repeater.mutex.Lock()
+	defer repeater.mutex.Unlock()
+	return append([]*Record{}, repeater.records...)
+}
+
+func TestExportJournalSamples(t *testing.T) {
+
+	for _, sample := range exportJournalSamples {
+
+		journal, _ := openExportSample(t, sample, "")
+		defer journal.Close()
+
+		messages := []string{}
+		for {
+			count, err := journal.Next()
+			if err != nil {
+				t.Fatalf("%s: unable to read %s", sample, err)
+			}
+			if count == 0 {
+				break
+			}
+			message, _ := journal.GetDataValue("MESSAGE")
+			messages = append(messages, message)
+
+			if len(messages) == 3 {
+				cursor, err := journal.GetCursor()
+				if err != nil || cursor != nativeJournalSamples["compact"] {
+					t.Errorf("%s: wrong cursor %s %v", sample, cursor, err)
+				}
+			}
+			if len(messages) == 5 {
+				binary, err := journal.GetDataValue("SAMPLE_BINARY")
+				if err != nil || binary != "\x00\x01\x02binary" {
+					t.Errorf("%s: wrong binary field %q %v", sample, binary, err)
+				}
+			}
+		}
+
+		if len(messages) != 7 || messages[0] != "Journal started" || messages[4] != "line one\nline two" {
+			t.Errorf("%s: wrong messages %q", sample, messages)
+		}
+		if !journal.Done() {
+			t.Errorf("%s: expected the input to be done", sample)
+		}
+		if _, err := journal.GetDataValue("__CURSOR"); err == nil {
+			t.Errorf("%s: expected __ fields to be hidden", sample)
+		}
+	}
+}
+
+func TestExportJournalSeek(t *testing.T) {
+
+	journal, _ := openExportSample(t, exportJournalSamples[0], "")
+	defer journal.Close()
+
+	if err := journal.SeekCursor(nativeJournalSamples["compact"]); err != nil {
+		t.Fatalf("Unable to seek to cursor %s", err)
+	}
+	journal.Next()
+	if message, _ := journal.GetDataValue("MESSAGE"); message != "hello from the sample journal" {
+		t.Errorf("Expected the entry of the cursor, got %s", message)
+	}
+
+	journal.SeekHead()
+	journal.Next()
+	if message, _ := journal.GetDataValue("MESSAGE"); message != "Journal started" {
+		t.Errorf("Expected the first entry after seeking to head, got %s", message)
+	}
+
+	boots, err := journal.ListBoots()
+	if err != nil || len(boots) != 1 || boots[0].Id != "aaef4725d15143d1bfeec08851ff34d5" {
+		t.Errorf("Wrong boots %v %v", boots, err)
+	}
+
+	file, err := os.Open(exportJournalSamples[1])
+	if err != nil {
+		t.Fatalf("Unable to open sample %s", err)
+	}
+	defer file.Close()
+
+	pipe := newExportJournalFromReader(file)
+	if err := pipe.SeekHead(); err != nil {
+		t.Errorf("Expected a pipe to be at its head %s", err)
+	}
+	pipe.Next()
+	if err := pipe.SeekHead(); err == nil {
+		t.Errorf("Expected a pipe not to seek back")
+	}
+}
+
+func TestExportJournalRunner(t *testing.T) {
+
+	journal, config := openExportSample(t, exportJournalSamples[1], "log_priority=4\nfields=[\"MESSAGE\", \"PRIORITY\", \"SYSLOG_IDENTIFIER\"]")
+	defer journal.Close()
+	journal.AddLogFilters(config)
+
+	repeater := &collectingRepeater{}
+	done := make(chan bool)
+	go func() {
+		NewRunner(journal, repeater, lg.NewSimpleLogger("export-test"), config)
+		done <- true
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the runner to stop at the end of the input")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(repeater.collected()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	records := repeater.collected()
+	if len(records) != 2 {
+		t.Fatalf("Expected the 2 warnings and errors, got %d records", len(records))
+	}
+	if records[0].Priority != ERROR || records[1].Message != "line one\nline two" || records[1].Identifier != "sample" {
+		t.Errorf("Wrong records %+v %+v", records[0], records[1])
+	}
+	if records[0].PID != 0 {
+		t.Errorf("Expected fields to filter _PID, got %d", records[0].PID)
+	}
+}
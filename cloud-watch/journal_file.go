@@ -0,0 +1,428 @@
+package cloud_watch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"io/ioutil"
+	"os"
+)
+
+// The journal file format is described in
+// https://systemd.io/JOURNAL_FILE_FORMAT/, all numbers are little endian.
+
+const journalSignature = "LPKSHHRH"
+
+const (
+	journalIncompatibleCompressedXZ   = 1 << 0
+	journalIncompatibleCompressedLZ4  = 1 << 1
+	journalIncompatibleKeyedHash      = 1 << 2
+	journalIncompatibleCompressedZSTD = 1 << 3
+	journalIncompatibleCompact        = 1 << 4
+	journalIncompatibleSupported      = journalIncompatibleCompressedXZ | journalIncompatibleCompressedLZ4 |
+		journalIncompatibleKeyedHash | journalIncompatibleCompressedZSTD | journalIncompatibleCompact
+)
+
+const (
+	journalObjectData       = 1
+	journalObjectEntry      = 3
+	journalObjectEntryArray = 6
+)
+
+const (
+	journalObjectCompressedXZ   = 1 << 0
+	journalObjectCompressedLZ4  = 1 << 1
+	journalObjectCompressedZSTD = 1 << 2
+)
+
+const (
+	journalHeaderSize         = 208
+	journalObjectHeaderSize   = 16
+	journalEntryItemsOffset   = 64
+	journalDataPayload        = 64
+	journalDataPayloadCompact = 72
+	journalEntryArrayItems    = 24
+)
+
+// journalFileEntry is the fixed part of an entry object.
+type journalFileEntry struct {
+	offset    uint64
+	seqnum    uint64
+	realtime  uint64
+	monotonic uint64
+	bootId    string
+	xorHash   uint64
+}
+
+// entryArray is one entry array object of the chain with the index of its first entry.
+type entryArray struct {
+	offset uint64
+	first  uint64
+	count  uint64
+}
+
+// journalFile reads one .journal file. Entries are found through the chain of entry arrays,
+// only the offsets of the arrays are kept so memory does not grow with the journal.
+type journalFile struct {
+	path     string
+	file     *os.File
+	compact  bool
+	fileId   string
+	seqnumId string
+	arrays   []entryArray
+	nEntries uint64
+	zstd     *zstd.Decoder
+}
+
+func openJournalFile(path string) (*journalFile, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, journalHeaderSize)
+	if _, err = file.ReadAt(header, 0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: unable to read header: %s %v", path, err.Error(), err)
+	}
+	if string(header[0:8]) != journalSignature {
+		file.Close()
+		return nil, fmt.Errorf("%s: not a journal file", path)
+	}
+
+	incompatible := binary.LittleEndian.Uint32(header[12:16])
+	if incompatible&^journalIncompatibleSupported != 0 {
+		file.Close()
+		return nil, fmt.Errorf("%s: unsupported journal features %#x", path, incompatible)
+	}
+
+	journal := &journalFile{
+		path:     path,
+		file:     file,
+		compact:  incompatible&journalIncompatibleCompact != 0,
+		fileId:   hex.EncodeToString(header[24:40]),
+		seqnumId: hex.EncodeToString(header[72:88]),
+	}
+
+	if err = journal.refresh(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return journal, nil
+}
+
+func (journal *journalFile) Close() error {
+	if journal.zstd != nil {
+		journal.zstd.Close()
+	}
+	return journal.file.Close()
+}
+
+func (journal *journalFile) readAt(offset uint64, size uint64) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := journal.file.ReadAt(data, int64(offset)); err != nil {
+		return nil, fmt.Errorf("%s: unable to read %d bytes at %d: %s %v", journal.path, size, offset, err.Error(), err)
+	}
+	return data, nil
+}
+
+// readObject reads the object at offset and checks its type.
+func (journal *journalFile) readObject(offset uint64, objectType byte) ([]byte, error) {
+
+	header, err := journal.readAt(offset, journalObjectHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	if header[0] != objectType {
+		return nil, fmt.Errorf("%s: expected object type %d at %d, found %d", journal.path, objectType, offset, header[0])
+	}
+	size := binary.LittleEndian.Uint64(header[8:16])
+	if size < journalObjectHeaderSize || size > 1<<30 {
+		return nil, fmt.Errorf("%s: invalid object size %d at %d", journal.path, size, offset)
+	}
+	return journal.readAt(offset, size)
+}
+
+// refresh reads the number of entries from the header and extends the entry array chain,
+// so entries appended by journald since the file was opened can be read.
+func (journal *journalFile) refresh() error {
+
+	header, err := journal.readAt(0, journalHeaderSize)
+	if err != nil {
+		return err
+	}
+	nEntries := binary.LittleEndian.Uint64(header[152:160])
+	if nEntries == journal.nEntries {
+		return nil
+	}
+
+	itemSize := uint64(8)
+	if journal.compact {
+		itemSize = 4
+	}
+
+	// The last array read may have been filled since, read it again.
+	next := binary.LittleEndian.Uint64(header[176:184])
+	first := uint64(0)
+	if last := len(journal.arrays); last > 0 {
+		next = journal.arrays[last-1].offset
+		first = journal.arrays[last-1].first
+		journal.arrays = journal.arrays[:last-1]
+	}
+
+	for next != 0 && first < nEntries {
+		object, err := journal.readObject(next, journalObjectEntryArray)
+		if err != nil {
+			return err
+		}
+		capacity := (uint64(len(object)) - journalEntryArrayItems) / itemSize
+		count := capacity
+		if first+count > nEntries {
+			count = nEntries - first
+		}
+		journal.arrays = append(journal.arrays, entryArray{offset: next, first: first, count: count})
+		first += count
+		next = binary.LittleEndian.Uint64(object[16:24])
+	}
+
+	journal.nEntries = first
+	return nil
+}
+
+// entryOffset returns the offset of the entry object with the index.
+func (journal *journalFile) entryOffset(index uint64) (uint64, error) {
+
+	low, high := 0, len(journal.arrays)
+	for low < high {
+		middle := (low + high) / 2
+		if journal.arrays[middle].first+journal.arrays[middle].count <= index {
+			low = middle + 1
+		} else {
+			high = middle
+		}
+	}
+	if low == len(journal.arrays) {
+		return 0, fmt.Errorf("%s: no entry %d", journal.path, index)
+	}
+
+	array := journal.arrays[low]
+	if journal.compact {
+		data, err := journal.readAt(array.offset+journalEntryArrayItems+(index-array.first)*4, 4)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.LittleEndian.Uint32(data)), nil
+	}
+	data, err := journal.readAt(array.offset+journalEntryArrayItems+(index-array.first)*8, 8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(data), nil
+}
+
+// entry reads the fixed part of the entry with the index.
+func (journal *journalFile) entry(index uint64) (*journalFileEntry, error) {
+
+	offset, err := journal.entryOffset(index)
+	if err != nil {
+		return nil, err
+	}
+	data, err := journal.readAt(offset, journalEntryItemsOffset)
+	if err != nil {
+		return nil, err
+	}
+	if data[0] != journalObjectEntry {
+		return nil, fmt.Errorf("%s: expected an entry at %d, found object type %d", journal.path, offset, data[0])
+	}
+	return &journalFileEntry{
+		offset:    offset,
+		seqnum:    binary.LittleEndian.Uint64(data[16:24]),
+		realtime:  binary.LittleEndian.Uint64(data[24:32]),
+		monotonic: binary.LittleEndian.Uint64(data[32:40]),
+		bootId:    hex.EncodeToString(data[40:56]),
+		xorHash:   binary.LittleEndian.Uint64(data[56:64]),
+	}, nil
+}
+
+// search returns the index of the first entry for which after is true, entries must be
+// sorted so after is false and then true.
+func (journal *journalFile) search(after func(entry *journalFileEntry) bool) (uint64, error) {
+	low, high := uint64(0), journal.nEntries
+	for low < high {
+		middle := (low + high) / 2
+		entry, err := journal.entry(middle)
+		if err != nil {
+			return 0, err
+		}
+		if after(entry) {
+			high = middle
+		} else {
+			low = middle + 1
+		}
+	}
+	return low, nil
+}
+
+// fields reads the data objects of an entry as FIELD=value pairs.
+func (journal *journalFile) fields(entry *journalFileEntry) (map[string]string, error) {
+
+	object, err := journal.readObject(entry.offset, journalObjectEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	itemSize := 16
+	if journal.compact {
+		itemSize = 4
+	}
+
+	fields := map[string]string{}
+	for item := journalEntryItemsOffset; item+itemSize <= len(object); item += itemSize {
+		var offset uint64
+		if journal.compact {
+			offset = uint64(binary.LittleEndian.Uint32(object[item : item+4]))
+		} else {
+			offset = binary.LittleEndian.Uint64(object[item : item+8])
+		}
+		payload, err := journal.data(offset)
+		if err != nil {
+			return nil, err
+		}
+		separator := bytes.IndexByte(payload, '=')
+		if separator <= 0 {
+			continue
+		}
+		fields[string(payload[:separator])] = string(payload[separator+1:])
+	}
+	return fields, nil
+}
+
+// data reads the FIELD=value payload of a data object, uncompressing it if needed.
+func (journal *journalFile) data(offset uint64) ([]byte, error) {
+
+	object, err := journal.readObject(offset, journalObjectData)
+	if err != nil {
+		return nil, err
+	}
+
+	start := journalDataPayload
+	if journal.compact {
+		start = journalDataPayloadCompact
+	}
+	if len(object) < start {
+		return nil, fmt.Errorf("%s: data object at %d is too small", journal.path, offset)
+	}
+
+	payload, err := journal.uncompress(object[1], object[start:])
+	if err != nil {
+		return nil, fmt.Errorf("%s: unable to uncompress data object at %d: %s %v", journal.path, offset, err.Error(), err)
+	}
+	return payload, nil
+}
+
+func (journal *journalFile) uncompress(flags byte, payload []byte) ([]byte, error) {
+
+	switch {
+	case flags&journalObjectCompressedZSTD != 0:
+		if journal.zstd == nil {
+			decoder, err := zstd.NewReader(nil)
+			if err != nil {
+				return nil, err
+			}
+			journal.zstd = decoder
+		}
+		return journal.zstd.DecodeAll(payload, nil)
+	case flags&journalObjectCompressedLZ4 != 0:
+		return uncompressJournalLZ4(payload)
+	case flags&journalObjectCompressedXZ != 0:
+		reader, err := xz.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(reader)
+	}
+	return payload, nil
+}
+
+// uncompressJournalLZ4 reads an LZ4 payload, the size of the data followed by one LZ4 block.
+func uncompressJournalLZ4(payload []byte) ([]byte, error) {
+	if len(payload) < 8 {
+		return nil, fmt.Errorf("lz4 payload of %d bytes is too small", len(payload))
+	}
+	size := binary.LittleEndian.Uint64(payload[0:8])
+	if size > 1<<30 {
+		return nil, fmt.Errorf("lz4 payload size %d is too large", size)
+	}
+	data := make([]byte, size)
+	count, err := uncompressLZ4Block(payload[8:], data)
+	if err != nil {
+		return nil, err
+	}
+	return data[:count], nil
+}
+
+// uncompressLZ4Block decodes one LZ4 block into data and returns the length of the data. A
+// block is a list of sequences: a token with the number of literals and the match length, the
+// literals, and the offset of the match in the data already decoded. The last sequence only
+// has literals.
+func uncompressLZ4Block(block []byte, data []byte) (int, error) {
+
+	corrupt := fmt.Errorf("corrupt lz4 block of %d bytes", len(block))
+
+	// length reads the extension of a length, bytes that are added while they are 255.
+	length := func(position int, value int) (int, int, bool) {
+		if value != 15 {
+			return value, position, true
+		}
+		for position < len(block) {
+			next := block[position]
+			position++
+			value += int(next)
+			if next != 255 {
+				return value, position, true
+			}
+		}
+		return 0, 0, false
+	}
+
+	position, written := 0, 0
+	for position < len(block) {
+		token := block[position]
+		literals, next, ok := length(position+1, int(token>>4))
+		position = next
+		if !ok || position+literals > len(block) || written+literals > len(data) {
+			return 0, corrupt
+		}
+		written += copy(data[written:], block[position:position+literals])
+		position += literals
+		if position == len(block) {
+			break
+		}
+
+		if position+2 > len(block) {
+			return 0, corrupt
+		}
+		offset := int(block[position]) | int(block[position+1])<<8
+		match, next, ok := length(position+2, int(token&15))
+		position = next
+		match += 4
+		if !ok || offset == 0 || offset > written || written+match > len(data) {
+			return 0, corrupt
+		}
+		// The match may overlap the data it writes, so it is copied byte by byte.
+		for end := written + match; written < end; written++ {
+			data[written] = data[written-offset]
+		}
+	}
+	return written, nil
+}
+
+// cursor formats the cursor of an entry like sd_journal_get_cursor.
+func (journal *journalFile) cursor(entry *journalFileEntry) string {
+	return fmt.Sprintf("s=%s;i=%x;b=%s;m=%x;t=%x;x=%x", journal.seqnumId, entry.seqnum, entry.bootId,
+		entry.monotonic, entry.realtime, entry.xorHash)
+}
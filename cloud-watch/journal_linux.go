@@ -1,7 +1,11 @@
+//go:build linux && cgo
+// +build linux,cgo
+
 package cloud_watch
 
 import (
 	"github.com/coreos/go-systemd/sdjournal"
+	"sort"
 	"strconv"
 	"time"
 )
@@ -10,6 +14,7 @@ type SdJournal struct {
 	journal *sdjournal.Journal
 	logger  *Logger
 	debug   bool
+	dir     string
 }
 
 func NewJournal(config *Config) (Journal, error) {
@@ -27,14 +32,14 @@ func NewJournal(config *Config) (Journal, error) {
 	if config == nil || config.JournalDir == "" {
 		journal, err := sdjournal.NewJournal()
 		return &SdJournal{
-			journal, logger, debug,
+			journal, logger, debug, "",
 		}, err
 	} else {
 		logger.Info.Printf("using journal dir: %s", config.JournalDir)
 		journal, err := sdjournal.NewJournalFromDir(config.JournalDir)
 
 		return &SdJournal{
-			journal, logger, debug,
+			journal, logger, debug, config.JournalDir,
 		}, err
 	}
 
@@ -123,6 +128,64 @@ func (journal *SdJournal) SeekCursor(cursor string) error {
 	return journal.journal.SeekCursor(cursor)
 }
 
+// SeekRealtimeUsec seeks to the entry with the specified realtime (wallclock)
+// timestamp, i.e. CLOCK_REALTIME.
+func (journal *SdJournal) SeekRealtimeUsec(usec uint64) error {
+	return journal.journal.SeekRealtimeUsec(usec)
+}
+
+// AddMatch adds a match by which to filter the entries of the journal.
+func (journal *SdJournal) AddMatch(match string) error {
+	return journal.journal.AddMatch(match)
+}
+
+// ListBoots lists the boots in the journal, oldest first. It uses a second reader so
+// the matches and position of this one are not changed.
+func (journal *SdJournal) ListBoots() ([]JournalBoot, error) {
+
+	var reader *sdjournal.Journal
+	var err error
+	if journal.dir == "" {
+		reader, err = sdjournal.NewJournal()
+	} else {
+		reader, err = sdjournal.NewJournalFromDir(journal.dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	ids, err := reader.GetUniqueValues("_BOOT_ID")
+	if err != nil {
+		return nil, err
+	}
+
+	boots := make([]JournalBoot, 0, len(ids))
+	for _, id := range ids {
+		reader.FlushMatches()
+		if err = reader.AddMatch("_BOOT_ID=" + id); err != nil {
+			return nil, err
+		}
+		if err = reader.SeekHead(); err != nil {
+			return nil, err
+		}
+		if count, err := reader.Next(); err != nil || count == 0 {
+			continue
+		}
+		boot := JournalBoot{Id: id}
+		if boot.FirstUsec, err = reader.GetRealtimeUsec(); err != nil {
+			return nil, err
+		}
+		if boot.FirstCursor, err = reader.GetCursor(); err != nil {
+			return nil, err
+		}
+		boots = append(boots, boot)
+	}
+
+	sort.Slice(boots, func(i, j int) bool { return boots[i].FirstUsec < boots[j].FirstUsec })
+	return boots, nil
+}
+
 // Wait will synchronously wait until the journal gets changed. The maximum time
 // this call sleeps may be controlled with the timeout parameter.  If
 // sdjournal.IndefiniteWait is passed as the timeout parameter, Wait will
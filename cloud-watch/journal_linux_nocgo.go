@@ -0,0 +1,12 @@
+//go:build linux && !cgo
+// +build linux,!cgo
+
+package cloud_watch
+
+import "errors"
+
+// NewJournal needs libsystemd through cgo, builds without cgo read the journal with the
+// native journal_reader.
+func NewJournal(config *Config) (Journal, error) {
+	return nil, errors.New(`the sdjournal journal_reader needs a build with cgo, set journal_reader = "native"`)
+}
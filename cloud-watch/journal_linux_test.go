@@ -1,3 +1,6 @@
+//go:build linux && cgo
+// +build linux,cgo
+
 package cloud_watch
 
 import "testing"
@@ -0,0 +1,202 @@
+package cloud_watch
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Shopify/sarama"
+	lg "github.com/advantageous/go-logback/logging"
+	"strings"
+)
+
+// KafkaJournalRepeater produces every record as a JSON message to a Kafka topic.
+type KafkaJournalRepeater struct {
+	producer      sarama.SyncProducer
+	topicTemplate string
+	keyField      string
+	logger        lg.Logger
+	config        *Config
+}
+
+// NewKafkaJournalRepeater connects a synchronous producer to kafka_brokers.
+func NewKafkaJournalRepeater(logger lg.Logger, config *Config) (*KafkaJournalRepeater, error) {
+
+	if len(config.KafkaBrokers) == 0 {
+		return nil, errors.New("kafka_brokers must be set to use the kafka repeater")
+	}
+
+	if logger == nil {
+		if !config.Debug {
+			logger = lg.GetSimpleLogger("KAFKA_REPEATER_DEBUG", "kafka-repeater")
+		} else {
+			logger = lg.NewSimpleDebugLogger("kafka-repeater")
+		}
+	}
+
+	producerConfig, err := newKafkaProducerConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	producer, err := sarama.NewSyncProducer(config.KafkaBrokers, producerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create kafka producer: %s %v", err.Error(), err)
+	}
+
+	return &KafkaJournalRepeater{
+		producer:      producer,
+		topicTemplate: config.KafkaTopic,
+		keyField:      config.KafkaKey,
+		logger:        logger,
+		config:        config,
+	}, nil
+}
+
+// newKafkaProducerConfig maps the kafka_* config keys onto a sarama producer config.
+func newKafkaProducerConfig(config *Config) (*sarama.Config, error) {
+
+	producerConfig := sarama.NewConfig()
+	producerConfig.ClientID = config.KafkaClientId
+	producerConfig.Producer.Return.Successes = true
+	producerConfig.Producer.Retry.Max = config.KafkaMaxRetries
+
+	version, err := sarama.ParseKafkaVersion(config.KafkaVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kafka_version %s: %s %v", config.KafkaVersion, err.Error(), err)
+	}
+	producerConfig.Version = version
+
+	switch config.KafkaAcks {
+	case "all":
+		producerConfig.Producer.RequiredAcks = sarama.WaitForAll
+	case "local":
+		producerConfig.Producer.RequiredAcks = sarama.WaitForLocal
+	case "none":
+		producerConfig.Producer.RequiredAcks = sarama.NoResponse
+	default:
+		return nil, fmt.Errorf("kafka_acks must be all, local or none, not %s", config.KafkaAcks)
+	}
+
+	switch config.KafkaCompression {
+	case "none":
+		producerConfig.Producer.Compression = sarama.CompressionNone
+	case "gzip":
+		producerConfig.Producer.Compression = sarama.CompressionGZIP
+	case "snappy":
+		producerConfig.Producer.Compression = sarama.CompressionSnappy
+	case "lz4":
+		producerConfig.Producer.Compression = sarama.CompressionLZ4
+	case "zstd":
+		producerConfig.Producer.Compression = sarama.CompressionZSTD
+	default:
+		return nil, fmt.Errorf("kafka_compression must be none, gzip, snappy, lz4 or zstd, not %s", config.KafkaCompression)
+	}
+
+	if config.KafkaIdempotent {
+		// The idempotent producer needs acks from all replicas, checked with the config, and
+		// one request in flight per broker to keep the sequence numbers in order.
+		producerConfig.Producer.Idempotent = true
+		producerConfig.Net.MaxOpenRequests = 1
+	}
+
+	if config.KafkaSaslMechanism != "" {
+		mechanism := strings.ToUpper(config.KafkaSaslMechanism)
+		switch mechanism {
+		case sarama.SASLTypePlaintext:
+		case sarama.SASLTypeSCRAMSHA256:
+			producerConfig.Net.SASL.SCRAMClientGeneratorFunc = newScramSHA256Client
+		case sarama.SASLTypeSCRAMSHA512:
+			producerConfig.Net.SASL.SCRAMClientGeneratorFunc = newScramSHA512Client
+		default:
+			return nil, fmt.Errorf("kafka_sasl_mechanism %s is not supported, use PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512",
+				config.KafkaSaslMechanism)
+		}
+		producerConfig.Net.SASL.Enable = true
+		producerConfig.Net.SASL.Mechanism = sarama.SASLMechanism(mechanism)
+		producerConfig.Net.SASL.User = config.KafkaSaslUsername
+		producerConfig.Net.SASL.Password = config.KafkaSaslPassword
+	}
+
+	if config.KafkaTls {
+		tlsConfig := &tls.Config{}
+		if config.KafkaCAFile != "" {
+			pool, err := loadCertPool(config.KafkaCAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = pool
+		}
+		producerConfig.Net.TLS.Enable = true
+		producerConfig.Net.TLS.Config = tlsConfig
+	}
+
+	err = producerConfig.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid kafka config: %s %v", err.Error(), err)
+	}
+
+	return producerConfig, nil
+}
+
+func (repeater *KafkaJournalRepeater) Close() error {
+	return repeater.producer.Close()
+}
+
+func (repeater *KafkaJournalRepeater) WriteBatch(records []*Record) error {
+
+	messages, err := repeater.buildMessages(records)
+	if err != nil {
+		return err
+	}
+
+	err = repeater.producer.SendMessages(messages)
+	if err != nil {
+		if producerErrors, ok := err.(sarama.ProducerErrors); ok {
+			return fmt.Errorf("failed to produce %d of %d messages: %s",
+				len(producerErrors), len(messages), producerErrors[0].Err.Error())
+		}
+		return err
+	}
+
+	if repeater.config.Debug {
+		repeater.logger.Debug("SENT SUCCESSFULLY")
+	}
+	return nil
+}
+
+func (repeater *KafkaJournalRepeater) buildMessages(records []*Record) ([]*sarama.ProducerMessage, error) {
+
+	messages := make([]*sarama.ProducerMessage, 0, len(records))
+
+	for _, record := range records {
+
+		topic, err := expandTemplate(repeater.topicTemplate, recordLookup(record))
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := json.Marshal(newTimestampedRecord(record))
+		if err != nil {
+			return nil, err
+		}
+
+		message := &sarama.ProducerMessage{
+			Topic:     topic,
+			Value:     sarama.ByteEncoder(value),
+			Timestamp: recordTime(record),
+		}
+
+		if repeater.keyField != "" {
+			key, found := recordFieldValue(record, repeater.keyField)
+			if !found {
+				return nil, fmt.Errorf("unknown kafka_key field %s", repeater.keyField)
+			}
+			message.Key = sarama.StringEncoder(key)
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
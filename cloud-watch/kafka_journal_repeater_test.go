@@ -0,0 +1,116 @@
+package cloud_watch
+
+import (
+	"github.com/Shopify/sarama"
+	"testing"
+)
+
+func TestKafkaRepeaterProducesToBroker(t *testing.T) {
+
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("journal-sshd.service", 0, broker.BrokerID()).
+			SetLeader("journal-cron.service", 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).SetVersion(3),
+	})
+
+	config, _ := LoadConfigFromString(`
+repeater="kafka"
+kafka_brokers=["`+broker.Addr()+`"]
+kafka_topic="journal-{systemdUnit}"
+kafka_key="hostname"
+kafka_compression="gzip"
+`, nil)
+
+	repeater, err := NewKafkaJournalRepeater(nil, config)
+	if err != nil {
+		t.Fatalf("Unable to create repeater %s", err)
+	}
+	defer repeater.Close()
+
+	records := []*Record{
+		{Message: "one", SystemdUnit: "sshd.service", Hostname: "a", TimeUsec: 1480459022025000},
+		{Message: "two", SystemdUnit: "cron.service", Hostname: "b", TimeUsec: 1480459022026000},
+	}
+
+	messages, err := repeater.buildMessages(records)
+	if err != nil {
+		t.Fatalf("Unable to build messages %s", err)
+	}
+
+	if messages[0].Topic != "journal-sshd.service" || messages[1].Topic != "journal-cron.service" {
+		t.Errorf("Topic not templated %s %s", messages[0].Topic, messages[1].Topic)
+	}
+
+	key, _ := messages[1].Key.Encode()
+	if string(key) != "b" {
+		t.Errorf("Key not taken from hostname %s", string(key))
+	}
+
+	err = repeater.WriteBatch(records)
+	if err != nil {
+		t.Fatalf("Unable to write batch %s", err)
+	}
+
+	produced := 0
+	for _, exchange := range broker.History() {
+		if _, ok := exchange.Request.(*sarama.ProduceRequest); ok {
+			produced++
+		}
+	}
+	if produced == 0 {
+		t.Error("No produce request reached the broker")
+	}
+}
+
+func TestKafkaProducerConfig(t *testing.T) {
+
+	config, _ := LoadConfigFromString(`
+kafka_brokers=["localhost:9092"]
+kafka_idempotent=true
+kafka_version="2.1.0"
+kafka_sasl_mechanism="plain"
+kafka_sasl_username="user"
+kafka_sasl_password="secret"
+`, nil)
+
+	producerConfig, err := newKafkaProducerConfig(config)
+	if err != nil {
+		t.Fatalf("Unable to create producer config %s", err)
+	}
+
+	if !producerConfig.Producer.Idempotent || producerConfig.Producer.RequiredAcks != sarama.WaitForAll {
+		t.Error("Idempotent producer needs acks from all replicas")
+	}
+
+	if producerConfig.Net.MaxOpenRequests != 1 {
+		t.Error("Idempotent producer needs one request in flight")
+	}
+
+	if !producerConfig.Net.SASL.Enable || producerConfig.Net.SASL.User != "user" {
+		t.Error("SASL not configured")
+	}
+
+	config.KafkaSaslMechanism = "scram-sha-512"
+	producerConfig, err = newKafkaProducerConfig(config)
+	if err != nil || producerConfig.Net.SASL.Mechanism != sarama.SASLTypeSCRAMSHA512 ||
+		producerConfig.Net.SASL.SCRAMClientGeneratorFunc == nil {
+		t.Errorf("SCRAM not configured %v", err)
+	}
+
+	config.KafkaSaslMechanism = "GSSAPI"
+	if _, err = newKafkaProducerConfig(config); err == nil {
+		t.Error("Expected an error for an unsupported SASL mechanism")
+	}
+	config.KafkaSaslMechanism = "plain"
+
+	config.KafkaCompression = "brotli"
+	_, err = newKafkaProducerConfig(config)
+	if err == nil {
+		t.Error("Expected an error for an unknown compression")
+	}
+}
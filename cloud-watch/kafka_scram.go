@@ -0,0 +1,176 @@
+package cloud_watch
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// newScramNonce returns the client nonce of a SCRAM exchange, tests replace it.
+var newScramNonce = func() (string, error) {
+	nonce := make([]byte, 18)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(nonce), nil
+}
+
+// scramClient authenticates with SCRAM-SHA-256 or SCRAM-SHA-512 (RFC 5802, RFC 7677) for the
+// sarama SASL handshake. Passwords are used as is, without SASLprep.
+type scramClient struct {
+	hash            func() hash.Hash
+	username        string
+	password        string
+	gs2Header       string
+	nonce           string
+	clientFirstBare string
+	serverSignature []byte
+	step            int
+	done            bool
+}
+
+func newScramSHA256Client() sarama.SCRAMClient {
+	return &scramClient{hash: sha256.New}
+}
+
+func newScramSHA512Client() sarama.SCRAMClient {
+	return &scramClient{hash: sha512.New}
+}
+
+func (client *scramClient) Begin(userName, password, authzID string) error {
+
+	nonce, err := newScramNonce()
+	if err != nil {
+		return fmt.Errorf("unable to create SCRAM nonce: %s %v", err.Error(), err)
+	}
+
+	client.username = scramName(userName)
+	client.password = password
+	client.gs2Header = "n,,"
+	if authzID != "" {
+		client.gs2Header = "n,a=" + scramName(authzID) + ","
+	}
+	client.nonce = nonce
+	client.step = 0
+	client.done = false
+	return nil
+}
+
+// Step answers the server message, the first step sends the client-first message.
+func (client *scramClient) Step(challenge string) (string, error) {
+
+	client.step++
+	switch client.step {
+	case 1:
+		client.clientFirstBare = "n=" + client.username + ",r=" + client.nonce
+		return client.gs2Header + client.clientFirstBare, nil
+	case 2:
+		return client.clientFinal(challenge)
+	case 3:
+		client.done = true
+		return "", client.verifyServerFinal(challenge)
+	}
+	return "", errors.New("SCRAM exchange already done")
+}
+
+func (client *scramClient) Done() bool {
+	return client.done
+}
+
+func (client *scramClient) clientFinal(serverFirst string) (string, error) {
+
+	attributes := scramAttributes(serverFirst)
+	nonce := attributes["r"]
+	if !strings.HasPrefix(nonce, client.nonce) || len(nonce) == len(client.nonce) {
+		return "", errors.New("SCRAM server nonce does not extend the client nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(attributes["s"])
+	if err != nil {
+		return "", fmt.Errorf("invalid SCRAM salt: %s %v", err.Error(), err)
+	}
+	iterations, err := strconv.Atoi(attributes["i"])
+	if err != nil || iterations < 1 {
+		return "", fmt.Errorf("invalid SCRAM iteration count %q", attributes["i"])
+	}
+
+	saltedPassword := pbkdf2(client.hash, []byte(client.password), salt, iterations)
+	clientKey := client.hmac(saltedPassword, "Client Key")
+	storedKey := client.hash()
+	storedKey.Write(clientKey)
+
+	clientFinal := "c=" + base64.StdEncoding.EncodeToString([]byte(client.gs2Header)) + ",r=" + nonce
+	authMessage := client.clientFirstBare + "," + serverFirst + "," + clientFinal
+
+	proof := client.hmac(storedKey.Sum(nil), authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	client.serverSignature = client.hmac(client.hmac(saltedPassword, "Server Key"), authMessage)
+
+	return clientFinal + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+func (client *scramClient) verifyServerFinal(serverFinal string) error {
+	attributes := scramAttributes(serverFinal)
+	if message, found := attributes["e"]; found {
+		return fmt.Errorf("SCRAM authentication failed: %s", message)
+	}
+	signature, err := base64.StdEncoding.DecodeString(attributes["v"])
+	if err != nil || !hmac.Equal(signature, client.serverSignature) {
+		return errors.New("SCRAM server signature does not match, the broker does not know the password")
+	}
+	return nil
+}
+
+func (client *scramClient) hmac(key []byte, message string) []byte {
+	mac := hmac.New(client.hash, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// scramName escapes = and , in a user name.
+func scramName(name string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(name)
+}
+
+// scramAttributes splits a SCRAM message like r=abc,s=def,i=4096 into its attributes.
+func scramAttributes(message string) map[string]string {
+	attributes := map[string]string{}
+	for _, attribute := range strings.Split(message, ",") {
+		if len(attribute) > 1 && attribute[1] == '=' {
+			attributes[attribute[:1]] = attribute[2:]
+		}
+	}
+	return attributes
+}
+
+// pbkdf2 derives a key as long as the hash (RFC 8018), the SaltedPassword of SCRAM.
+func pbkdf2(newHash func() hash.Hash, password, salt []byte, iterations int) []byte {
+
+	mac := hmac.New(newHash, password)
+	block := make([]byte, 4)
+	binary.BigEndian.PutUint32(block, 1)
+	mac.Write(salt)
+	mac.Write(block)
+	u := mac.Sum(nil)
+
+	key := append([]byte{}, u...)
+	for n := 1; n < iterations; n++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for i := range key {
+			key[i] ^= u[i]
+		}
+	}
+	return key
+}
@@ -0,0 +1,52 @@
+package cloud_watch
+
+import (
+	"testing"
+)
+
+// TestScramSHA256 follows the example exchange of RFC 7677.
+func TestScramSHA256(t *testing.T) {
+
+	saved := newScramNonce
+	newScramNonce = func() (string, error) { return "rOprNGfwEbeRWgbNEkqO", nil }
+	defer func() { newScramNonce = saved }()
+
+	client := newScramSHA256Client()
+	if err := client.Begin("user", "pencil", ""); err != nil {
+		t.Fatalf("Unable to begin %s", err)
+	}
+
+	message, err := client.Step("")
+	if err != nil || message != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Fatalf("Wrong client-first message %q %v", message, err)
+	}
+
+	message, err = client.Step("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	expected := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	if err != nil || message != expected {
+		t.Fatalf("Wrong client-final message %q %v", message, err)
+	}
+
+	if _, err = client.Step("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err != nil || !client.Done() {
+		t.Errorf("Expected the server signature to be verified %v", err)
+	}
+}
+
+func TestScramRejectsServer(t *testing.T) {
+
+	client := newScramSHA512Client()
+	client.Begin("user", "pencil", "")
+	client.Step("")
+	if _, err := client.Step("r=other,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"); err == nil {
+		t.Error("Expected a server nonce that does not extend the client nonce to be rejected")
+	}
+
+	client = newScramSHA256Client()
+	client.Begin("user", "pencil", "")
+	first, _ := client.Step("")
+	nonce := scramAttributes(first[3:])["r"]
+	client.Step("r=" + nonce + "server,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if _, err := client.Step("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err == nil {
+		t.Error("Expected a wrong server signature to be rejected")
+	}
+}
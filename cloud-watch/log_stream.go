@@ -0,0 +1,147 @@
+package cloud_watch
+
+import (
+	"errors"
+	awsSession "github.com/aws/aws-sdk-go/aws/session"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_LOG_STREAM       = "{name}-{private_ip}-{az}"
+	DEFAULT_LOCAL_LOG_STREAM = "{hostname}"
+)
+
+var errLocalMetadata = errors.New("no instance metadata in local mode")
+
+var (
+	machineIdFile = "/etc/machine-id"
+	bootIdFile    = "/proc/sys/kernel/random/boot_id"
+)
+
+// logStreamNamer expands the log_stream template. Metadata is only looked up for the
+// placeholders the template uses, provider is nil in local mode.
+type logStreamNamer struct {
+	config    *Config
+	provider  InstanceMetadataProvider
+	discovery *metadataDiscovery
+	session   *awsSession.Session
+	err       error
+}
+
+func newLogStreamNamer(provider InstanceMetadataProvider, config *Config, session *awsSession.Session) *logStreamNamer {
+	namer := &logStreamNamer{config: config, provider: provider, session: session}
+	if provider != nil {
+		namer.discovery = newMetadataDiscovery(provider, config)
+	}
+	return namer
+}
+
+// name returns the log stream name for the template. Placeholders:
+// {instance_id}, {region}, {az}, {name}, {tag:Key}, {private_ip}, {public_ip}, {hostname},
+// {machine_id}, {boot_id} and {date:layout}. IP addresses use dashes instead of dots.
+func (namer *logStreamNamer) name(template string) (string, error) {
+	name, err := expandTemplate(template, namer.lookup)
+	if err != nil {
+		return "", err
+	}
+	if namer.err != nil {
+		return "", namer.err
+	}
+	return name, nil
+}
+
+func (namer *logStreamNamer) metadata(field string) string {
+	if namer.err != nil {
+		return ""
+	}
+	if namer.discovery == nil {
+		namer.err = &MetadataError{Field: field, Err: errLocalMetadata}
+		return ""
+	}
+	value, err := namer.discovery.lookup(field, "")
+	if err != nil {
+		namer.err = err
+	}
+	return value
+}
+
+func (namer *logStreamNamer) tag(key string) string {
+	if namer.err != nil {
+		return ""
+	}
+	if namer.provider == nil {
+		namer.err = &MetadataError{Field: "tag " + key, Err: errLocalMetadata}
+		return ""
+	}
+	value, err := namer.provider.Tag(key)
+	if err == nil && value != "" {
+		return value
+	}
+	value, err = findInstanceTag(namer.config.EC2InstanceId, namer.config.AWSRegion, key, namer.session)
+	if err != nil {
+		namer.err = &MetadataError{Field: "tag " + key, Err: err}
+	}
+	return value
+}
+
+func (namer *logStreamNamer) lookup(name string, arg string) (string, bool) {
+
+	switch name {
+	case "instance_id":
+		if namer.config.EC2InstanceId == "" {
+			return namer.metadata(METADATA_INSTANCE_ID), true
+		}
+		return namer.config.EC2InstanceId, true
+	case "region":
+		return namer.config.AWSRegion, true
+	case "az":
+		return namer.metadata(METADATA_AVAILABILITY_ZONE), true
+	case "private_ip":
+		return dashedIp(namer.metadata(METADATA_LOCAL_IPV4)), true
+	case "public_ip":
+		return dashedIp(namer.metadata(METADATA_PUBLIC_IPV4)), true
+	case "name":
+		if namer.provider != nil {
+			if value, err := namer.provider.Name(); err == nil && value != "" {
+				return value, true
+			}
+		}
+		return findInstanceName(namer.config.EC2InstanceId, namer.config.AWSRegion, namer.session), true
+	case "tag":
+		return namer.tag(arg), true
+	case "hostname":
+		hostname, err := os.Hostname()
+		if err != nil && namer.err == nil {
+			namer.err = err
+		}
+		return hostname, true
+	case "machine_id":
+		return namer.readId(machineIdFile), true
+	case "boot_id":
+		return namer.readId(bootIdFile), true
+	case "date":
+		if arg == "" {
+			arg = "2006-01-02"
+		}
+		return time.Now().UTC().Format(arg), true
+	}
+	return "", false
+}
+
+func (namer *logStreamNamer) readId(filename string) string {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if namer.err == nil {
+			namer.err = err
+		}
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func dashedIp(ip string) string {
+	return strings.Replace(ip, ".", "-", -1)
+}
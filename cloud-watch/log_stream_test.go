@@ -0,0 +1,83 @@
+package cloud_watch
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func writeIdFile(t *testing.T, id string) string {
+	file, err := ioutil.TempFile("", "id")
+	if err != nil {
+		t.Fatalf("Unable to create id file %s", err)
+	}
+	file.WriteString(id + "\n")
+	file.Close()
+	return file.Name()
+}
+
+func TestLogStreamTemplate(t *testing.T) {
+
+	imds := newFakeIMDS()
+	server := httptest.NewServer(imds)
+	defer server.Close()
+
+	machineIdFile = writeIdFile(t, "4b1a2c")
+	bootIdFile = writeIdFile(t, "9f8e7d")
+	defer func() {
+		os.Remove(machineIdFile)
+		os.Remove(bootIdFile)
+		machineIdFile = "/etc/machine-id"
+		bootIdFile = "/proc/sys/kernel/random/boot_id"
+	}()
+
+	config, _ := LoadConfigFromString(`
+metadata_endpoint="`+server.URL+`"
+log_stream="{tag:Team}/{instance_id}/{public_ip}/{machine_id}/{boot_id}/{date:2006}"
+`, nil)
+
+	provider, _ := NewInstanceMetadataProvider(config)
+	_, err := getRegion(provider, config, nil)
+	if err != nil {
+		t.Fatalf("Unable to name log stream %s", err)
+	}
+
+	expected := "payments/i-0123456789/54-1-2-3/4b1a2c/9f8e7d/" + time.Now().UTC().Format("2006")
+	if config.LogStreamName != expected {
+		t.Errorf("Expected %s got %s", expected, config.LogStreamName)
+	}
+}
+
+func TestLogStreamLocalMode(t *testing.T) {
+
+	config, _ := LoadConfigFromString(`local=true`, nil)
+	_, err := getRegion(nil, config, nil)
+	if err != nil {
+		t.Fatalf("Unable to name log stream %s", err)
+	}
+
+	hostname, _ := os.Hostname()
+	if config.LogStreamName != hostname {
+		t.Errorf("Expected hostname as default log stream got %s", config.LogStreamName)
+	}
+
+	config, _ = LoadConfigFromString(`
+local=true
+log_stream="{hostname}-{az}"
+`, nil)
+	_, err = getRegion(nil, config, nil)
+	if _, ok := err.(*MetadataError); !ok {
+		t.Errorf("Expected a MetadataError for {az} in local mode got %v", err)
+	}
+
+	config, _ = LoadConfigFromString(`
+local=true
+log_stream="{nope}"
+`, nil)
+	_, err = getRegion(nil, config, nil)
+	if err == nil {
+		t.Error("Unknown placeholder should be an error")
+	}
+}
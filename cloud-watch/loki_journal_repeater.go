@@ -0,0 +1,251 @@
+package cloud_watch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	lg "github.com/advantageous/go-logback/logging"
+	"github.com/golang/snappy"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const lokiPushPath = "/loki/api/v1/push"
+
+// LokiJournalRepeater pushes records to Grafana Loki, one stream per distinct label set.
+type LokiJournalRepeater struct {
+	client   *http.Client
+	pushURL  string
+	labels   []string
+	protobuf bool
+	tenantId string
+	logger   lg.Logger
+	config   *Config
+}
+
+type lokiEntry struct {
+	timestamp time.Time
+	line      string
+}
+
+type lokiStream struct {
+	labels  map[string]string
+	entries []lokiEntry
+}
+
+// NewLokiJournalRepeater creates a repeater that pushes to the Loki instance at loki_url.
+func NewLokiJournalRepeater(logger lg.Logger, config *Config) (*LokiJournalRepeater, error) {
+
+	if config.LokiURL == "" {
+		return nil, errors.New("loki_url must be set to use the loki repeater")
+	}
+
+	if config.LokiFormat != "json" && config.LokiFormat != "protobuf" {
+		return nil, fmt.Errorf("loki_format must be json or protobuf, not %s", config.LokiFormat)
+	}
+
+	if logger == nil {
+		if !config.Debug {
+			logger = lg.GetSimpleLogger("LOKI_REPEATER_DEBUG", "loki-repeater")
+		} else {
+			logger = lg.NewSimpleDebugLogger("loki-repeater")
+		}
+	}
+
+	return &LokiJournalRepeater{
+		client:   &http.Client{Timeout: time.Duration(config.LokiTimeoutMS) * time.Millisecond},
+		pushURL:  strings.TrimRight(config.LokiURL, "/") + lokiPushPath,
+		labels:   config.LokiLabels,
+		protobuf: config.LokiFormat == "protobuf",
+		tenantId: config.LokiTenantId,
+		logger:   logger,
+		config:   config,
+	}, nil
+}
+
+func (repeater *LokiJournalRepeater) Close() error {
+	return nil
+}
+
+func (repeater *LokiJournalRepeater) WriteBatch(records []*Record) error {
+
+	streams, err := repeater.groupStreams(records)
+	if err != nil {
+		return err
+	}
+
+	var body []byte
+	var contentType string
+
+	if repeater.protobuf {
+		body = snappy.Encode(nil, encodeLokiProtobuf(streams))
+		contentType = "application/x-protobuf"
+	} else {
+		body, err = encodeLokiJson(streams)
+		if err != nil {
+			return err
+		}
+		contentType = "application/json"
+	}
+
+	request, err := http.NewRequest("POST", repeater.pushURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", contentType)
+
+	if repeater.tenantId != "" {
+		request.Header.Set("X-Scope-OrgID", repeater.tenantId)
+	}
+
+	response, err := repeater.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		responseBody, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("loki push failed with status %d: %s", response.StatusCode, string(responseBody))
+	}
+
+	if repeater.config.Debug {
+		repeater.logger.Debug("SENT SUCCESSFULLY")
+	}
+	return nil
+}
+
+// groupStreams splits the records into streams by label set, entries are sorted by time
+// within each stream as Loki rejects out of order entries.
+func (repeater *LokiJournalRepeater) groupStreams(records []*Record) ([]*lokiStream, error) {
+
+	streams := make([]*lokiStream, 0)
+	byKey := make(map[string]*lokiStream)
+
+	for _, record := range records {
+
+		labels := make(map[string]string)
+		for _, label := range repeater.labels {
+			value, found := recordFieldValue(record, label)
+			if !found {
+				return nil, fmt.Errorf("unknown loki label %s", label)
+			}
+			if value != "" {
+				labels[label] = value
+			}
+		}
+
+		line, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+
+		key := lokiLabelString(labels)
+		stream, found := byKey[key]
+		if !found {
+			stream = &lokiStream{labels: labels}
+			byKey[key] = stream
+			streams = append(streams, stream)
+		}
+		stream.entries = append(stream.entries, lokiEntry{recordTime(record), string(line)})
+	}
+
+	for _, stream := range streams {
+		entries := stream.entries
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].timestamp.Before(entries[j].timestamp)
+		})
+	}
+
+	return streams, nil
+}
+
+// lokiLabelString renders labels in the Prometheus {name="value"} form with sorted names.
+func lokiLabelString(labels map[string]string) string {
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+strconv.Quote(labels[name]))
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+func encodeLokiJson(streams []*lokiStream) ([]byte, error) {
+
+	type jsonStream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+
+	request := struct {
+		Streams []jsonStream `json:"streams"`
+	}{make([]jsonStream, 0, len(streams))}
+
+	for _, stream := range streams {
+		values := make([][2]string, 0, len(stream.entries))
+		for _, entry := range stream.entries {
+			values = append(values, [2]string{strconv.FormatInt(entry.timestamp.UnixNano(), 10), entry.line})
+		}
+		request.Streams = append(request.Streams, jsonStream{stream.labels, values})
+	}
+
+	return json.Marshal(request)
+}
+
+// encodeLokiProtobuf hand encodes a logproto.PushRequest:
+//
+//	PushRequest  { repeated StreamAdapter streams = 1; }
+//	StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+//	EntryAdapter { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+func encodeLokiProtobuf(streams []*lokiStream) []byte {
+
+	request := make([]byte, 0)
+
+	for _, stream := range streams {
+
+		streamBytes := appendProtoBytes(nil, 1, []byte(lokiLabelString(stream.labels)))
+
+		for _, entry := range stream.entries {
+			timestamp := appendProtoVarint(nil, 1, uint64(entry.timestamp.Unix()))
+			timestamp = appendProtoVarint(timestamp, 2, uint64(entry.timestamp.Nanosecond()))
+
+			entryBytes := appendProtoBytes(nil, 1, timestamp)
+			entryBytes = appendProtoBytes(entryBytes, 2, []byte(entry.line))
+
+			streamBytes = appendProtoBytes(streamBytes, 2, entryBytes)
+		}
+
+		request = appendProtoBytes(request, 1, streamBytes)
+	}
+
+	return request
+}
+
+func appendVarint(buf []byte, value uint64) []byte {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(scratch[:], value)
+	return append(buf, scratch[:n]...)
+}
+
+func appendProtoVarint(buf []byte, field int, value uint64) []byte {
+	buf = appendVarint(buf, uint64(field<<3))
+	return appendVarint(buf, value)
+}
+
+func appendProtoBytes(buf []byte, field int, value []byte) []byte {
+	buf = appendVarint(buf, uint64(field<<3|2))
+	buf = appendVarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
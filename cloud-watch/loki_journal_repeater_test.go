@@ -0,0 +1,110 @@
+package cloud_watch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/golang/snappy"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var lokiTestRecords = []*Record{
+	{Message: "late", SystemdUnit: "sshd.service", Hostname: "a", TimeUsec: 1480459022030000},
+	{Message: "cron", SystemdUnit: "cron.service", Hostname: "a", TimeUsec: 1480459022020000},
+	{Message: "early", SystemdUnit: "sshd.service", Hostname: "a", TimeUsec: 1480459022010000},
+}
+
+func TestLokiRepeaterJson(t *testing.T) {
+
+	var pushed struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"streams"`
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != lokiPushPath {
+			t.Errorf("Wrong path %s", r.URL.Path)
+		}
+		if r.Header.Get("X-Scope-OrgID") != "team-a" {
+			t.Errorf("Tenant header missing")
+		}
+		json.NewDecoder(r.Body).Decode(&pushed)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	config, _ := LoadConfigFromString(`
+repeater="loki"
+loki_url="`+server.URL+`"
+loki_tenant_id="team-a"
+loki_labels=["unit", "hostname"]
+`, nil)
+
+	repeater, err := NewLokiJournalRepeater(nil, config)
+	if err != nil {
+		t.Fatalf("Unable to create repeater %s", err)
+	}
+
+	err = repeater.WriteBatch(lokiTestRecords)
+	if err != nil {
+		t.Fatalf("Unable to write batch %s", err)
+	}
+
+	if len(pushed.Streams) != 2 {
+		t.Fatalf("Expected two streams got %d", len(pushed.Streams))
+	}
+
+	sshd := pushed.Streams[0]
+	if sshd.Stream["unit"] != "sshd.service" || sshd.Stream["hostname"] != "a" {
+		t.Errorf("Wrong labels %v", sshd.Stream)
+	}
+
+	if len(sshd.Values) != 2 || sshd.Values[0][0] != "1480459022010000000" {
+		t.Errorf("Entries not sorted %v", sshd.Values)
+	}
+}
+
+func TestLokiRepeaterProtobuf(t *testing.T) {
+
+	var body []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("Wrong content type %s", r.Header.Get("Content-Type"))
+		}
+		compressed, _ := ioutil.ReadAll(r.Body)
+		body, _ = snappy.Decode(nil, compressed)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	config, _ := LoadConfigFromString(`
+loki_url="`+server.URL+`"
+loki_format="protobuf"
+loki_labels=["unit"]
+`, nil)
+
+	repeater, _ := NewLokiJournalRepeater(nil, config)
+
+	err := repeater.WriteBatch(lokiTestRecords[:1])
+	if err != nil {
+		t.Fatalf("Unable to write batch %s", err)
+	}
+
+	labels := `{unit="sshd.service"}`
+	if len(body) == 0 || body[0] != 0x0a {
+		t.Fatalf("Push request does not start with a stream %v", body)
+	}
+	streamLength, n := binary.Uvarint(body[1:])
+	if int(streamLength) != len(body)-1-n {
+		t.Errorf("Stream length %d does not match body", streamLength)
+	}
+	if !bytes.Contains(body, append([]byte{0x0a, byte(len(labels))}, labels...)) {
+		t.Errorf("Labels not encoded %q", body)
+	}
+}
@@ -0,0 +1,464 @@
+package cloud_watch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	awsCredentials "github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/hashicorp/hcl"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	METADATA_PROVIDER_IMDS = "imds"
+	METADATA_PROVIDER_ECS  = "ecs"
+	METADATA_PROVIDER_FILE = "file"
+)
+
+// InstanceMetadataProvider supplies the details of the host the agent runs on, used for the
+// region and for the default log stream name (name-ip-az).
+type InstanceMetadataProvider interface {
+	Region() (string, error)
+	InstanceId() (string, error)
+	AvailabilityZone() (string, error)
+	LocalIPv4() (string, error)
+	PublicIPv4() (string, error)
+
+	// Tag returns the value of an instance tag, providers without tags return an error.
+	Tag(key string) (string, error)
+
+	// Name returns a human friendly name for the host, an error means the caller should
+	// look the name up another way (e.g. the EC2 Name tag).
+	Name() (string, error)
+}
+
+// NewInstanceMetadataProvider creates the provider selected by metadata_provider.
+func NewInstanceMetadataProvider(config *Config) (InstanceMetadataProvider, error) {
+
+	switch config.MetadataProvider {
+	case METADATA_PROVIDER_IMDS:
+		return NewIMDSMetadataProvider(config), nil
+	case METADATA_PROVIDER_ECS:
+		return NewECSMetadataProvider(config)
+	case METADATA_PROVIDER_FILE:
+		return NewStaticMetadataProvider(config.MetadataFile)
+	default:
+		return nil, fmt.Errorf("metadata_provider must be imds, ecs or file, not %s", config.MetadataProvider)
+	}
+}
+
+type metadataStatusError struct {
+	url    string
+	status int
+}
+
+func (err *metadataStatusError) Error() string {
+	return fmt.Sprintf("metadata request %s failed with status %d", err.url, err.status)
+}
+
+func newMetadataHTTPClient(config *Config) *http.Client {
+
+	dialer := &net.Dialer{Timeout: time.Duration(config.MetadataTimeoutMS) * time.Millisecond}
+
+	return &http.Client{
+		Timeout:   time.Duration(config.MetadataTimeoutMS) * time.Millisecond,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+	}
+}
+
+func httpGetString(client *http.Client, request *http.Request) (string, error) {
+
+	response, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", &metadataStatusError{request.URL.String(), response.StatusCode}
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// IMDSMetadataProvider reads the EC2 instance metadata service. It uses IMDSv2 session
+// tokens and falls back to IMDSv1 when the token endpoint is not available.
+type IMDSMetadataProvider struct {
+	endpoint     string
+	client       *http.Client
+	tokenTTL     int
+	token        string
+	tokenExpires time.Time
+	v1           bool
+}
+
+func NewIMDSMetadataProvider(config *Config) *IMDSMetadataProvider {
+	return &IMDSMetadataProvider{
+		endpoint: strings.TrimRight(config.MetadataEndpoint, "/"),
+		client:   newMetadataHTTPClient(config),
+		tokenTTL: config.MetadataTokenTTLSeconds,
+	}
+}
+
+func (provider *IMDSMetadataProvider) getToken() (string, error) {
+
+	if provider.v1 {
+		return "", nil
+	}
+
+	if provider.token != "" && time.Now().Before(provider.tokenExpires) {
+		return provider.token, nil
+	}
+
+	request, err := http.NewRequest("PUT", provider.endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", strconv.Itoa(provider.tokenTTL))
+
+	token, err := httpGetString(provider.client, request)
+	if err != nil {
+		if statusErr, ok := err.(*metadataStatusError); ok {
+			switch statusErr.status {
+			case http.StatusForbidden, http.StatusNotFound, http.StatusMethodNotAllowed:
+				awsLogger.Warnf("IMDSv2 token not available, using IMDSv1 : %s", err.Error())
+				provider.v1 = true
+				return "", nil
+			}
+		}
+		return "", err
+	}
+
+	provider.token = token
+	// Renew a minute early so a token never expires between fetch and use.
+	provider.tokenExpires = time.Now().Add(time.Duration(provider.tokenTTL)*time.Second - time.Minute)
+	return token, nil
+}
+
+// GetMetadata reads a path below /latest/meta-data/.
+func (provider *IMDSMetadataProvider) GetMetadata(path string) (string, error) {
+
+	for attempt := 0; attempt < 2; attempt++ {
+
+		token, err := provider.getToken()
+		if err != nil {
+			return "", err
+		}
+
+		request, err := http.NewRequest("GET", provider.endpoint+"/latest/meta-data/"+path, nil)
+		if err != nil {
+			return "", err
+		}
+		if token != "" {
+			request.Header.Set("X-aws-ec2-metadata-token", token)
+		}
+
+		value, err := httpGetString(provider.client, request)
+		if statusErr, ok := err.(*metadataStatusError); ok && statusErr.status == http.StatusUnauthorized {
+			// The token expired or was revoked, get a new one and try again.
+			provider.token = ""
+			continue
+		}
+		return value, err
+	}
+
+	return "", errors.New("metadata token rejected twice for " + path)
+}
+
+func (provider *IMDSMetadataProvider) Region() (string, error) {
+	region, err := provider.GetMetadata("placement/region")
+	if err == nil {
+		return region, nil
+	}
+	az, azErr := provider.AvailabilityZone()
+	if azErr != nil || az == "" {
+		return "", err
+	}
+	return az[:len(az)-1], nil
+}
+
+func (provider *IMDSMetadataProvider) InstanceId() (string, error) {
+	return provider.GetMetadata("instance-id")
+}
+
+func (provider *IMDSMetadataProvider) AvailabilityZone() (string, error) {
+	return provider.GetMetadata("placement/availability-zone")
+}
+
+func (provider *IMDSMetadataProvider) LocalIPv4() (string, error) {
+	return provider.GetMetadata("local-ipv4")
+}
+
+func (provider *IMDSMetadataProvider) PublicIPv4() (string, error) {
+	return provider.GetMetadata("public-ipv4")
+}
+
+// Tag reads an instance tag, tags are only in the metadata when instance tags are enabled.
+func (provider *IMDSMetadataProvider) Tag(key string) (string, error) {
+	return provider.GetMetadata("tags/instance/" + key)
+}
+
+func (provider *IMDSMetadataProvider) Name() (string, error) {
+	return provider.Tag("Name")
+}
+
+const IMDS_ROLE_PROVIDER_NAME = "IMDSRoleProvider"
+
+// IMDSRoleProvider reads the credentials of the instance role with the IMDS provider, so they
+// use the same metadata_endpoint, session token and timeouts as the other metadata.
+type IMDSRoleProvider struct {
+	awsCredentials.Expiry
+	metadata *IMDSMetadataProvider
+}
+
+type imdsRoleCredentials struct {
+	Code            string
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+func NewIMDSRoleProvider(metadata *IMDSMetadataProvider) *IMDSRoleProvider {
+	return &IMDSRoleProvider{metadata: metadata}
+}
+
+// Retrieve reads the credentials of the first role of the instance profile, they are renewed
+// five minutes before they expire.
+func (provider *IMDSRoleProvider) Retrieve() (awsCredentials.Value, error) {
+
+	value := awsCredentials.Value{ProviderName: IMDS_ROLE_PROVIDER_NAME}
+
+	roles, err := provider.metadata.GetMetadata("iam/security-credentials/")
+	if err != nil {
+		return value, fmt.Errorf("unable to list the instance roles: %s %v", err.Error(), err)
+	}
+	role := strings.TrimSpace(strings.SplitN(strings.TrimSpace(roles), "\n", 2)[0])
+	if role == "" {
+		return value, errors.New("the instance has no role")
+	}
+
+	data, err := provider.metadata.GetMetadata("iam/security-credentials/" + role)
+	if err != nil {
+		return value, fmt.Errorf("unable to read the credentials of role %s: %s %v", role, err.Error(), err)
+	}
+	var credentials imdsRoleCredentials
+	if err := json.Unmarshal([]byte(data), &credentials); err != nil {
+		return value, fmt.Errorf("unable to parse the credentials of role %s: %s %v", role, err.Error(), err)
+	}
+	if credentials.Code != "Success" {
+		return value, fmt.Errorf("credentials of role %s not available: %s", role, credentials.Code)
+	}
+
+	provider.SetExpiration(credentials.Expiration, 5*time.Minute)
+	value.AccessKeyID = credentials.AccessKeyId
+	value.SecretAccessKey = credentials.SecretAccessKey
+	value.SessionToken = credentials.Token
+	return value, nil
+}
+
+// ECSMetadataProvider reads the ECS task metadata endpoint (version 4), for tasks on
+// Fargate or with no access to the instance metadata service.
+type ECSMetadataProvider struct {
+	endpoint  string
+	client    *http.Client
+	task      *ecsTaskMetadata
+	container *ecsContainerMetadata
+}
+
+type ecsTaskMetadata struct {
+	Cluster          string `json:"Cluster"`
+	TaskARN          string `json:"TaskARN"`
+	Family           string `json:"Family"`
+	AvailabilityZone string `json:"AvailabilityZone"`
+}
+
+type ecsContainerMetadata struct {
+	Networks []struct {
+		IPv4Addresses []string `json:"IPv4Addresses"`
+	} `json:"Networks"`
+}
+
+func NewECSMetadataProvider(config *Config) (*ECSMetadataProvider, error) {
+
+	endpoint := config.MetadataEndpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("ECS_CONTAINER_METADATA_URI_V4")
+	}
+	if endpoint == "" {
+		return nil, errors.New("ECS_CONTAINER_METADATA_URI_V4 is not set, not running in an ECS task")
+	}
+
+	return &ECSMetadataProvider{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		client:   newMetadataHTTPClient(config),
+	}, nil
+}
+
+func (provider *ECSMetadataProvider) getJson(path string, value interface{}) error {
+
+	request, err := http.NewRequest("GET", provider.endpoint+path, nil)
+	if err != nil {
+		return err
+	}
+	body, err := httpGetString(provider.client, request)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(body), value)
+}
+
+func (provider *ECSMetadataProvider) getTask() (*ecsTaskMetadata, error) {
+	if provider.task == nil {
+		task := &ecsTaskMetadata{}
+		err := provider.getJson("/task", task)
+		if err != nil {
+			return nil, err
+		}
+		provider.task = task
+	}
+	return provider.task, nil
+}
+
+// Region is taken from the task ARN, arn:aws:ecs:<region>:<account>:task/<cluster>/<id>.
+func (provider *ECSMetadataProvider) Region() (string, error) {
+	task, err := provider.getTask()
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(task.TaskARN, ":")
+	if len(parts) < 6 {
+		return "", fmt.Errorf("unexpected task ARN %s", task.TaskARN)
+	}
+	return parts[3], nil
+}
+
+// InstanceId is the task id, tasks on Fargate have no EC2 instance.
+func (provider *ECSMetadataProvider) InstanceId() (string, error) {
+	task, err := provider.getTask()
+	if err != nil {
+		return "", err
+	}
+	return task.TaskARN[strings.LastIndex(task.TaskARN, "/")+1:], nil
+}
+
+func (provider *ECSMetadataProvider) AvailabilityZone() (string, error) {
+	task, err := provider.getTask()
+	if err != nil {
+		return "", err
+	}
+	if task.AvailabilityZone == "" {
+		return "", errors.New("task metadata has no AvailabilityZone")
+	}
+	return task.AvailabilityZone, nil
+}
+
+func (provider *ECSMetadataProvider) LocalIPv4() (string, error) {
+	if provider.container == nil {
+		container := &ecsContainerMetadata{}
+		err := provider.getJson("", container)
+		if err != nil {
+			return "", err
+		}
+		provider.container = container
+	}
+	for _, network := range provider.container.Networks {
+		if len(network.IPv4Addresses) > 0 {
+			return network.IPv4Addresses[0], nil
+		}
+	}
+	return "", errors.New("container metadata has no IPv4 address")
+}
+
+func (provider *ECSMetadataProvider) PublicIPv4() (string, error) {
+	return "", errors.New("task metadata has no public IPv4 address")
+}
+
+func (provider *ECSMetadataProvider) Tag(key string) (string, error) {
+	return "", errors.New("task metadata has no tags")
+}
+
+// Name is the task family.
+func (provider *ECSMetadataProvider) Name() (string, error) {
+	task, err := provider.getTask()
+	if err != nil {
+		return "", err
+	}
+	return task.Family, nil
+}
+
+// StaticMetadataProvider reads fixed values from an HCL or JSON file, for hosts outside of
+// AWS and for tests.
+type StaticMetadataProvider struct {
+	RegionValue           string            `hcl:"region"`
+	InstanceIdValue       string            `hcl:"instance_id"`
+	AvailabilityZoneValue string            `hcl:"availability_zone"`
+	LocalIPv4Value        string            `hcl:"local_ipv4"`
+	PublicIPv4Value       string            `hcl:"public_ipv4"`
+	NameValue             string            `hcl:"name"`
+	Tags                  map[string]string `hcl:"tags"`
+}
+
+func NewStaticMetadataProvider(filename string) (*StaticMetadataProvider, error) {
+
+	if filename == "" {
+		return nil, errors.New("metadata_file must be set to use the file metadata provider")
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := &StaticMetadataProvider{}
+	err = hcl.Decode(provider, string(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse metadata_file %s: %s %v", filename, err.Error(), err)
+	}
+	return provider, nil
+}
+
+func staticValue(name string, value string) (string, error) {
+	if value == "" {
+		return "", fmt.Errorf("%s not set in metadata file", name)
+	}
+	return value, nil
+}
+
+func (provider *StaticMetadataProvider) Region() (string, error) {
+	return staticValue("region", provider.RegionValue)
+}
+
+func (provider *StaticMetadataProvider) InstanceId() (string, error) {
+	return staticValue("instance_id", provider.InstanceIdValue)
+}
+
+func (provider *StaticMetadataProvider) AvailabilityZone() (string, error) {
+	return staticValue("availability_zone", provider.AvailabilityZoneValue)
+}
+
+func (provider *StaticMetadataProvider) LocalIPv4() (string, error) {
+	return staticValue("local_ipv4", provider.LocalIPv4Value)
+}
+
+func (provider *StaticMetadataProvider) PublicIPv4() (string, error) {
+	return staticValue("public_ipv4", provider.PublicIPv4Value)
+}
+
+func (provider *StaticMetadataProvider) Tag(key string) (string, error) {
+	return staticValue("tags."+key, provider.Tags[key])
+}
+
+func (provider *StaticMetadataProvider) Name() (string, error) {
+	return staticValue("name", provider.NameValue)
+}
@@ -0,0 +1,208 @@
+package cloud_watch
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// fakeIMDS serves instance metadata and, like an instance with HttpTokens=required,
+// rejects any metadata request without a session token.
+type fakeIMDS struct {
+	values      map[string]string
+	tokenPuts   int
+	failures    int
+	unavailable bool
+}
+
+func (imds *fakeIMDS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method == "PUT" && r.URL.Path == "/latest/api/token" {
+		if r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		imds.tokenPuts++
+		w.Write([]byte("test-token"))
+		return
+	}
+
+	if r.Header.Get("X-aws-ec2-metadata-token") != "test-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if imds.failures > 0 {
+		imds.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	value, found := imds.values[strings.TrimPrefix(r.URL.Path, "/latest/meta-data/")]
+	if !found || imds.unavailable {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Write([]byte(value))
+}
+
+func newFakeIMDS() *fakeIMDS {
+	return &fakeIMDS{values: map[string]string{
+		"instance-id":                 "i-0123456789",
+		"placement/availability-zone": "us-west-2b",
+		"placement/region":            "us-west-2",
+		"local-ipv4":                  "10.0.1.15",
+		"public-ipv4":                 "54.1.2.3",
+		"tags/instance/Name":          "web",
+		"tags/instance/Team":          "payments",
+	}}
+}
+
+func TestIMDSv2LogStreamName(t *testing.T) {
+
+	imds := newFakeIMDS()
+	server := httptest.NewServer(imds)
+	defer server.Close()
+
+	config, _ := LoadConfigFromString(`metadata_endpoint="`+server.URL+`"`, nil)
+
+	provider, err := NewInstanceMetadataProvider(config)
+	if err != nil {
+		t.Fatalf("Unable to create provider %s", err)
+	}
+
+	region, err := getRegion(provider, config, nil)
+	if err != nil {
+		t.Fatalf("Unable to discover region %s", err)
+	}
+
+	if region != "us-west-2" || config.EC2InstanceId != "i-0123456789" {
+		t.Errorf("Wrong region or instance id %s %s", region, config.EC2InstanceId)
+	}
+
+	if config.LogStreamName != "web-10-0-1-15-us-west-2b" {
+		t.Errorf("Wrong log stream name %s", config.LogStreamName)
+	}
+
+	if imds.tokenPuts != 1 {
+		t.Errorf("Token should be fetched once and reused, fetched %d times", imds.tokenPuts)
+	}
+}
+
+func TestIMDSRegionFromAvailabilityZone(t *testing.T) {
+
+	imds := newFakeIMDS()
+	delete(imds.values, "placement/region")
+	server := httptest.NewServer(imds)
+	defer server.Close()
+
+	config, _ := LoadConfigFromString(`metadata_endpoint="`+server.URL+`"`, nil)
+
+	region, err := NewIMDSMetadataProvider(config).Region()
+	if err != nil || region != "us-west-2" {
+		t.Errorf("Expected region from availability zone got %s %v", region, err)
+	}
+}
+
+func TestIMDSRoleCredentials(t *testing.T) {
+
+	imds := newFakeIMDS()
+	imds.values["iam/security-credentials/"] = "journal-role\n"
+	imds.values["iam/security-credentials/journal-role"] = `{"Code":"Success","AccessKeyId":"ROLEKEY",
+		"SecretAccessKey":"ROLESECRET","Token":"ROLETOKEN","Expiration":"2099-01-01T00:00:00Z"}`
+	server := httptest.NewServer(imds)
+	defer server.Close()
+
+	defer clearCredentialsEnv()()
+	config, _ := LoadConfigFromString(`metadata_endpoint="`+server.URL+`"
+aws_credentials_file="/nonexistent"`, nil)
+
+	value, err := getCredentials(config, NewIMDSMetadataProvider(config), "us-west-2").Get()
+	if err != nil {
+		t.Fatalf("Unable to get role credentials %s", err)
+	}
+	if value.AccessKeyID != "ROLEKEY" || value.SessionToken != "ROLETOKEN" || value.ProviderName != IMDS_ROLE_PROVIDER_NAME {
+		t.Errorf("Role credentials not read from metadata_endpoint %+v", value)
+	}
+
+	delete(imds.values, "iam/security-credentials/")
+	if _, err = NewIMDSRoleProvider(NewIMDSMetadataProvider(config)).Retrieve(); err == nil {
+		t.Error("Expected an error for an instance without a role")
+	}
+}
+
+func TestECSMetadataProvider(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v4/abc/task":
+			w.Write([]byte(`{"Cluster":"prod","Family":"api",
+				"TaskARN":"arn:aws:ecs:eu-west-1:123456789012:task/prod/0f9de9b1c2",
+				"AvailabilityZone":"eu-west-1c"}`))
+		case "/v4/abc":
+			w.Write([]byte(`{"Networks":[{"NetworkMode":"awsvpc","IPv4Addresses":["10.1.2.3"]}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config, _ := LoadConfigFromString(`
+metadata_provider="ecs"
+metadata_endpoint="`+server.URL+`/v4/abc"
+`, nil)
+
+	provider, err := NewInstanceMetadataProvider(config)
+	if err != nil {
+		t.Fatalf("Unable to create provider %s", err)
+	}
+
+	region, err := getRegion(provider, config, nil)
+	if err != nil {
+		t.Fatalf("Unable to discover region %s", err)
+	}
+
+	if region != "eu-west-1" || config.EC2InstanceId != "0f9de9b1c2" {
+		t.Errorf("Wrong region or task id %s %s", region, config.EC2InstanceId)
+	}
+
+	if config.LogStreamName != "api-10-1-2-3-eu-west-1c" {
+		t.Errorf("Wrong log stream name %s", config.LogStreamName)
+	}
+}
+
+func TestStaticMetadataProvider(t *testing.T) {
+
+	file, _ := ioutil.TempFile("", "metadata")
+	defer os.Remove(file.Name())
+	file.WriteString(`
+region="ap-southeast-2"
+instance_id="build-01"
+availability_zone="ap-southeast-2a"
+local_ipv4="192.168.0.10"
+name="build"
+`)
+	file.Close()
+
+	config, _ := LoadConfigFromString(`
+metadata_provider="file"
+metadata_file="`+file.Name()+`"
+`, nil)
+
+	provider, err := NewInstanceMetadataProvider(config)
+	if err != nil {
+		t.Fatalf("Unable to create provider %s", err)
+	}
+
+	_, err = getRegion(provider, config, nil)
+	if err != nil {
+		t.Fatalf("Unable to discover region %s", err)
+	}
+
+	if config.LogStreamName != "build-192-168-0-10-ap-southeast-2a" {
+		t.Errorf("Wrong log stream name %s", config.LogStreamName)
+	}
+}
@@ -1,6 +1,7 @@
 package cloud_watch
 
 import (
+	"strconv"
 	"sync/atomic"
 	"time"
 	lg "github.com/advantageous/go-logback/logging"
@@ -10,6 +11,7 @@ type MockJournal interface {
 	Journal
 	SetCount(uint64)
 	SetError(error)
+	SetBoots([]JournalBoot)
 }
 
 type TestJournal struct {
@@ -17,6 +19,9 @@ type TestJournal struct {
 	logger lg.Logger
 	count  int64
 	err    error
+	boots  []JournalBoot
+	// seek is the last position sought to, e.g. head, tail, realtime:<usec> or cursor:<cursor>.
+	seek string
 }
 
 type MockJournalRepeater struct {
@@ -80,6 +85,10 @@ func (journal *TestJournal) SetError(err error) {
 
 }
 
+func (journal *TestJournal) SetBoots(boots []JournalBoot) {
+	journal.boots = boots
+}
+
 func NewJournalWithMap(values map[string]string) Journal {
 	logger := lg.NewSimpleLogger("test-journal")
 	return &TestJournal{
@@ -143,7 +152,7 @@ func (journal *TestJournal) GetDataValue(field string) (string, error) {
 // journal entry.
 func (journal *TestJournal) GetRealtimeUsec() (uint64, error) {
 	journal.logger.Info("GetRealtimeUsec")
-	return 1480549576015541 / 1000, nil
+	return 1480549576015541, nil
 }
 
 func (journal *TestJournal) AddLogFilters(config *Config) {
@@ -159,26 +168,51 @@ func (journal *TestJournal) GetMonotonicUsec() (uint64, error) {
 // GetCursor gets the cursor of the current journal entry.
 func (journal *TestJournal) GetCursor() (string, error) {
 	journal.logger.Info("GetCursor")
+	if cursor, found := journal.values["__CURSOR"]; found {
+		return cursor, nil
+	}
 	return "abc-123", nil
 }
 
 // SeekHead seeks to the beginning of the journal, i.e. the oldest available
 // entry.
 func (journal *TestJournal) SeekHead() error {
+	journal.seek = "head"
 	return nil
 }
 
 // SeekTail may be used to seek to the end of the journal, i.e. the most recent
 // available entry.
 func (journal *TestJournal) SeekTail() error {
+	journal.seek = "tail"
 	return nil
 }
 
 // SeekCursor seeks to a concrete journal cursor.
 func (journal *TestJournal) SeekCursor(cursor string) error {
+	journal.seek = "cursor:" + cursor
+	return nil
+}
+
+// SeekRealtimeUsec seeks to the entry with the specified realtime (wallclock)
+// timestamp, i.e. CLOCK_REALTIME.
+func (journal *TestJournal) SeekRealtimeUsec(usec uint64) error {
+	journal.logger.Info("SeekRealtimeUsec")
+	journal.seek = "realtime:" + strconv.FormatUint(usec, 10)
 	return nil
 }
 
+// AddMatch adds a match by which to filter the entries of the journal.
+func (journal *TestJournal) AddMatch(match string) error {
+	journal.logger.Info("AddMatch", match)
+	return nil
+}
+
+// ListBoots lists the boots set with SetBoots.
+func (journal *TestJournal) ListBoots() ([]JournalBoot, error) {
+	return journal.boots, nil
+}
+
 // Wait will synchronously wait until the journal gets changed. The maximum time
 // this call sleeps may be controlled with the timeout parameter.  If
 // sdjournal.IndefiniteWait is passed as the timeout parameter, Wait will
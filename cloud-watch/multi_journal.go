@@ -0,0 +1,387 @@
+package cloud_watch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// JOURNAL_SOURCE_HOST is the source of the system journal or journal_dir.
+	JOURNAL_SOURCE_HOST = "host"
+	// JOURNAL_SOURCE_NAMESPACE_PREFIX starts the source of a journal namespace, e.g. ns:audit.
+	JOURNAL_SOURCE_NAMESPACE_PREFIX = "ns:"
+)
+
+// journalRoots are searched for namespace journals, persistent storage first.
+var journalRoots = []string{"/var/log/journal", "/run/log/journal"}
+
+// SourceJournal is implemented by journals that read several sources. Source names the
+// source of the current entry, NewRecord copies it to the record.
+type SourceJournal interface {
+	Source() string
+}
+
+type journalSource struct {
+	name    string
+	dir     string
+	journal Journal
+	// pending is set when the journal is on an entry that was not returned yet.
+	pending bool
+	usec    uint64
+	// cursor is the cursor of the last entry returned from this source.
+	cursor string
+}
+
+// MultiJournal merges several journals into one, in realtime order. Its cursor is a JSON
+// object with the cursor of every source, so each source resumes where it stopped.
+type MultiJournal struct {
+	sources []*journalSource
+	current *journalSource
+}
+
+// journalSources lists the journals to read, the host journal first, then journal_dirs,
+// which can be glob patterns, then journal_namespaces of this machine.
+func journalSources(config *Config) ([]*journalSource, error) {
+
+	sources := []*journalSource{{name: JOURNAL_SOURCE_HOST, dir: config.JournalDir}}
+	names := map[string]bool{JOURNAL_SOURCE_HOST: true}
+
+	for _, pattern := range config.JournalDirs {
+		dirs, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid journal_dirs pattern %s: %s %v", pattern, err.Error(), err)
+		}
+		if len(dirs) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return nil, fmt.Errorf("journal dir %s not found", pattern)
+		}
+		for _, dir := range dirs {
+			name := filepath.Base(dir)
+			if names[name] || dir == config.JournalDir {
+				continue
+			}
+			names[name] = true
+			sources = append(sources, &journalSource{name: name, dir: dir})
+		}
+	}
+
+	if len(config.JournalNamespaces) == 0 {
+		return sources, nil
+	}
+
+	data, err := ioutil.ReadFile(machineIdFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the machine id for journal_namespaces: %s %v", err.Error(), err)
+	}
+	machineId := strings.TrimSpace(string(data))
+
+	for _, namespace := range config.JournalNamespaces {
+		dir := ""
+		for _, root := range journalRoots {
+			candidate := filepath.Join(root, machineId+"."+namespace)
+			if info, err := os.Stat(candidate); err == nil && info.IsDir() {
+				dir = candidate
+				break
+			}
+		}
+		if dir == "" {
+			return nil, fmt.Errorf("journal namespace %s not found in %s", namespace, strings.Join(journalRoots, " or "))
+		}
+		sources = append(sources, &journalSource{name: JOURNAL_SOURCE_NAMESPACE_PREFIX + namespace, dir: dir})
+	}
+	return sources, nil
+}
+
+// NewMultiJournal opens every source of journalSources.
+func NewMultiJournal(config *Config) (*MultiJournal, error) {
+
+	sources, err := journalSources(config)
+	if err != nil {
+		return nil, err
+	}
+
+	multi := &MultiJournal{}
+	for _, source := range sources {
+		sourceConfig := *config
+		sourceConfig.JournalDir = source.dir
+		source.journal, err = newSourceJournal(&sourceConfig)
+		if err != nil {
+			multi.Close()
+			return nil, fmt.Errorf("unable to open journal %s: %s %v", source.name, err.Error(), err)
+		}
+		multi.sources = append(multi.sources, source)
+	}
+	return multi, nil
+}
+
+func newMultiJournalWithSources(sources ...*journalSource) *MultiJournal {
+	return &MultiJournal{sources: sources}
+}
+
+// Source is the name of the source of the current entry.
+func (multi *MultiJournal) Source() string {
+	if multi.current == nil {
+		return ""
+	}
+	return multi.current.name
+}
+
+func (multi *MultiJournal) reset() {
+	multi.current = nil
+	for _, source := range multi.sources {
+		source.pending = false
+	}
+}
+
+func (multi *MultiJournal) Close() error {
+	var firstErr error
+	for _, source := range multi.sources {
+		if source.journal == nil {
+			continue
+		}
+		if err := source.journal.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Next advances every source without a pending entry and returns the oldest pending entry.
+func (multi *MultiJournal) Next() (uint64, error) {
+
+	multi.current = nil
+
+	for _, source := range multi.sources {
+		if source.pending {
+			continue
+		}
+		count, err := source.journal.Next()
+		if err != nil {
+			return 0, fmt.Errorf("journal %s: %s %v", source.name, err.Error(), err)
+		}
+		if count == 0 {
+			continue
+		}
+		source.usec, err = source.journal.GetRealtimeUsec()
+		if err != nil {
+			return 0, fmt.Errorf("journal %s: %s %v", source.name, err.Error(), err)
+		}
+		source.pending = true
+	}
+
+	for _, source := range multi.sources {
+		if source.pending && (multi.current == nil || source.usec < multi.current.usec) {
+			multi.current = source
+		}
+	}
+
+	if multi.current == nil {
+		return 0, nil
+	}
+
+	multi.current.pending = false
+	cursor, err := multi.current.journal.GetCursor()
+	if err != nil {
+		return 0, fmt.Errorf("journal %s: %s %v", multi.current.name, err.Error(), err)
+	}
+	multi.current.cursor = cursor
+	return 1, nil
+}
+
+func (multi *MultiJournal) NextSkip(skip uint64) (uint64, error) {
+	var skipped uint64
+	for ; skipped < skip; skipped++ {
+		count, err := multi.Next()
+		if err != nil || count == 0 {
+			return skipped, err
+		}
+	}
+	return skipped, nil
+}
+
+// Previous moves every source back by one entry.
+func (multi *MultiJournal) Previous() (uint64, error) {
+	return multi.PreviousSkip(1)
+}
+
+// PreviousSkip moves every source back by skip entries, so tail with rewind rewinds each
+// source. It returns the most entries any source moved.
+func (multi *MultiJournal) PreviousSkip(skip uint64) (uint64, error) {
+	multi.reset()
+	var most uint64
+	for _, source := range multi.sources {
+		count, err := source.journal.PreviousSkip(skip)
+		if err != nil {
+			return most, fmt.Errorf("journal %s: %s %v", source.name, err.Error(), err)
+		}
+		if count > most {
+			most = count
+		}
+	}
+	return most, nil
+}
+
+func (multi *MultiJournal) GetDataValue(field string) (string, error) {
+	if multi.current == nil {
+		return "", fmt.Errorf("no current journal entry")
+	}
+	return multi.current.journal.GetDataValue(field)
+}
+
+func (multi *MultiJournal) GetRealtimeUsec() (uint64, error) {
+	if multi.current == nil {
+		return 0, fmt.Errorf("no current journal entry")
+	}
+	return multi.current.usec, nil
+}
+
+func (multi *MultiJournal) GetMonotonicUsec() (uint64, error) {
+	if multi.current == nil {
+		return 0, fmt.Errorf("no current journal entry")
+	}
+	return multi.current.journal.GetMonotonicUsec()
+}
+
+func (multi *MultiJournal) AddLogFilters(config *Config) {
+	for _, source := range multi.sources {
+		source.journal.AddLogFilters(config)
+	}
+}
+
+func (multi *MultiJournal) AddMatch(match string) error {
+	for _, source := range multi.sources {
+		if err := source.journal.AddMatch(match); err != nil {
+			return fmt.Errorf("journal %s: %s %v", source.name, err.Error(), err)
+		}
+	}
+	return nil
+}
+
+// GetCursor returns a JSON object with the cursor of the last entry read from each source,
+// e.g. {"host":"s=...","ns:audit":"s=..."}.
+func (multi *MultiJournal) GetCursor() (string, error) {
+	cursors := map[string]string{}
+	for _, source := range multi.sources {
+		if source.cursor != "" {
+			cursors[source.name] = source.cursor
+		}
+	}
+	data, err := json.Marshal(cursors)
+	return string(data), err
+}
+
+// EntryCursor is the cursor of the current entry in its source.
+func (multi *MultiJournal) EntryCursor() (string, error) {
+	if multi.current == nil {
+		return "", fmt.Errorf("no current journal entry")
+	}
+	return multi.current.cursor, nil
+}
+
+// sourceCursors returns the cursor of each source in a cursor from GetCursor, or the cursor
+// itself when it is the cursor of a single journal.
+func sourceCursors(cursor string) []string {
+	cursors := map[string]string{}
+	if !strings.HasPrefix(cursor, "{") || json.Unmarshal([]byte(cursor), &cursors) != nil {
+		return []string{cursor}
+	}
+	values := make([]string, 0, len(cursors))
+	for _, sourceCursor := range cursors {
+		values = append(values, sourceCursor)
+	}
+	return values
+}
+
+// SeekCursor seeks every source to its cursor from GetCursor. Sources without a cursor,
+// e.g. a journal dir that was added since, start from their oldest entry. The cursor of a
+// single journal, saved before journal_dirs or journal_namespaces were set, is the cursor of
+// the host source.
+func (multi *MultiJournal) SeekCursor(cursor string) error {
+
+	cursors := map[string]string{}
+	if !strings.HasPrefix(cursor, "{") {
+		cursors[JOURNAL_SOURCE_HOST] = cursor
+	} else if err := json.Unmarshal([]byte(cursor), &cursors); err != nil {
+		return fmt.Errorf("expected a cursor for each journal source: %s %v", err.Error(), err)
+	}
+
+	multi.reset()
+	for _, source := range multi.sources {
+		var err error
+		if sourceCursor, found := cursors[source.name]; found {
+			source.cursor = sourceCursor
+			err = source.journal.SeekCursor(sourceCursor)
+		} else {
+			err = source.journal.SeekHead()
+		}
+		if err != nil {
+			return fmt.Errorf("journal %s: %s %v", source.name, err.Error(), err)
+		}
+	}
+	return nil
+}
+
+func (multi *MultiJournal) SeekHead() error {
+	return multi.seek(func(journal Journal) error { return journal.SeekHead() })
+}
+
+func (multi *MultiJournal) SeekTail() error {
+	return multi.seek(func(journal Journal) error { return journal.SeekTail() })
+}
+
+func (multi *MultiJournal) SeekRealtimeUsec(usec uint64) error {
+	return multi.seek(func(journal Journal) error { return journal.SeekRealtimeUsec(usec) })
+}
+
+func (multi *MultiJournal) seek(seek func(journal Journal) error) error {
+	multi.reset()
+	for _, source := range multi.sources {
+		if err := seek(source.journal); err != nil {
+			return fmt.Errorf("journal %s: %s %v", source.name, err.Error(), err)
+		}
+	}
+	return nil
+}
+
+// ListBoots lists the boots of every source, oldest first. A boot found in several sources
+// has the earliest first entry. The cursors are left empty as they only apply to one source,
+// so the runner seeks to boots by time.
+func (multi *MultiJournal) ListBoots() ([]JournalBoot, error) {
+
+	byId := map[string]JournalBoot{}
+	for _, source := range multi.sources {
+		boots, err := source.journal.ListBoots()
+		if err != nil {
+			return nil, fmt.Errorf("journal %s: %s %v", source.name, err.Error(), err)
+		}
+		for _, boot := range boots {
+			if known, found := byId[boot.Id]; !found || boot.FirstUsec < known.FirstUsec {
+				byId[boot.Id] = JournalBoot{Id: boot.Id, FirstUsec: boot.FirstUsec}
+			}
+		}
+	}
+
+	boots := make([]JournalBoot, 0, len(byId))
+	for _, boot := range byId {
+		boots = append(boots, boot)
+	}
+	sort.Slice(boots, func(i, j int) bool { return boots[i].FirstUsec < boots[j].FirstUsec })
+	return boots, nil
+}
+
+// Wait waits on each source in turn for a share of the timeout and returns as soon as one
+// of them changed.
+func (multi *MultiJournal) Wait(timeout time.Duration) int {
+	share := timeout / time.Duration(len(multi.sources))
+	for _, source := range multi.sources {
+		if result := source.journal.Wait(share); result != 0 {
+			return result
+		}
+	}
+	return 0
+}
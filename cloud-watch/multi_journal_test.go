@@ -0,0 +1,152 @@
+package cloud_watch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMultiJournalMergesInTimeOrder(t *testing.T) {
+
+	start := time.Date(2016, 11, 29, 20, 0, 0, 0, time.UTC)
+	multi := newMultiJournalWithSources(
+		&journalSource{name: JOURNAL_SOURCE_HOST, journal: newRangeJournal(start, "host-0", "host-1", "host-2")},
+		&journalSource{name: "container", journal: newRangeJournal(start.Add(30*time.Second), "container-0", "container-1")},
+	)
+
+	config, _ := LoadConfigFromString(``, nil)
+	expected := []string{"host-0", "container-0", "host-1", "container-1", "host-2"}
+	sources := []string{"host", "container", "host", "container", "host"}
+
+	for i := range expected {
+		count, err := multi.Next()
+		if err != nil || count != 1 {
+			t.Fatalf("Expected entry %d got %d %v", i, count, err)
+		}
+		record, err := NewRecord(multi, nil, config)
+		if err != nil {
+			t.Fatalf("Unable to read record %s", err)
+		}
+		if record.Message != expected[i] || record.Source != sources[i] {
+			t.Errorf("Entry %d is %s from %s not %s from %s", i, record.Message, record.Source, expected[i], sources[i])
+		}
+	}
+
+	if count, _ := multi.Next(); count != 0 {
+		t.Errorf("Expected the end of the journals")
+	}
+}
+
+func TestMultiJournalCursorPerSource(t *testing.T) {
+
+	start := time.Date(2016, 11, 29, 20, 0, 0, 0, time.UTC)
+	newMulti := func() *MultiJournal {
+		return newMultiJournalWithSources(
+			&journalSource{name: JOURNAL_SOURCE_HOST, journal: newRangeJournal(start, "host-0", "host-1", "host-2")},
+			&journalSource{name: "ns:audit", journal: newRangeJournal(start.Add(90*time.Second), "audit-0", "audit-1")},
+		)
+	}
+
+	multi := newMulti()
+	for i := 0; i < 3; i++ {
+		multi.Next()
+	}
+
+	cursor, err := multi.GetCursor()
+	if err != nil {
+		t.Fatalf("Unable to get cursor %s", err)
+	}
+	if cursor != `{"host":"i=1","ns:audit":"i=0"}` {
+		t.Errorf("Wrong composite cursor %s", cursor)
+	}
+
+	restarted := newMulti()
+	if err = restarted.SeekCursor(cursor); err != nil {
+		t.Fatalf("Unable to seek cursor %s", err)
+	}
+
+	messages := []string{}
+	for {
+		count, _ := restarted.Next()
+		if count == 0 {
+			break
+		}
+		message, _ := restarted.GetDataValue("MESSAGE")
+		messages = append(messages, message)
+	}
+
+	// Each source restarts on the entry of its cursor, like a single journal does.
+	if len(messages) != 4 || messages[0] != "host-1" || messages[1] != "audit-0" || messages[3] != "audit-1" {
+		t.Errorf("Wrong entries after seeking %v", messages)
+	}
+
+	if restarted.SeekCursor("{s=123") == nil {
+		t.Errorf("Expected a malformed composite cursor to be rejected")
+	}
+
+	// A cursor saved before the other sources were added is the cursor of the host journal,
+	// the audit namespace starts from its oldest entry.
+	restarted = newMulti()
+	if err = restarted.SeekCursor("i=2"); err != nil {
+		t.Fatalf("Unable to seek plain cursor %s", err)
+	}
+	messages = []string{}
+	for count, _ := restarted.Next(); count > 0; count, _ = restarted.Next() {
+		message, _ := restarted.GetDataValue("MESSAGE")
+		messages = append(messages, message)
+	}
+	if len(messages) != 3 || messages[0] != "audit-0" || messages[1] != "host-2" || messages[2] != "audit-1" {
+		t.Errorf("Wrong entries after seeking a plain cursor %v", messages)
+	}
+}
+
+func TestJournalSources(t *testing.T) {
+
+	root, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatalf("Unable to create journal root %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, dir := range []string{"5125015c46bb4bf6a686b5e692492075", "c0ffee5c46bb4bf6a686b5e692492075",
+		"923def0648b1422aa28a8846072481f2.audit"} {
+		os.Mkdir(filepath.Join(root, dir), 0755)
+	}
+	ioutil.WriteFile(filepath.Join(root, "machine-id"), []byte("923def0648b1422aa28a8846072481f2\n"), 0644)
+
+	savedRoots, savedMachineId := journalRoots, machineIdFile
+	journalRoots, machineIdFile = []string{filepath.Join(root, "missing"), root}, filepath.Join(root, "machine-id")
+	defer func() { journalRoots, machineIdFile = savedRoots, savedMachineId }()
+
+	config, _ := LoadConfigFromString(`
+journal_dirs=["`+root+`/*5c46bb4bf6a686b5e692492075"]
+journal_namespaces=["audit"]
+`, nil)
+
+	sources, err := journalSources(config)
+	if err != nil {
+		t.Fatalf("Unable to find sources %s", err)
+	}
+
+	expected := map[string]string{
+		"host":                             "",
+		"5125015c46bb4bf6a686b5e692492075": filepath.Join(root, "5125015c46bb4bf6a686b5e692492075"),
+		"c0ffee5c46bb4bf6a686b5e692492075": filepath.Join(root, "c0ffee5c46bb4bf6a686b5e692492075"),
+		"ns:audit":                         filepath.Join(root, "923def0648b1422aa28a8846072481f2.audit"),
+	}
+	if len(sources) != len(expected) {
+		t.Fatalf("Expected %d sources got %d", len(expected), len(sources))
+	}
+	for _, source := range sources {
+		if dir, found := expected[source.name]; !found || dir != source.dir {
+			t.Errorf("Unexpected source %s %s", source.name, source.dir)
+		}
+	}
+
+	config.JournalNamespaces = []string{"missing"}
+	if _, err = journalSources(config); err == nil {
+		t.Errorf("Expected a missing namespace to fail")
+	}
+}
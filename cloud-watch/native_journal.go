@@ -0,0 +1,520 @@
+package cloud_watch
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	JOURNAL_READER_SDJOURNAL = "sdjournal"
+	JOURNAL_READER_NATIVE    = "native"
+)
+
+// nativeJournalPoll is how often Wait checks the journal files for new entries.
+var nativeJournalPoll = 250 * time.Millisecond
+
+// nativeFile is an open journal file with the read position in it.
+type nativeFile struct {
+	*journalFile
+	// next is the index of the next entry Next can return from this file.
+	next uint64
+}
+
+// NativeJournal reads journal files directly, without libsystemd, and merges the files of
+// the directory in realtime order.
+type NativeJournal struct {
+	dirs    []string
+	files   []*nativeFile
+	fileIds map[string]bool
+	current *nativeFile
+	entry   *journalFileEntry
+	values  map[string]string
+	filter  *journalFilter
+}
+
+// NewNativeJournal opens the journal files in journal_dir, or of the local machine in
+// /var/log/journal and /run/log/journal.
+func NewNativeJournal(config *Config) (*NativeJournal, error) {
+
+	journal := &NativeJournal{fileIds: map[string]bool{}, filter: newJournalFilter()}
+
+	if config.JournalDir != "" {
+		journal.dirs = []string{config.JournalDir}
+	} else {
+		data, err := ioutil.ReadFile(machineIdFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the machine id: %s %v", err.Error(), err)
+		}
+		for _, root := range journalRoots {
+			journal.dirs = append(journal.dirs, filepath.Join(root, strings.TrimSpace(string(data))))
+		}
+	}
+
+	if err := journal.openFiles(); err != nil {
+		journal.Close()
+		return nil, err
+	}
+	if config.JournalDir != "" && len(journal.files) == 0 {
+		return nil, fmt.Errorf("no journal files in %s", config.JournalDir)
+	}
+	return journal, nil
+}
+
+// journalFileNames lists the journal files of a directory and of its machine id
+// subdirectories, like sd_journal_open_directory.
+func journalFileNames(dir string) []string {
+
+	names := []string{}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return names
+	}
+
+	for _, info := range infos {
+		path := filepath.Join(dir, info.Name())
+		if info.IsDir() {
+			if _, err := hex.DecodeString(info.Name()); err == nil && len(info.Name()) == 32 {
+				names = append(names, journalFileNames(path)...)
+			}
+			continue
+		}
+		if strings.HasSuffix(info.Name(), ".journal") || strings.HasSuffix(info.Name(), ".journal~") {
+			names = append(names, path)
+		}
+	}
+	return names
+}
+
+// openFiles opens the files that are not open yet. A file that was renamed when journald
+// rotated it has the same file id and is skipped.
+func (journal *NativeJournal) openFiles() error {
+
+	for _, dir := range journal.dirs {
+		for _, path := range journalFileNames(dir) {
+			file, err := openJournalFile(path)
+			if err != nil {
+				// Files being created or corrupted files are skipped, like journalctl does.
+				continue
+			}
+			if journal.fileIds[file.fileId] {
+				file.Close()
+				continue
+			}
+			journal.fileIds[file.fileId] = true
+			journal.files = append(journal.files, &nativeFile{journalFile: file})
+		}
+	}
+	return nil
+}
+
+// refresh picks up entries appended to the files and new files.
+func (journal *NativeJournal) refresh() (bool, error) {
+
+	changed := false
+	for _, file := range journal.files {
+		before := file.nEntries
+		if err := file.refresh(); err != nil {
+			return changed, err
+		}
+		changed = changed || file.nEntries != before
+	}
+
+	count := len(journal.files)
+	if err := journal.openFiles(); err != nil {
+		return changed, err
+	}
+	return changed || len(journal.files) != count, nil
+}
+
+func (journal *NativeJournal) Close() error {
+	var firstErr error
+	for _, file := range journal.files {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (journal *NativeJournal) setCurrent(file *nativeFile, entry *journalFileEntry) {
+	journal.current = file
+	journal.entry = entry
+	journal.values = nil
+}
+
+// nextEntry moves to the oldest entry after the read position of all files.
+func (journal *NativeJournal) nextEntry() (bool, error) {
+
+	var oldest *nativeFile
+	var oldestEntry *journalFileEntry
+
+	for _, file := range journal.files {
+		if file.next >= file.nEntries {
+			continue
+		}
+		entry, err := file.entry(file.next)
+		if err != nil {
+			return false, err
+		}
+		if oldestEntry == nil || entry.realtime < oldestEntry.realtime {
+			oldest, oldestEntry = file, entry
+		}
+	}
+
+	if oldest == nil {
+		return false, nil
+	}
+	oldest.next++
+	journal.setCurrent(oldest, oldestEntry)
+	return true, nil
+}
+
+// previousEntry moves to the newest entry before the current one.
+func (journal *NativeJournal) previousEntry() (bool, error) {
+
+	if journal.current != nil {
+		journal.current.next--
+	}
+
+	var newest *nativeFile
+	var newestEntry *journalFileEntry
+
+	for _, file := range journal.files {
+		if file.next == 0 {
+			continue
+		}
+		entry, err := file.entry(file.next - 1)
+		if err != nil {
+			return false, err
+		}
+		if newestEntry == nil || entry.realtime > newestEntry.realtime {
+			newest, newestEntry = file, entry
+		}
+	}
+
+	if newest == nil {
+		journal.setCurrent(nil, nil)
+		return false, nil
+	}
+	journal.setCurrent(newest, newestEntry)
+	return true, nil
+}
+
+func (journal *NativeJournal) matchesFilters() (bool, error) {
+	if journal.filter.empty() {
+		return true, nil
+	}
+	values, err := journal.currentValues()
+	if err != nil {
+		return false, err
+	}
+	return journal.filter.matches(values), nil
+}
+
+// Next advances the read pointer into the journal by one entry.
+func (journal *NativeJournal) Next() (uint64, error) {
+
+	for {
+		found, err := journal.nextEntry()
+		if err == nil && !found {
+			if _, err = journal.refresh(); err == nil {
+				found, err = journal.nextEntry()
+			}
+		}
+		if err != nil || !found {
+			return 0, err
+		}
+		match, err := journal.matchesFilters()
+		if err != nil {
+			return 0, err
+		}
+		if match {
+			return 1, nil
+		}
+	}
+}
+
+// NextSkip advances the read pointer by multiple entries at once,
+// as specified by the skip parameter.
+func (journal *NativeJournal) NextSkip(skip uint64) (uint64, error) {
+	var skipped uint64
+	for ; skipped < skip; skipped++ {
+		count, err := journal.Next()
+		if err != nil || count == 0 {
+			return skipped, err
+		}
+	}
+	return skipped, nil
+}
+
+// Previous sets the read pointer into the journal back by one entry.
+func (journal *NativeJournal) Previous() (uint64, error) {
+	for {
+		found, err := journal.previousEntry()
+		if err != nil || !found {
+			return 0, err
+		}
+		match, err := journal.matchesFilters()
+		if err != nil {
+			return 0, err
+		}
+		if match {
+			return 1, nil
+		}
+	}
+}
+
+// PreviousSkip sets back the read pointer by multiple entries at once,
+// as specified by the skip parameter.
+func (journal *NativeJournal) PreviousSkip(skip uint64) (uint64, error) {
+	var skipped uint64
+	for ; skipped < skip; skipped++ {
+		count, err := journal.Previous()
+		if err != nil || count == 0 {
+			return skipped, err
+		}
+	}
+	return skipped, nil
+}
+
+func (journal *NativeJournal) currentValues() (map[string]string, error) {
+	if journal.entry == nil {
+		return nil, fmt.Errorf("no current journal entry")
+	}
+	if journal.values == nil {
+		values, err := journal.current.fields(journal.entry)
+		if err != nil {
+			return nil, err
+		}
+		journal.values = values
+	}
+	return journal.values, nil
+}
+
+// GetDataValue gets the data object associated with a specific field from the
+// current journal entry, returning only the value of the object.
+func (journal *NativeJournal) GetDataValue(field string) (string, error) {
+	values, err := journal.currentValues()
+	if err != nil {
+		return "", err
+	}
+	value, found := values[field]
+	if !found {
+		return "", fmt.Errorf("field %s not found", field)
+	}
+	return value, nil
+}
+
+// GetRealtimeUsec gets the realtime (wallclock) timestamp of the current
+// journal entry.
+func (journal *NativeJournal) GetRealtimeUsec() (uint64, error) {
+	if journal.entry == nil {
+		return 0, fmt.Errorf("no current journal entry")
+	}
+	return journal.entry.realtime, nil
+}
+
+// GetMonotonicUsec gets the monotonic timestamp of the current journal entry.
+func (journal *NativeJournal) GetMonotonicUsec() (uint64, error) {
+	if journal.entry == nil {
+		return 0, fmt.Errorf("no current journal entry")
+	}
+	return journal.entry.monotonic, nil
+}
+
+// GetCursor gets the cursor of the current journal entry, in the format of sd_journal.
+func (journal *NativeJournal) GetCursor() (string, error) {
+	if journal.entry == nil {
+		return "", fmt.Errorf("no current journal entry")
+	}
+	return journal.current.cursor(journal.entry), nil
+}
+
+// AddLogFilters adds the log_priority matches.
+func (journal *NativeJournal) AddLogFilters(config *Config) {
+	journal.filter.addLogFilters(config)
+}
+
+// AddMatch adds a match by which to filter the entries of the journal.
+func (journal *NativeJournal) AddMatch(match string) error {
+	return journal.filter.addMatch(match)
+}
+
+func (journal *NativeJournal) seek(position func(file *nativeFile) (uint64, error)) error {
+	journal.setCurrent(nil, nil)
+	if _, err := journal.refresh(); err != nil {
+		return err
+	}
+	for _, file := range journal.files {
+		next, err := position(file)
+		if err != nil {
+			return err
+		}
+		file.next = next
+	}
+	return nil
+}
+
+// SeekHead seeks to the beginning of the journal, i.e. the oldest available
+// entry.
+func (journal *NativeJournal) SeekHead() error {
+	return journal.seek(func(file *nativeFile) (uint64, error) { return 0, nil })
+}
+
+// SeekTail may be used to seek to the end of the journal, i.e. the most recent
+// available entry.
+func (journal *NativeJournal) SeekTail() error {
+	return journal.seek(func(file *nativeFile) (uint64, error) { return file.nEntries, nil })
+}
+
+// SeekRealtimeUsec seeks to the entry with the specified realtime (wallclock)
+// timestamp, i.e. CLOCK_REALTIME.
+func (journal *NativeJournal) SeekRealtimeUsec(usec uint64) error {
+	return journal.seek(func(file *nativeFile) (uint64, error) {
+		return file.search(func(entry *journalFileEntry) bool { return entry.realtime >= usec })
+	})
+}
+
+// SeekCursor seeks to a concrete journal cursor. The file with the sequence number id of
+// the cursor is positioned on its entry, other files by the time of the cursor.
+func (journal *NativeJournal) SeekCursor(cursor string) error {
+
+	values := map[string]string{}
+	for _, part := range strings.Split(cursor, ";") {
+		pair := strings.SplitN(part, "=", 2)
+		if len(pair) == 2 {
+			values[pair[0]] = pair[1]
+		}
+	}
+
+	seqnum, seqnumErr := strconv.ParseUint(values["i"], 16, 64)
+	realtime, realtimeErr := strconv.ParseUint(values["t"], 16, 64)
+	if values["s"] == "" || seqnumErr != nil || realtimeErr != nil {
+		return fmt.Errorf("invalid journal cursor %q", cursor)
+	}
+
+	return journal.seek(func(file *nativeFile) (uint64, error) {
+		if file.seqnumId == values["s"] {
+			return file.search(func(entry *journalFileEntry) bool { return entry.seqnum >= seqnum })
+		}
+		return file.search(func(entry *journalFileEntry) bool { return entry.realtime >= realtime })
+	})
+}
+
+// ListBoots lists the boots in the journal, oldest first. Every entry is read so this
+// takes a while on large journals.
+func (journal *NativeJournal) ListBoots() ([]JournalBoot, error) {
+
+	if _, err := journal.refresh(); err != nil {
+		return nil, err
+	}
+
+	byId := map[string]JournalBoot{}
+	for _, file := range journal.files {
+		for index := uint64(0); index < file.nEntries; index++ {
+			entry, err := file.entry(index)
+			if err != nil {
+				return nil, err
+			}
+			if boot, found := byId[entry.bootId]; !found || entry.realtime < boot.FirstUsec {
+				byId[entry.bootId] = JournalBoot{Id: entry.bootId, FirstUsec: entry.realtime,
+					FirstCursor: file.cursor(entry)}
+			}
+		}
+	}
+
+	boots := make([]JournalBoot, 0, len(byId))
+	for _, boot := range byId {
+		boots = append(boots, boot)
+	}
+	sort.Slice(boots, func(i, j int) bool { return boots[i].FirstUsec < boots[j].FirstUsec })
+	return boots, nil
+}
+
+// Wait polls the journal files until entries are added or the timeout passes. It returns
+// 1 (SD_JOURNAL_APPEND) when there are new entries and 0 (SD_JOURNAL_NOP) otherwise.
+func (journal *NativeJournal) Wait(timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for {
+		if changed, err := journal.refresh(); err != nil || changed {
+			return 1
+		}
+		if !time.Now().Before(deadline) {
+			return 0
+		}
+		time.Sleep(nativeJournalPoll)
+	}
+}
+
+// journalFilter implements the matches of sd_journal for the readers that do not use
+// libsystemd: matches of the same field are ORed, of different fields ANDed and a
+// disjunction ORs groups of matches.
+type journalFilter struct {
+	groups [][]string
+}
+
+func newJournalFilter() *journalFilter {
+	return &journalFilter{groups: [][]string{{}}}
+}
+
+func (filter *journalFilter) addMatch(match string) error {
+	if !strings.Contains(match, "=") {
+		return fmt.Errorf("invalid match %q, expected FIELD=value", match)
+	}
+	last := len(filter.groups) - 1
+	filter.groups[last] = append(filter.groups[last], match)
+	return nil
+}
+
+func (filter *journalFilter) addDisjunction() {
+	filter.groups = append(filter.groups, []string{})
+}
+
+// addLogFilters adds the log_priority matches like SdJournal.AddLogFilters.
+func (filter *journalFilter) addLogFilters(config *Config) {
+	if config.GetJournalDLogPriority() < DEBUG {
+		for p := range PriorityJsonMap {
+			if p <= config.GetJournalDLogPriority() {
+				filter.addMatch("PRIORITY=" + strconv.Itoa(int(p)))
+			}
+		}
+		filter.addDisjunction()
+	}
+}
+
+func (filter *journalFilter) empty() bool {
+	for _, group := range filter.groups {
+		if len(group) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (filter *journalFilter) matches(values map[string]string) bool {
+
+	for _, group := range filter.groups {
+		if len(group) == 0 {
+			continue
+		}
+		fields := map[string]bool{}
+		found := map[string]bool{}
+		for _, match := range group {
+			pair := strings.SplitN(match, "=", 2)
+			fields[pair[0]] = true
+			if value, ok := values[pair[0]]; ok && value == pair[1] {
+				found[pair[0]] = true
+			}
+		}
+		if len(found) == len(fields) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,243 @@
+package cloud_watch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/ulikunitz/xz"
+	"strings"
+	"testing"
+)
+
+// The samples were written by systemd-journald 252 in a journal namespace, see
+// testdata/journal/README.md. They hold the same 7 entries.
+var nativeJournalSamples = map[string]string{
+	"compact": "s=cebf68fa8fd94375b59c66407dad2967;i=3;b=aaef4725d15143d1bfeec08851ff34d5;m=a319a579;t=65e2f9405b396;x=ecd8d0c654f0923d",
+	"keyed":   "s=c09465b0b8ae4806b7ead24f726c0cc2;i=3;b=aaef4725d15143d1bfeec08851ff34d5;m=a395847d;t=65e2f9481929a;x=6aa9772b8f5fb469",
+	"regular": "s=00f6a905f4b24dee95adda9436641efb;i=3;b=aaef4725d15143d1bfeec08851ff34d5;m=a3d3e6e8;t=65e2f94bff505;x=132fb4a111f943ab",
+}
+
+func openNativeSample(t *testing.T, sample string) *NativeJournal {
+	config, _ := LoadConfigFromString(`journal_reader="native"
+journal_dir="testdata/journal/`+sample+`"`, nil)
+	journal, err := NewNativeJournal(config)
+	if err != nil {
+		t.Fatalf("Unable to open sample %s %s", sample, err)
+	}
+	return journal
+}
+
+func readMessages(t *testing.T, journal Journal) []string {
+	messages := []string{}
+	for {
+		count, err := journal.Next()
+		if err != nil {
+			t.Fatalf("Unable to read journal %s", err)
+		}
+		if count == 0 {
+			return messages
+		}
+		message, _ := journal.GetDataValue("MESSAGE")
+		messages = append(messages, message)
+	}
+}
+
+func TestNativeJournalSamples(t *testing.T) {
+
+	for sample, cursor := range nativeJournalSamples {
+
+		journal := openNativeSample(t, sample)
+		defer journal.Close()
+
+		messages := readMessages(t, journal)
+		if len(messages) != 7 {
+			t.Fatalf("%s: expected 7 entries got %d", sample, len(messages))
+		}
+
+		if messages[0] != "Journal started" || messages[2] != "hello from the sample journal" ||
+			messages[6] != "Journal stopped" {
+			t.Errorf("%s: wrong messages %v", sample, messages)
+		}
+
+		// journald compressed this message with zstd.
+		if len(messages[3]) != 2411 || !strings.HasPrefix(messages[3], "compressed abcdefgh") {
+			t.Errorf("%s: compressed message not read %d", sample, len(messages[3]))
+		}
+
+		journal.SeekCursor(cursor)
+		journal.NextSkip(3)
+		message, _ := journal.GetDataValue("MESSAGE")
+		binaryValue, _ := journal.GetDataValue("SAMPLE_BINARY")
+		if message != "line one\nline two" || binaryValue != "\x00\x01\x02binary" {
+			t.Errorf("%s: binary fields not read %q %q", sample, message, binaryValue)
+		}
+
+		journal.SeekHead()
+		journal.NextSkip(3)
+		if value, _ := journal.GetCursor(); value != cursor {
+			t.Errorf("%s: cursor is %s not %s like journalctl", sample, value, cursor)
+		}
+	}
+}
+
+func TestNativeJournalSeek(t *testing.T) {
+
+	journal := openNativeSample(t, "compact")
+	defer journal.Close()
+
+	journal.SeekTail()
+	if count, _ := journal.PreviousSkip(2); count != 2 {
+		t.Errorf("Expected to rewind 2 entries got %d", count)
+	}
+	if messages := readMessages(t, journal); len(messages) != 1 || messages[0] != "Journal stopped" {
+		t.Errorf("Wrong entries after the rewind %v", messages)
+	}
+
+	journal.SeekRealtimeUsec(0x65e2f9405c761)
+	if messages := readMessages(t, journal); len(messages) != 4 || !strings.HasPrefix(messages[0], "compressed") {
+		t.Errorf("Wrong entries after seeking to a time %v", messages)
+	}
+
+	journal.SeekCursor(nativeJournalSamples["compact"])
+	if messages := readMessages(t, journal); len(messages) != 5 || messages[0] != "hello from the sample journal" {
+		t.Errorf("Wrong entries after seeking to a cursor %v", messages)
+	}
+
+	if journal.SeekCursor("abc-123") == nil {
+		t.Errorf("Expected an invalid cursor to fail")
+	}
+
+	boots, err := journal.ListBoots()
+	if err != nil || len(boots) != 1 || boots[0].Id != "aaef4725d15143d1bfeec08851ff34d5" ||
+		!strings.Contains(boots[0].FirstCursor, ";i=1;") {
+		t.Errorf("Wrong boots %v %v", boots, err)
+	}
+}
+
+func TestNativeJournalFilters(t *testing.T) {
+
+	journal := openNativeSample(t, "regular")
+	defer journal.Close()
+
+	config, _ := LoadConfigFromString(`log_priority=4`, nil)
+	journal.AddLogFilters(config)
+	journal.SeekHead()
+	if messages := readMessages(t, journal); len(messages) != 2 || messages[1] != "line one\nline two" {
+		t.Errorf("Wrong entries with log_priority %v", messages)
+	}
+
+	journal = openNativeSample(t, "regular")
+	defer journal.Close()
+	journal.AddMatch("SYSLOG_IDENTIFIER=other")
+	journal.AddMatch("SYSLOG_IDENTIFIER=sample")
+	journal.AddMatch("PRIORITY=6")
+	journal.SeekHead()
+	if messages := readMessages(t, journal); len(messages) != 2 || messages[0] != messages[1] {
+		t.Errorf("Wrong entries with matches %v", messages)
+	}
+}
+
+func TestNativeJournalMergesFiles(t *testing.T) {
+
+	journal := &NativeJournal{dirs: []string{"testdata/journal/regular", "testdata/journal/compact"},
+		fileIds: map[string]bool{}, filter: newJournalFilter()}
+	journal.openFiles()
+	defer journal.Close()
+
+	var last uint64
+	count := 0
+	for {
+		found, _ := journal.Next()
+		if found == 0 {
+			break
+		}
+		usec, _ := journal.GetRealtimeUsec()
+		if usec < last {
+			t.Errorf("Entry %d is older than the one before", count)
+		}
+		last = usec
+		count++
+	}
+	if count != 14 {
+		t.Errorf("Expected the 14 entries of both files got %d", count)
+	}
+}
+
+func TestNativeJournalRecord(t *testing.T) {
+
+	journal := openNativeSample(t, "keyed")
+	defer journal.Close()
+
+	config, _ := LoadConfigFromString(``, nil)
+	journal.NextSkip(5)
+	record, err := NewRecord(journal, nil, config)
+	if err != nil {
+		t.Fatalf("Unable to read record %s", err)
+	}
+	if record.Identifier != "sample" || record.Priority != WARNING || record.TimeUsec != 0x65e2f94819f4f {
+		t.Errorf("Wrong record %v", record)
+	}
+}
+
+// lz4Sequence encodes an LZ4 sequence of literals and a match of match bytes at offset, the
+// last sequence of a block has no match.
+func lz4Sequence(literals []byte, offset int, match int) []byte {
+
+	nibble := func(value int) int {
+		if value > 15 {
+			return 15
+		}
+		return value
+	}
+	extension := func(value int) []byte {
+		out := []byte{}
+		if value < 15 {
+			return out
+		}
+		for value -= 15; value >= 255; value -= 255 {
+			out = append(out, 255)
+		}
+		return append(out, byte(value))
+	}
+
+	if offset == 0 {
+		sequence := append([]byte{byte(nibble(len(literals)) << 4)}, extension(len(literals))...)
+		return append(sequence, literals...)
+	}
+	sequence := append([]byte{byte(nibble(len(literals))<<4 | nibble(match-4))}, extension(len(literals))...)
+	sequence = append(sequence, literals...)
+	sequence = append(sequence, byte(offset), byte(offset>>8))
+	return append(sequence, extension(match-4)...)
+}
+
+func TestUncompressJournalPayloads(t *testing.T) {
+
+	data := []byte("MESSAGE=" + strings.Repeat("compressed ", 100))
+	file := &journalFile{path: "test"}
+
+	// "MESSAGE=compressed " followed by a match that repeats "compressed " and 5 literals.
+	block := lz4Sequence(data[:19], 11, len(data)-19-5)
+	block = append(block, lz4Sequence(data[len(data)-5:], 0, 0)...)
+	payload := make([]byte, 8)
+	binary.LittleEndian.PutUint64(payload, uint64(len(data)))
+	payload = append(payload, block...)
+
+	uncompressed, err := file.uncompress(journalObjectCompressedLZ4, payload)
+	if err != nil || !bytes.Equal(uncompressed, data) {
+		t.Errorf("LZ4 payload not uncompressed %v", err)
+	}
+
+	corrupt := append(payload[:8:8], lz4Sequence([]byte("x"), 2, 4)...)
+	if _, err = file.uncompress(journalObjectCompressedLZ4, corrupt); err == nil {
+		t.Error("Expected a match before the start of the data to be corrupt")
+	}
+
+	var buffer bytes.Buffer
+	writer, _ := xz.NewWriter(&buffer)
+	writer.Write(data)
+	writer.Close()
+
+	uncompressed, err = file.uncompress(journalObjectCompressedXZ, buffer.Bytes())
+	if err != nil || !bytes.Equal(uncompressed, data) {
+		t.Errorf("XZ payload not uncompressed %v", err)
+	}
+}
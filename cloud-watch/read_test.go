@@ -3,6 +3,7 @@ package cloud_watch
 import (
 	"errors"
 	"fmt"
+	"os"
 	"testing"
 	"time"
 	lg "github.com/advantageous/go-logback/logging"
@@ -107,3 +108,85 @@ func TestReadAllFromJournal(t *testing.T) {
 
 	fmt.Println("COUNT ", count, "                                                      \n\n\n")
 }
+
+// useBootId makes id the running boot until the returned function is called.
+func useBootId(t *testing.T, id string) func() {
+	bootIdFile = writeIdFile(t, id)
+	return func() {
+		os.Remove(bootIdFile)
+		bootIdFile = "/proc/sys/kernel/random/boot_id"
+	}
+}
+
+func TestStartPositions(t *testing.T) {
+
+	logger := lg.NewSimpleLogger("start-position-test")
+	boots := []JournalBoot{
+		{Id: "0b5a0e24f5a34c0f9d4b28e7a7ae1a01", FirstUsec: 1480400000000000, FirstCursor: "s=1;b=a"},
+		{Id: "0b5a0e24f5a34c0f9d4b28e7a7ae1a02", FirstUsec: 1480450000000000, FirstCursor: "s=1;b=b"},
+		{Id: "0b5a0e24f5a34c0f9d4b28e7a7ae1a03", FirstUsec: 1480460000000000, FirstCursor: "s=1;b=c"},
+	}
+	defer useBootId(t, "0b5a0e24-f5a3-4c0f-9d4b-28e7a7ae1a02")()
+
+	for configData, expected := range map[string]string{
+		``:                                  "head",
+		`tail=true`:                         "tail",
+		`since="@1480459022"`:               "realtime:1480459022000000",
+		`since="2016-11-29T22:37:02Z"`:      "realtime:1480459022000000",
+		`start_position="current_boot"`:     "cursor:s=1;b=b",
+		`start_position="previous_boot"`:    "cursor:s=1;b=a",
+		"start_position=\"head\"\nrewind=5": "head",
+		"start_position=\"current_boot\"\njournal_dir=\"/var/log/journal/remote\"":  "cursor:s=1;b=c",
+		"start_position=\"previous_boot\"\njournal_dir=\"/var/log/journal/remote\"": "cursor:s=1;b=b",
+	} {
+		journal := NewJournalWithMap(readTestMap).(MockJournal)
+		journal.SetBoots(boots)
+		config, _ := LoadConfigFromString(configData, logger)
+
+		runner := NewRunnerInternal(journal, NewMockJournalRepeater(), logger, config, false)
+		runner.Stop()
+
+		seek := journal.(*TestJournal).seek
+		if seek != expected {
+			t.Errorf("%s sought to %s not %s", configData, seek, expected)
+		}
+	}
+}
+
+func TestCurrentBootNotInJournal(t *testing.T) {
+
+	logger := lg.NewSimpleLogger("start-position-test")
+	defer useBootId(t, "0b5a0e24f5a34c0f9d4b28e7a7ae1a09")()
+
+	for configData, expected := range map[string]string{
+		`start_position="current_boot"`:  "tail",
+		`start_position="previous_boot"`: "cursor:s=1;b=a",
+	} {
+		journal := NewJournalWithMap(readTestMap).(MockJournal)
+		journal.SetBoots([]JournalBoot{{Id: "0b5a0e24f5a34c0f9d4b28e7a7ae1a01", FirstUsec: 1480400000000000, FirstCursor: "s=1;b=a"}})
+		config, _ := LoadConfigFromString(configData, logger)
+
+		runner := NewRunnerInternal(journal, NewMockJournalRepeater(), logger, config, false)
+		runner.Stop()
+
+		if seek := journal.(*TestJournal).seek; seek != expected {
+			t.Errorf("%s sought to %s not %s", configData, seek, expected)
+		}
+	}
+}
+
+func TestPreviousBootWithoutBoots(t *testing.T) {
+
+	logger := lg.NewSimpleLogger("start-position-test")
+	journal := NewJournalWithMap(readTestMap).(MockJournal)
+	journal.SetBoots([]JournalBoot{{Id: "0b5a0e24f5a34c0f9d4b28e7a7ae1a01", FirstUsec: 1480400000000000, FirstCursor: "s=1;b=a"}})
+	defer useBootId(t, "0b5a0e24f5a34c0f9d4b28e7a7ae1a01")()
+	config, _ := LoadConfigFromString(`start_position="previous_boot"`, logger)
+
+	runner := NewRunnerInternal(journal, NewMockJournalRepeater(), logger, config, false)
+	runner.Stop()
+
+	if seek := journal.(*TestJournal).seek; seek != "head" {
+		t.Errorf("Expected head without a previous boot, sought to %s", seek)
+	}
+}
@@ -1,9 +1,12 @@
 package cloud_watch
 
 import (
+	"encoding/base64"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 	lg "github.com/advantageous/go-logback/logging"
 )
 
@@ -34,6 +37,7 @@ var PriorityJsonMap = map[Priority][]byte{
 type Record struct {
 	InstanceId  string   `json:"instanceId,omitempty"`
 	TimeUsec    int64    `json:"-" journald:"__REALTIME_TIMESTAMP"`
+	MonoUsec    int64    `json:"monotonicUsec,omitempty"`
 	PID         int      `json:"pid,omitempty" journald:"_PID"`
 	UID         int      `json:"uid,omitempty" journald:"_UID"`
 	GID         int      `json:"gid,omitempty" journald:"_GID"`
@@ -49,7 +53,7 @@ type Record struct {
 	Message     string   `json:"message" journald:"MESSAGE"`
 	MessageId   string   `json:"messageId,omitempty" journald:"MESSAGE_ID"`
 	Errno       int      `json:"machineId,omitempty" journald:"ERRNO"`
-	SeqId       int64    `json:"seq,omitempty" `
+	EntryId     string   `json:"entryId,omitempty"`
 	Facility    int      `json:"syslogFacility,omitempty" journald:"SYSLOG_FACILITY"`
 	Identifier  string   `json:"syslogIdent,omitempty" journald:"SYSLOG_IDENTIFIER"`
 	SysPID      int      `json:"syslogPid,omitempty" journald:"SYSLOG_PID"`
@@ -57,6 +61,75 @@ type Record struct {
 	Subsystem   string   `json:"kernelSubsystem,omitempty" journald:"_KERNEL_SUBSYSTEM"`
 	SysName     string   `json:"kernelSysName,omitempty" journald:"_UDEV_SYSNAME"`
 	DevNode     string   `json:"kernelDevNode,omitempty" journald:"_UDEV_DEVNODE"`
+	Source      string   `json:"source,omitempty"`
+	Part        int      `json:"part,omitempty"`
+	Parts       int      `json:"parts,omitempty"`
+
+	Enrichment *Enrichment `json:"aws,omitempty"`
+	// Cursor is the journal cursor after this record, it is checkpointed once the record was sent.
+	Cursor string `json:"-"`
+	// upload is the upload of a record received by the remote journal reader, it is answered
+	// once its records were sent.
+	upload *remoteUpload
+	// hasFacility is set when the entry has a SYSLOG_FACILITY, so an explicit 0 (kern) is kept.
+	hasFacility bool
+}
+
+// Enrichment holds instance details attached to records by the EnrichingJournalRepeater.
+// Records refer to it by pointer so one value is shared by all records and Record stays comparable.
+type Enrichment map[string]string
+
+const (
+	// TRUNCATION_MARKER ends values that were cut at field_length, message_length or the event size limit.
+	TRUNCATION_MARKER = "…"
+	// BINARY_FIELD_PREFIX starts values that are not text, followed by the base64 encoding of the value.
+	BINARY_FIELD_PREFIX = "base64:"
+)
+
+// recordTime returns the wall clock time of the journal entry the record was read from.
+func recordTime(record *Record) time.Time {
+	return time.Unix(0, record.TimeUsec*int64(time.Microsecond))
+}
+
+// timestampedRecord adds the entry time with microseconds to the JSON encoding of a record,
+// destinations like CloudWatch only keep milliseconds of their own timestamp.
+type timestampedRecord struct {
+	Timestamp string `json:"@timestamp"`
+	*Record
+}
+
+func newTimestampedRecord(record *Record) timestampedRecord {
+	return timestampedRecord{recordTime(record).UTC().Format(time.RFC3339Nano), record}
+}
+
+// EntryCursorJournal is implemented by journals whose cursor covers more than the current
+// entry, like the cursor of every source of a MultiJournal. EntryCursor is the cursor of the
+// current entry alone.
+type EntryCursorJournal interface {
+	EntryCursor() (string, error)
+}
+
+func entryCursor(journal Journal) (string, error) {
+	if entryJournal, ok := journal.(EntryCursorJournal); ok {
+		return entryJournal.EntryCursor()
+	}
+	return journal.GetCursor()
+}
+
+// entryId identifies a journal entry by the sequence number id and sequence number of its
+// cursor, e.g. 6c072e0567ff423fa9cb39f136066299-3, which stay the same when the entry is read
+// again. Cursors without them are used as they are.
+func entryId(cursor string) string {
+	values := map[string]string{}
+	for _, part := range strings.Split(cursor, ";") {
+		if pair := strings.SplitN(part, "=", 2); len(pair) == 2 {
+			values[pair[0]] = pair[1]
+		}
+	}
+	if values["s"] == "" || values["i"] == "" {
+		return cursor
+	}
+	return values["s"] + "-" + values["i"]
 }
 
 func NewRecord(journal Journal, logger lg.Logger, config *Config) (*Record, error) {
@@ -64,17 +137,38 @@ func NewRecord(journal Journal, logger lg.Logger, config *Config) (*Record, erro
 
 	err := decodeRecord(journal, reflect.ValueOf(record).Elem(), logger, config)
 
+	if sourceJournal, ok := journal.(SourceJournal); ok {
+		record.Source = sourceJournal.Source()
+	}
+
 	if record.TimeUsec == 0 {
 
 		timestamp, err := journal.GetRealtimeUsec()
 		if err != nil {
 			logger.Errorf("Unable to read the time : %s %v", err.Error(), err)
-			record.TimeUsec = time.Now().Unix() * 1000
+			record.TimeUsec = time.Now().UnixNano() / int64(time.Microsecond)
 		} else {
-			record.TimeUsec = int64(timestamp / 1000)
+			record.TimeUsec = int64(timestamp)
 		}
 	}
 
+	if monotonic, err := journal.GetMonotonicUsec(); err == nil {
+		record.MonoUsec = int64(monotonic)
+	}
+	if cursor, err := entryCursor(journal); err == nil {
+		record.EntryId = entryId(cursor)
+	}
+	if cursor, err := journal.GetCursor(); err == nil {
+		record.Cursor = cursor
+	}
+	if remote, ok := journal.(*RemoteJournal); ok && remote.current != nil {
+		record.upload = remote.current.upload
+	}
+	if facility, err := journal.GetDataValue("SYSLOG_FACILITY"); err == nil && facility != "" &&
+		config.AllowField("SYSLOG_FACILITY") {
+		record.hasFacility = true
+	}
+
 	return record, err
 }
 
@@ -117,7 +211,11 @@ func decodeRecord(journal Journal, toVal reflect.Value, logger lg.Logger, config
 			break
 		case reflect.String:
 
-			fieldVal.SetString(trimField(value, config.FieldLength))
+			fieldLength := config.FieldLength
+			if jdKey == "MESSAGE" {
+				fieldLength = config.MessageLength
+			}
+			fieldVal.SetString(trimFieldValue(value, fieldLength))
 			break
 
 		case reflect.Int64:
@@ -127,7 +225,7 @@ func decodeRecord(journal Journal, toVal reflect.Value, logger lg.Logger, config
 				fieldVal.Set(reflect.Zero(fieldType))
 				continue
 			}
-			fieldVal.SetInt(u / 1000)
+			fieldVal.SetInt(u)
 			break
 
 		default:
@@ -137,15 +235,69 @@ func decodeRecord(journal Journal, toVal reflect.Value, logger lg.Logger, config
 
 	return nil
 }
+
+// trimField cuts a value to at most fieldLength bytes, ending with TRUNCATION_MARKER, without
+// splitting a UTF-8 sequence.
 func trimField(value string, fieldLength int) string {
 
 	if fieldLength == 0 {
 		fieldLength = 255
 	}
 
-	if fieldLength < len(value) {
-		return value[0:fieldLength]
-	} else {
+	if fieldLength >= len(value) {
+		return value
+	} else if fieldLength <= len(TRUNCATION_MARKER) {
+		return truncateUTF8(value, fieldLength)
+	}
+	return truncateUTF8(value, fieldLength-len(TRUNCATION_MARKER)) + TRUNCATION_MARKER
+}
+
+// truncateUTF8 returns the longest start of value of at most length bytes that ends on a
+// character boundary.
+func truncateUTF8(value string, length int) string {
+	if length >= len(value) {
+		return value
+	}
+	for length > 0 && !utf8.RuneStart(value[length]) {
+		length--
+	}
+	return value[:length]
+}
+
+// encodeBinaryField base64 encodes a value that is not valid UTF-8 or contains NUL bytes,
+// like the binary fields journalctl -o json writes as arrays of bytes.
+func encodeBinaryField(value string) string {
+	if !isBinaryField(value) {
 		return value
 	}
+	return BINARY_FIELD_PREFIX + base64.StdEncoding.EncodeToString([]byte(value))
+}
+
+func isBinaryField(value string) bool {
+	return !utf8.ValidString(value) || strings.ContainsRune(value, 0)
+}
+
+// trimFieldValue encodes a binary field and cuts a field to fieldLength. Binary values are cut
+// before they are encoded, so the base64 text before the TRUNCATION_MARKER still decodes to the
+// first bytes of the value.
+func trimFieldValue(value string, fieldLength int) string {
+
+	if !isBinaryField(value) {
+		return trimField(value, fieldLength)
+	}
+
+	if fieldLength == 0 {
+		fieldLength = 255
+	}
+	encoded := encodeBinaryField(value)
+	if fieldLength >= len(encoded) {
+		return encoded
+	}
+
+	// Every 3 bytes encode to 4 characters without padding.
+	length := 0
+	if room := fieldLength - len(BINARY_FIELD_PREFIX) - len(TRUNCATION_MARKER); room > 0 {
+		length = room / 4 * 3
+	}
+	return BINARY_FIELD_PREFIX + base64.StdEncoding.EncodeToString([]byte(value[:length])) + TRUNCATION_MARKER
 }
@@ -1,8 +1,11 @@
 package cloud_watch
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"strings"
 	"testing"
+	"unicode/utf8"
 	lg "github.com/advantageous/go-logback/logging"
 )
 
@@ -57,7 +60,7 @@ debug=true
 		t.Fail()
 	}
 
-	if record.TimeUsec != 1480459022025952/1000 {
+	if record.TimeUsec != 1480459022025952 {
 		t.Logf("Unable to read time stamp %d", record.TimeUsec)
 		t.Fail()
 	}
@@ -93,7 +96,7 @@ debug=true
 		t.Fail()
 	}
 
-	if record.TimeUsec != 1480459022025952/1000 {
+	if record.TimeUsec != 1480459022025952 {
 		t.Logf("Unable to read time stamp %d", record.TimeUsec)
 		t.Fail()
 	}
@@ -136,7 +139,7 @@ fields=["__REALTIME_TIMESTAMP"]
 		t.Fail()
 	}
 
-	if record.TimeUsec != 1480459022025952/1000 {
+	if record.TimeUsec != 1480459022025952 {
 		t.Logf("Unable to read time stamp %d", record.TimeUsec)
 		t.Fail()
 	}
@@ -174,9 +177,105 @@ omit_fields=["_CMDLINE"]
 		t.Fail()
 	}
 
-	if record.TimeUsec != 1480459022025952/1000 {
+	if record.TimeUsec != 1480459022025952 {
 		t.Logf("Unable to read time stamp %d", record.TimeUsec)
 		t.Fail()
 	}
 
 }
+
+func TestTrimField(t *testing.T) {
+
+	for _, test := range []struct {
+		value    string
+		length   int
+		expected string
+	}{
+		{"hello", 10, "hello"},
+		{"hello world", 8, "hello" + TRUNCATION_MARKER},
+		{"héllo wörld", 6, "hé" + TRUNCATION_MARKER},
+		{"日本語のテキスト", 11, "日本" + TRUNCATION_MARKER},
+		{"日本語", 2, ""},
+	} {
+		trimmed := trimField(test.value, test.length)
+		if trimmed != test.expected || len(trimmed) > test.length || !utf8.ValidString(trimmed) {
+			t.Errorf("%q cut at %d is %q not %q", test.value, test.length, trimmed, test.expected)
+		}
+	}
+}
+
+func TestBinaryAndLongFields(t *testing.T) {
+
+	values := map[string]string{
+		"__REALTIME_TIMESTAMP": "1480459022025952",
+		"MESSAGE":              strings.Repeat("é", 200),
+		"SYSLOG_IDENTIFIER":    "\x00\x01\x02binary",
+		"_COMM":                "\xff\xfe",
+		"_CMDLINE":             "line one\nline two\ttabbed and more",
+	}
+
+	config, _ := LoadConfigFromString(`field_length=24
+message_length=301`, nil)
+	record, err := NewRecord(NewJournalWithMap(values), nil, config)
+	if err != nil {
+		t.Fatalf("Unable to read record %s", err)
+	}
+
+	if record.Identifier != "base64:AAECYmluYXJ5" || record.Command != "base64://4=" {
+		t.Errorf("Binary fields not encoded %q %q", record.Identifier, record.Command)
+	}
+	if record.CommandLine != "line one\nline two\ttab"+TRUNCATION_MARKER {
+		t.Errorf("Text field not cut at field_length %q", record.CommandLine)
+	}
+	if record.Message != strings.Repeat("é", 149)+TRUNCATION_MARKER {
+		t.Errorf("Message not cut at message_length, %d bytes", len(record.Message))
+	}
+}
+
+func TestLongBinaryField(t *testing.T) {
+
+	binary := "\x00" + strings.Repeat("\x01\x02\xff", 20)
+	values := map[string]string{
+		"__REALTIME_TIMESTAMP": "1480459022025952",
+		"SYSLOG_IDENTIFIER":    binary,
+	}
+
+	config, _ := LoadConfigFromString(`field_length=24`, nil)
+	record, err := NewRecord(NewJournalWithMap(values), nil, config)
+	if err != nil {
+		t.Fatalf("Unable to read record %s", err)
+	}
+
+	encoded := strings.TrimSuffix(strings.TrimPrefix(record.Identifier, BINARY_FIELD_PREFIX), TRUNCATION_MARKER)
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if len(record.Identifier) > 24 || !strings.HasSuffix(record.Identifier, TRUNCATION_MARKER) || err != nil ||
+		string(decoded) != binary[:9] {
+		t.Errorf("Expected the first bytes of the binary field to be encoded, got %q %q %v", record.Identifier, decoded, err)
+	}
+}
+
+func TestRecordTimingAndEntryId(t *testing.T) {
+
+	config, _ := LoadConfigFromString(``, nil)
+	record, err := NewRecord(NewJournalWithMap(testMap), nil, config)
+	if err != nil {
+		t.Fatalf("Unable to read record %s", err)
+	}
+
+	if record.TimeUsec != 1480459022025952 || record.BootId != "923def0648b1422aa28a8846072481f2" {
+		t.Errorf("Wrong time or boot %d %s", record.TimeUsec, record.BootId)
+	}
+	if record.EntryId != "6c072e0567ff423fa9cb39f136066299-3" {
+		t.Errorf("Wrong entry id %s", record.EntryId)
+	}
+
+	data, _ := json.Marshal(newTimestampedRecord(record))
+	if !strings.Contains(string(data), `"@timestamp":"2016-11-29T22:37:02.025952Z"`) ||
+		!strings.Contains(string(data), `"entryId":"6c072e0567ff423fa9cb39f136066299-3"`) {
+		t.Errorf("Wrong JSON %s", data)
+	}
+
+	if id := entryId("i=5"); id != "i=5" {
+		t.Errorf("Expected cursors without a sequence number id to be kept, got %s", id)
+	}
+}
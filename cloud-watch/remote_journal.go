@@ -0,0 +1,363 @@
+package cloud_watch
+
+import (
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	JOURNAL_READER_REMOTE = "remote"
+	// REMOTE_CONTENT_TYPE is the content type of the export stream systemd-journal-upload sends.
+	REMOTE_CONTENT_TYPE = "application/vnd.fdo.journal"
+	// REMOTE_UPLOAD_PATH is where systemd-journal-upload posts entries.
+	REMOTE_UPLOAD_PATH = "/upload"
+)
+
+// remoteEntry is an entry received from a sender.
+type remoteEntry struct {
+	*exportEntry
+	sender string
+	upload *remoteUpload
+}
+
+// remoteUpload is an upload that is answered once its entries were sent, so the sender only
+// moves its cursor past entries that can not be lost any more.
+type remoteUpload struct {
+	pending sync.WaitGroup
+	failed  int32
+}
+
+func (upload *remoteUpload) done(err error) {
+	if err != nil {
+		atomic.StoreInt32(&upload.failed, 1)
+	}
+	upload.pending.Done()
+}
+
+// acknowledgeEntry tells the upload of the current entry of a remote journal that it was
+// handled without a record, e.g. because it was sent before.
+func acknowledgeEntry(journal Journal, err error) {
+	if remote, ok := journal.(*RemoteJournal); ok && remote.current != nil {
+		remote.current.upload.done(err)
+	}
+}
+
+// acknowledgeRecords tells the uploads of the records whether they were sent.
+func acknowledgeRecords(records []*Record, err error) {
+	for _, record := range records {
+		if record.upload != nil {
+			record.upload.done(err)
+		}
+	}
+}
+
+// RemoteJournal receives entries from systemd-journal-upload like systemd-journal-remote does.
+// Uploads are read as they arrive and handed to Next one entry at a time, so a slow repeater
+// slows the uploads down instead of buffering them. An upload is only answered once all of its
+// entries were sent by the repeater. The source of each record is the sender.
+type RemoteJournal struct {
+	listener net.Listener
+	server   *http.Server
+	entries  chan *remoteEntry
+	closed   chan struct{}
+	current  *remoteEntry
+	// pending is an entry received by Wait that Next returns next.
+	pending *remoteEntry
+	filter  *journalFilter
+}
+
+// NewRemoteJournal listens on remote_listen, with HTTPS when remote_cert_file is set.
+func NewRemoteJournal(config *Config) (*RemoteJournal, error) {
+
+	journal := &RemoteJournal{
+		entries: make(chan *remoteEntry, config.QueueChannelSize),
+		closed:  make(chan struct{}),
+		filter:  newJournalFilter(),
+	}
+
+	listener, err := net.Listen("tcp", config.RemoteListen)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s: %s %v", config.RemoteListen, err.Error(), err)
+	}
+
+	if config.RemoteCertFile != "" {
+		tlsConfig, err := newRemoteTlsConfig(config)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	journal.listener = listener
+	journal.server = &http.Server{Handler: journal}
+	go journal.server.Serve(listener)
+	return journal, nil
+}
+
+// newRemoteTlsConfig loads the server certificate. With remote_ca_file senders must present a
+// client certificate signed by it, like the --trust option of systemd-journal-remote.
+func newRemoteTlsConfig(config *Config) (*tls.Config, error) {
+
+	certificate, err := tls.LoadX509KeyPair(config.RemoteCertFile, config.RemoteKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load remote_cert_file %s: %s %v", config.RemoteCertFile, err.Error(), err)
+	}
+
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{certificate}}
+	if config.RemoteCAFile != "" {
+		pool, err := loadCertPool(config.RemoteCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// Addr is the address the journal listens on.
+func (journal *RemoteJournal) Addr() net.Addr {
+	return journal.listener.Addr()
+}
+
+// ServeHTTP reads an upload of systemd-journal-upload, a POST of the export format to /upload.
+func (journal *RemoteJournal) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+
+	if request.URL.Path != REMOTE_UPLOAD_PATH {
+		http.Error(writer, "Not found.", http.StatusNotFound)
+		return
+	}
+	if request.Method != http.MethodPost {
+		http.Error(writer, "Method not allowed, use POST.", http.StatusMethodNotAllowed)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type")); mediaType != REMOTE_CONTENT_TYPE {
+		http.Error(writer, "Content-Type must be "+REMOTE_CONTENT_TYPE+".", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	upload := &remoteUpload{}
+	reader := newExportJournalFromReader(request.Body)
+	for {
+		entry, err := reader.readEntry()
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if entry == nil {
+			break
+		}
+		upload.pending.Add(1)
+		select {
+		case journal.entries <- &remoteEntry{exportEntry: entry, sender: remoteSender(request, entry), upload: upload}:
+		case <-journal.closed:
+			http.Error(writer, "Shutting down.", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	// The sender moves its cursor past the upload once it is accepted, so the answer waits
+	// until the entries were sent.
+	sent := make(chan struct{})
+	go func() {
+		upload.pending.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-journal.closed:
+		http.Error(writer, "Shutting down.", http.StatusServiceUnavailable)
+		return
+	case <-request.Context().Done():
+		return
+	}
+	if atomic.LoadInt32(&upload.failed) != 0 {
+		http.Error(writer, "Entries could not be sent, upload them again.", http.StatusServiceUnavailable)
+		return
+	}
+
+	writer.WriteHeader(http.StatusAccepted)
+	writer.Write([]byte("OK.\n"))
+}
+
+// remoteSender names the sender of an entry: the common name of its client certificate, the
+// _HOSTNAME of the entry or else the address it connected from. Entries without _HOSTNAME get
+// the sender as their hostname.
+func remoteSender(request *http.Request, entry *exportEntry) string {
+
+	sender := entry.fields["_HOSTNAME"]
+	if request.TLS != nil && len(request.TLS.PeerCertificates) > 0 {
+		sender = request.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	if sender == "" {
+		sender = request.RemoteAddr
+		if host, _, err := net.SplitHostPort(request.RemoteAddr); err == nil {
+			sender = host
+		}
+	}
+
+	if entry.fields["_HOSTNAME"] == "" {
+		entry.fields["_HOSTNAME"] = sender
+	}
+	return sender
+}
+
+// Source is the sender of the current entry.
+func (journal *RemoteJournal) Source() string {
+	if journal.current == nil {
+		return ""
+	}
+	return journal.current.sender
+}
+
+// Close stops listening, uploads in progress are ended.
+func (journal *RemoteJournal) Close() error {
+	select {
+	case <-journal.closed:
+		return nil
+	default:
+		close(journal.closed)
+	}
+	return journal.server.Close()
+}
+
+// Next returns the next entry that was received, it does not wait for one.
+func (journal *RemoteJournal) Next() (uint64, error) {
+	for {
+		entry := journal.pending
+		journal.pending = nil
+		if entry == nil {
+			select {
+			case entry = <-journal.entries:
+			default:
+				return 0, nil
+			}
+		}
+		journal.current = entry
+		if journal.filter.empty() || journal.filter.matches(entry.fields) {
+			return 1, nil
+		}
+		entry.upload.done(nil)
+	}
+}
+
+// NextSkip advances the read pointer by multiple entries at once,
+// as specified by the skip parameter.
+func (journal *RemoteJournal) NextSkip(skip uint64) (uint64, error) {
+	var skipped uint64
+	for ; skipped < skip; skipped++ {
+		count, err := journal.Next()
+		if err != nil || count == 0 {
+			return skipped, err
+		}
+	}
+	return skipped, nil
+}
+
+// Previous does not move, received entries are not kept.
+func (journal *RemoteJournal) Previous() (uint64, error) {
+	return 0, nil
+}
+
+// PreviousSkip does not move, received entries are not kept.
+func (journal *RemoteJournal) PreviousSkip(skip uint64) (uint64, error) {
+	return 0, nil
+}
+
+// GetDataValue gets the value of a field of the current entry, __ fields are not returned.
+func (journal *RemoteJournal) GetDataValue(field string) (string, error) {
+	if journal.current == nil {
+		return "", fmt.Errorf("no current journal entry")
+	}
+	value, found := journal.current.fields[field]
+	if !found || strings.HasPrefix(field, "__") {
+		return "", fmt.Errorf("field %s not found", field)
+	}
+	return value, nil
+}
+
+// GetRealtimeUsec gets the realtime (wallclock) timestamp of the current
+// journal entry.
+func (journal *RemoteJournal) GetRealtimeUsec() (uint64, error) {
+	if journal.current == nil {
+		return 0, fmt.Errorf("no current journal entry")
+	}
+	return journal.current.realtime, nil
+}
+
+// GetMonotonicUsec gets the monotonic timestamp of the current journal entry.
+func (journal *RemoteJournal) GetMonotonicUsec() (uint64, error) {
+	if journal.current == nil {
+		return 0, fmt.Errorf("no current journal entry")
+	}
+	return journal.current.monotonic, nil
+}
+
+// GetCursor gets the cursor of the current entry in the journal of its sender.
+func (journal *RemoteJournal) GetCursor() (string, error) {
+	if journal.current == nil {
+		return "", fmt.Errorf("no current journal entry")
+	}
+	cursor, found := journal.current.fields["__CURSOR"]
+	if !found {
+		return "", fmt.Errorf("entry from %s has no __CURSOR", journal.current.sender)
+	}
+	return cursor, nil
+}
+
+// AddLogFilters adds the log_priority matches.
+func (journal *RemoteJournal) AddLogFilters(config *Config) {
+	journal.filter.addLogFilters(config)
+}
+
+// AddMatch adds a match by which to filter the entries of the journal.
+func (journal *RemoteJournal) AddMatch(match string) error {
+	return journal.filter.addMatch(match)
+}
+
+// SeekHead does nothing, the senders keep track of what they uploaded.
+func (journal *RemoteJournal) SeekHead() error {
+	return nil
+}
+
+// SeekTail does nothing, the senders keep track of what they uploaded.
+func (journal *RemoteJournal) SeekTail() error {
+	return nil
+}
+
+// SeekCursor does nothing, the senders keep track of what they uploaded.
+func (journal *RemoteJournal) SeekCursor(cursor string) error {
+	return nil
+}
+
+// SeekRealtimeUsec does nothing, the senders keep track of what they uploaded.
+func (journal *RemoteJournal) SeekRealtimeUsec(usec uint64) error {
+	return nil
+}
+
+// ListBoots returns no boots, the boots of the senders are not known.
+func (journal *RemoteJournal) ListBoots() ([]JournalBoot, error) {
+	return []JournalBoot{}, nil
+}
+
+// Wait waits for an entry to be received. It returns 1 (SD_JOURNAL_APPEND) when one was and
+// 0 (SD_JOURNAL_NOP) when the timeout passed.
+func (journal *RemoteJournal) Wait(timeout time.Duration) int {
+	if journal.pending != nil {
+		return 1
+	}
+	select {
+	case journal.pending = <-journal.entries:
+		return 1
+	case <-time.After(timeout):
+		return 0
+	}
+}
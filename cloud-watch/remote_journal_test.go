@@ -0,0 +1,157 @@
+package cloud_watch
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openRemoteJournal(t *testing.T) (*RemoteJournal, *Config) {
+	config, _ := LoadConfigFromString(`journal_reader="remote"
+remote_listen="127.0.0.1:0"`, nil)
+	journal, err := NewRemoteJournal(config)
+	if err != nil {
+		t.Fatalf("Unable to listen %s", err)
+	}
+	return journal, config
+}
+
+func TestRemoteJournalUpload(t *testing.T) {
+
+	journal, config := openRemoteJournal(t)
+	defer journal.Close()
+
+	file, err := os.Open("testdata/export/sample.export")
+	if err != nil {
+		t.Fatalf("Unable to open sample %s", err)
+	}
+	defer file.Close()
+
+	status := postUpload(journal, file)
+	records := readRemoteRecords(t, journal, config, 7)
+
+	select {
+	case code := <-status:
+		t.Fatalf("Expected the upload to wait until its entries were sent, got %d", code)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	acknowledgeRecords(records, nil)
+	if code := <-status; code != http.StatusAccepted {
+		t.Errorf("Expected the upload to be accepted, got %d", code)
+	}
+	if len(records) != 7 {
+		t.Fatalf("Expected 7 records, got %d", len(records))
+	}
+	if records[4].Message != "line one\nline two" || records[4].Source != "vm" || records[4].Hostname != "vm" ||
+		records[4].MachineId != "fed6b2924c424cf1b9a322f606b4de6d" {
+		t.Errorf("Wrong record %+v", records[4])
+	}
+}
+
+func TestRemoteJournalUploadNotSent(t *testing.T) {
+
+	journal, config := openRemoteJournal(t)
+	defer journal.Close()
+
+	status := postUpload(journal, strings.NewReader("__REALTIME_TIMESTAMP=1\nMESSAGE=one\n\n"))
+	records := readRemoteRecords(t, journal, config, 1)
+
+	acknowledgeRecords(records, errors.New("destination unavailable"))
+	if code := <-status; code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the upload to fail when its entries were not sent, got %d", code)
+	}
+}
+
+// postUpload uploads body to journal, the status code of the response is sent to the channel.
+func postUpload(journal *RemoteJournal, body io.Reader) chan int {
+	status := make(chan int, 1)
+	go func() {
+		response, err := http.Post("http://"+journal.Addr().String()+REMOTE_UPLOAD_PATH, REMOTE_CONTENT_TYPE, body)
+		if err != nil {
+			status <- 0
+			return
+		}
+		response.Body.Close()
+		status <- response.StatusCode
+	}()
+	return status
+}
+
+// readRemoteRecords reads up to count records from journal.
+func readRemoteRecords(t *testing.T, journal *RemoteJournal, config *Config, count int) []*Record {
+	records := []*Record{}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(records) < count && time.Now().Before(deadline) {
+		read, err := journal.Next()
+		if err != nil {
+			t.Fatalf("Unable to read %s", err)
+		}
+		if read == 0 {
+			journal.Wait(100 * time.Millisecond)
+			continue
+		}
+		record, err := NewRecord(journal, nil, config)
+		if err != nil {
+			t.Fatalf("Unable to read record %s", err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRemoteJournalRejectsRequests(t *testing.T) {
+
+	journal, _ := openRemoteJournal(t)
+	defer journal.Close()
+	url := "http://" + journal.Addr().String()
+
+	response, err := http.Post(url+REMOTE_UPLOAD_PATH, "application/json", strings.NewReader("{}"))
+	if err != nil || response.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected JSON uploads to be rejected %v %v", response, err)
+	}
+
+	response, err = http.Get(url + REMOTE_UPLOAD_PATH)
+	if err != nil || response.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET to be rejected %v %v", response, err)
+	}
+
+	response, err = http.Post(url+"/other", REMOTE_CONTENT_TYPE, strings.NewReader(""))
+	if err != nil || response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected other paths to be rejected %v %v", response, err)
+	}
+
+	response, err = http.Post(url+REMOTE_UPLOAD_PATH, REMOTE_CONTENT_TYPE, strings.NewReader("MESSAGE=no time\n\n"))
+	if err != nil || response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected entries without a time to be rejected %v %v", response, err)
+	}
+}
+
+func TestRemoteSender(t *testing.T) {
+
+	request, _ := http.NewRequest(http.MethodPost, "http://collector"+REMOTE_UPLOAD_PATH, nil)
+	request.RemoteAddr = "10.0.0.7:41234"
+
+	entry := &exportEntry{fields: map[string]string{"MESSAGE": "hello"}}
+	if sender := remoteSender(request, entry); sender != "10.0.0.7" || entry.fields["_HOSTNAME"] != "10.0.0.7" {
+		t.Errorf("Expected the remote address as sender, got %s %s", sender, entry.fields["_HOSTNAME"])
+	}
+
+	entry = &exportEntry{fields: map[string]string{"_HOSTNAME": "web-1"}}
+	if sender := remoteSender(request, entry); sender != "web-1" {
+		t.Errorf("Expected the hostname as sender, got %s", sender)
+	}
+
+	request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{
+		{Subject: pkix.Name{CommonName: "web-1.example.com"}}}}
+	if sender := remoteSender(request, entry); sender != "web-1.example.com" || entry.fields["_HOSTNAME"] != "web-1" {
+		t.Errorf("Expected the certificate name as sender, got %s %s", sender, entry.fields["_HOSTNAME"])
+	}
+}
@@ -0,0 +1,53 @@
+package cloud_watch
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// StdoutJournalRepeater writes one JSON encoded record per line, for running as a sidecar
+// where the container runtime collects stdout.
+type StdoutJournalRepeater struct {
+	out    io.Writer
+	writer *bufio.Writer
+}
+
+func NewStdoutJournalRepeater() *StdoutJournalRepeater {
+	return NewWriterJournalRepeater(os.Stdout)
+}
+
+// NewWriterJournalRepeater writes the same newline delimited JSON as the stdout repeater to any writer.
+func NewWriterJournalRepeater(writer io.Writer) *StdoutJournalRepeater {
+	return &StdoutJournalRepeater{out: writer, writer: bufio.NewWriter(writer)}
+}
+
+func (repeater *StdoutJournalRepeater) Close() error {
+	return repeater.writer.Flush()
+}
+
+// WriteBatch returns the first error writing the batch. The buffered writer keeps failing after
+// an error, so it is reset and a retry writes the whole batch again.
+func (repeater *StdoutJournalRepeater) WriteBatch(records []*Record) error {
+
+	for _, record := range records {
+		line, err := json.Marshal(newTimestampedRecord(record))
+		if err != nil {
+			return err
+		}
+		if _, err = repeater.writer.Write(line); err == nil {
+			err = repeater.writer.WriteByte('\n')
+		}
+		if err != nil {
+			repeater.writer.Reset(repeater.out)
+			return err
+		}
+	}
+
+	if err := repeater.writer.Flush(); err != nil {
+		repeater.writer.Reset(repeater.out)
+		return err
+	}
+	return nil
+}
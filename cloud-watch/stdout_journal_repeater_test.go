@@ -0,0 +1,64 @@
+package cloud_watch
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStdoutRepeaterWritesJsonLines(t *testing.T) {
+
+	var out bytes.Buffer
+	repeater := NewWriterJournalRepeater(&out)
+
+	err := repeater.WriteBatch([]*Record{
+		{Message: "one", TimeUsec: 1480459022025000},
+		{Message: "two", TimeUsec: 1480459022026000},
+	})
+	if err != nil {
+		t.Fatalf("Unable to write batch %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected two lines got %q", out.String())
+	}
+
+	if !strings.HasPrefix(lines[0], `{"@timestamp":"2016-11-29T22:37:02.025Z",`) ||
+		!strings.Contains(lines[0], `"message":"one"`) {
+		t.Errorf("Unexpected line %s", lines[0])
+	}
+}
+
+// brokenWriter fails every write until it is repaired.
+type brokenWriter struct {
+	bytes.Buffer
+	broken bool
+}
+
+func (writer *brokenWriter) Write(data []byte) (int, error) {
+	if writer.broken {
+		return 0, errors.New("broken pipe")
+	}
+	return writer.Buffer.Write(data)
+}
+
+func TestStdoutRepeaterReturnsWriteErrors(t *testing.T) {
+
+	out := &brokenWriter{broken: true}
+	repeater := NewWriterJournalRepeater(out)
+	batch := []*Record{{Message: strings.Repeat("x", 5000), TimeUsec: 1480459022025000}}
+
+	if err := repeater.WriteBatch(batch); err == nil || err.Error() != "broken pipe" {
+		t.Errorf("Expected the write error, got %v", err)
+	}
+
+	out.broken = false
+	if err := repeater.WriteBatch(batch); err != nil {
+		t.Errorf("Expected the batch to be written once the writer works %s", err)
+	}
+	if strings.Count(out.String(), "\n") != 1 {
+		t.Errorf("Expected one line, got %d bytes", out.Len())
+	}
+}
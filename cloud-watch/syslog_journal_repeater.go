@@ -0,0 +1,298 @@
+package cloud_watch
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	lg "github.com/advantageous/go-logback/logging"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	syslogTimeFormat   = "2006-01-02T15:04:05.000000Z07:00"
+	syslogNilValue     = "-"
+	syslogUserFacility = 1
+	syslogMaxBackoff   = 30 * time.Second
+)
+
+// SyslogJournalRepeater forwards records to a remote syslog server as RFC 5424 messages
+// over UDP, TCP or TLS.
+type SyslogJournalRepeater struct {
+	address        string
+	protocol       string
+	octetCounting  bool
+	tlsConfig      *tls.Config
+	structuredData []string
+	sdId           string
+	hostname       string
+	maxRetries     int
+	backoff        time.Duration
+	timeout        time.Duration
+	conn           net.Conn
+	logger         lg.Logger
+	config         *Config
+}
+
+// NewSyslogJournalRepeater creates a repeater for the server at syslog_address. The connection
+// is opened with the first batch and reopened whenever a write fails.
+func NewSyslogJournalRepeater(logger lg.Logger, config *Config) (*SyslogJournalRepeater, error) {
+
+	if config.SyslogAddress == "" {
+		return nil, errors.New("syslog_address must be set to use the syslog repeater")
+	}
+
+	if logger == nil {
+		if !config.Debug {
+			logger = lg.GetSimpleLogger("SYSLOG_REPEATER_DEBUG", "syslog-repeater")
+		} else {
+			logger = lg.NewSimpleDebugLogger("syslog-repeater")
+		}
+	}
+
+	repeater := &SyslogJournalRepeater{
+		address:        config.SyslogAddress,
+		protocol:       config.SyslogProtocol,
+		octetCounting:  config.SyslogFraming == "octet-counting",
+		structuredData: config.SyslogStructuredData,
+		sdId:           config.SyslogStructuredDataId,
+		maxRetries:     config.SyslogMaxRetries,
+		backoff:        time.Duration(config.SyslogReconnectBackoffMS) * time.Millisecond,
+		timeout:        time.Duration(config.SyslogTimeoutMS) * time.Millisecond,
+		logger:         logger,
+		config:         config,
+	}
+
+	switch config.SyslogProtocol {
+	case "udp", "tcp":
+	case "tls":
+		tlsConfig, err := newSyslogTlsConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		repeater.tlsConfig = tlsConfig
+	default:
+		return nil, fmt.Errorf("syslog_protocol must be udp, tcp or tls, not %s", config.SyslogProtocol)
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		repeater.hostname = hostname
+	}
+
+	return repeater, nil
+}
+
+func newSyslogTlsConfig(config *Config) (*tls.Config, error) {
+
+	tlsConfig := &tls.Config{ServerName: config.SyslogTlsServerName}
+
+	if tlsConfig.ServerName == "" {
+		host, _, err := net.SplitHostPort(config.SyslogAddress)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ServerName = host
+	}
+
+	if config.SyslogCAFile != "" {
+		pool, err := loadCertPool(config.SyslogCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+func (repeater *SyslogJournalRepeater) Close() error {
+	if repeater.conn != nil {
+		err := repeater.conn.Close()
+		repeater.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (repeater *SyslogJournalRepeater) connect() error {
+
+	var conn net.Conn
+	var err error
+
+	dialer := &net.Dialer{Timeout: repeater.timeout}
+
+	if repeater.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", repeater.address, repeater.tlsConfig)
+	} else {
+		conn, err = dialer.Dial(repeater.protocol, repeater.address)
+	}
+
+	if err != nil {
+		return err
+	}
+	repeater.conn = conn
+	return nil
+}
+
+func (repeater *SyslogJournalRepeater) WriteBatch(records []*Record) error {
+
+	for _, record := range records {
+		err := repeater.writeWithReconnect(repeater.frame(repeater.formatMessage(record)))
+		if err != nil {
+			return err
+		}
+	}
+
+	if repeater.config.Debug {
+		repeater.logger.Debug("SENT SUCCESSFULLY")
+	}
+	return nil
+}
+
+// writeWithReconnect writes one framed message, reconnecting with exponential backoff
+// when the connection is missing or the write fails.
+func (repeater *SyslogJournalRepeater) writeWithReconnect(message []byte) error {
+
+	backoff := repeater.backoff
+	var err error
+
+	for attempt := 0; attempt <= repeater.maxRetries; attempt++ {
+
+		if attempt > 0 {
+			repeater.logger.Warnf("Reconnecting to syslog %s in %s, attempt %d : %v",
+				repeater.address, backoff, attempt, err)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > syslogMaxBackoff {
+				backoff = syslogMaxBackoff
+			}
+		}
+
+		if repeater.conn == nil {
+			err = repeater.connect()
+			if err != nil {
+				continue
+			}
+		}
+
+		if repeater.timeout > 0 {
+			repeater.conn.SetWriteDeadline(time.Now().Add(repeater.timeout))
+		}
+
+		_, err = repeater.conn.Write(message)
+		if err == nil {
+			return nil
+		}
+		repeater.Close()
+	}
+
+	return fmt.Errorf("failed to write to syslog %s after %d retries: %s %v",
+		repeater.address, repeater.maxRetries, err.Error(), err)
+}
+
+// frame applies octet counting (RFC 6587) or a trailing newline for stream transports.
+// UDP datagrams are sent as is.
+func (repeater *SyslogJournalRepeater) frame(message []byte) []byte {
+	if repeater.protocol == "udp" {
+		return message
+	}
+	if repeater.octetCounting {
+		return append([]byte(strconv.Itoa(len(message))+" "), message...)
+	}
+	return append(message, '\n')
+}
+
+// formatMessage renders the record as an RFC 5424 message.
+func (repeater *SyslogJournalRepeater) formatMessage(record *Record) []byte {
+
+	// Entries without SYSLOG_FACILITY are from user space unless the kernel logged them.
+	facility := record.Facility
+	if facility == 0 && !record.hasFacility && record.Transport != "kernel" {
+		facility = syslogUserFacility
+	}
+
+	hostname := record.Hostname
+	if hostname == "" {
+		hostname = repeater.hostname
+	}
+
+	appName := record.Identifier
+	if appName == "" {
+		appName = record.Command
+	}
+
+	procId := ""
+	if record.SysPID != 0 {
+		procId = strconv.Itoa(record.SysPID)
+	} else if record.PID != 0 {
+		procId = strconv.Itoa(record.PID)
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "<%d>1 %s %s %s %s %s %s",
+		facility*8+int(record.Priority),
+		recordTime(record).UTC().Format(syslogTimeFormat),
+		syslogHeaderField(hostname, 255),
+		syslogHeaderField(appName, 48),
+		syslogHeaderField(procId, 128),
+		syslogHeaderField(record.MessageId, 32),
+		repeater.formatStructuredData(record))
+
+	if record.Message != "" {
+		message.WriteString(" ")
+		message.WriteString(record.Message)
+	}
+
+	return message.Bytes()
+}
+
+// formatStructuredData renders the configured record fields as a single SD-ELEMENT.
+func (repeater *SyslogJournalRepeater) formatStructuredData(record *Record) string {
+
+	if len(repeater.structuredData) == 0 {
+		return syslogNilValue
+	}
+
+	lookup := recordLookup(record)
+	var data bytes.Buffer
+
+	for _, name := range repeater.structuredData {
+		value, found := lookup(name, "")
+		if !found || value == "" {
+			continue
+		}
+		fmt.Fprintf(&data, " %s=\"%s\"", name, syslogParamEscaper.Replace(value))
+	}
+
+	if data.Len() == 0 {
+		return syslogNilValue
+	}
+	return "[" + repeater.sdId + data.String() + "]"
+}
+
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogHeaderField replaces anything but printable US-ASCII and truncates to the
+// length allowed for the header field, empty values become the NILVALUE.
+func syslogHeaderField(value string, maxLength int) string {
+
+	if value == "" {
+		return syslogNilValue
+	}
+
+	field := []byte(value)
+	for i, c := range field {
+		if c < 33 || c > 126 {
+			field[i] = '_'
+		}
+	}
+
+	if len(field) > maxLength {
+		field = field[:maxLength]
+	}
+	return string(field)
+}
@@ -0,0 +1,132 @@
+package cloud_watch
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var syslogTestRecord = &Record{
+	Message:     "session opened",
+	Priority:    INFO,
+	Facility:    10,
+	Identifier:  "sshd",
+	SysPID:      1234,
+	MessageId:   "f77379a8490b408bbe5f6940505a777b",
+	Hostname:    "ip-10-0-0-1",
+	SystemdUnit: "sshd.service",
+	Command:     `quote"d]`,
+	TimeUsec:    1480459022025000,
+}
+
+func TestSyslogFormatMessage(t *testing.T) {
+
+	config, _ := LoadConfigFromString(`
+syslog_address="localhost:514"
+syslog_structured_data=["systemdUnit", "cmdName", "exe"]
+`, nil)
+
+	repeater, err := NewSyslogJournalRepeater(nil, config)
+	if err != nil {
+		t.Fatalf("Unable to create repeater %s", err)
+	}
+
+	message := string(repeater.formatMessage(syslogTestRecord))
+	expected := `<86>1 2016-11-29T22:37:02.025000Z ip-10-0-0-1 sshd 1234 f77379a8490b408bbe5f6940505a777b ` +
+		`[journal@32473 systemdUnit="sshd.service" cmdName="quote\"d\]"] session opened`
+
+	if message != expected {
+		t.Errorf("Unexpected message\n%s\n%s", message, expected)
+	}
+
+	message = string(repeater.formatMessage(&Record{Message: "bare", Priority: ERROR, TimeUsec: 1480459022025000}))
+	if !strings.HasPrefix(message, "<11>1 ") || !strings.HasSuffix(message, " - - - - bare") {
+		t.Errorf("Missing fields should be NILVALUE %s", message)
+	}
+
+	record, err := NewRecord(NewJournalWithMap(map[string]string{
+		"__REALTIME_TIMESTAMP": "1480459022025952",
+		"MESSAGE":              "kern from syslog",
+		"PRIORITY":             "3",
+		"SYSLOG_FACILITY":      "0",
+		"_TRANSPORT":           "syslog",
+	}), nil, config)
+	if err != nil {
+		t.Fatalf("Unable to read record %s", err)
+	}
+	if message = string(repeater.formatMessage(record)); !strings.HasPrefix(message, "<3>1 ") {
+		t.Errorf("Explicit kern facility should be kept %s", message)
+	}
+}
+
+func TestSyslogOctetCountingOverTcp(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen %s", err)
+	}
+	defer listener.Close()
+
+	received := make(chan []string, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		frames := make([]string, 0)
+		for len(frames) < 2 {
+			length, err := reader.ReadString(' ')
+			if err != nil {
+				break
+			}
+			size, _ := strconv.Atoi(strings.TrimSpace(length))
+			frame := make([]byte, size)
+			io.ReadFull(reader, frame)
+			frames = append(frames, string(frame))
+		}
+		received <- frames
+	}()
+
+	config, _ := LoadConfigFromString(`
+syslog_protocol="tcp"
+syslog_address="`+listener.Addr().String()+`"
+`, nil)
+
+	repeater, err := NewSyslogJournalRepeater(nil, config)
+	if err != nil {
+		t.Fatalf("Unable to create repeater %s", err)
+	}
+	defer repeater.Close()
+
+	err = repeater.WriteBatch([]*Record{syslogTestRecord, {Message: "second", TimeUsec: 1480459022026000}})
+	if err != nil {
+		t.Fatalf("Unable to write batch %s", err)
+	}
+
+	frames := <-received
+	if len(frames) != 2 {
+		t.Fatalf("Expected two frames got %d", len(frames))
+	}
+	if !strings.HasSuffix(frames[0], "session opened") || !strings.HasSuffix(frames[1], "second") {
+		t.Errorf("Frames not split on octet counts %v", frames)
+	}
+}
+
+func TestSyslogRejectsUnknownProtocol(t *testing.T) {
+
+	config, _ := LoadConfigFromString(`
+syslog_protocol="sctp"
+syslog_address="localhost:514"
+`, nil)
+
+	_, err := NewSyslogJournalRepeater(nil, config)
+	if err == nil {
+		t.Error("Expected an error for an unknown protocol")
+	}
+}
@@ -0,0 +1,93 @@
+package cloud_watch
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// expandTemplate replaces every {name} or {name:arg} placeholder in template with the value
+// returned by lookup. Literal braces are not supported, a placeholder that lookup can not
+// resolve is an error.
+func expandTemplate(template string, lookup func(name string, arg string) (string, bool)) (string, error) {
+
+	var out bytes.Buffer
+	rest := template
+
+	for {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			out.WriteString(rest)
+			break
+		}
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated placeholder in template %q", template)
+		}
+		end += start
+
+		out.WriteString(rest[:start])
+
+		name, arg := rest[start+1:end], ""
+		if colon := strings.Index(name, ":"); colon >= 0 {
+			name, arg = name[:colon], name[colon+1:]
+		}
+
+		value, found := lookup(name, arg)
+		if !found {
+			return "", fmt.Errorf("unknown placeholder {%s} in template %q", rest[start+1:end], template)
+		}
+		out.WriteString(value)
+		rest = rest[end+1:]
+	}
+
+	return out.String(), nil
+}
+
+// recordFieldValue resolves a record field by JSON field name, unit is accepted as a short
+// name for systemdUnit. It is used where a config key names a single record field.
+func recordFieldValue(record *Record, name string) (string, bool) {
+	if name == "unit" {
+		return record.SystemdUnit, true
+	}
+	return recordLookup(record)(name, "")
+}
+
+// recordLookup resolves template placeholders against the JSON field names of a record,
+// for example {systemdUnit} or {hostname}. {date:layout} formats the record time with a Go
+// time layout and {priority} resolves to the lower case priority name.
+func recordLookup(record *Record) func(name string, arg string) (string, bool) {
+
+	return func(name string, arg string) (string, bool) {
+
+		switch name {
+		case "date":
+			if arg == "" {
+				arg = "2006.01.02"
+			}
+			return recordTime(record).UTC().Format(arg), true
+		case "priority":
+			return strings.ToLower(strings.Trim(string(PriorityJsonMap[record.Priority]), "\"")), true
+		}
+
+		value := reflect.ValueOf(record).Elem()
+		valueType := value.Type()
+
+		for i := 0; i < valueType.NumField(); i++ {
+			jsonName := strings.Split(valueType.Field(i).Tag.Get("json"), ",")[0]
+			if jsonName != name {
+				continue
+			}
+			field := value.Field(i)
+			switch field.Kind() {
+			case reflect.String:
+				return field.String(), true
+			case reflect.Int, reflect.Int64:
+				return strconv.FormatInt(field.Int(), 10), true
+			}
+		}
+		return "", false
+	}
+}
@@ -0,0 +1,33 @@
+package cloud_watch
+
+import "testing"
+
+func TestExpandRecordTemplate(t *testing.T) {
+
+	record := &Record{
+		SystemdUnit: "nginx.service",
+		Hostname:    "ip-10-0-0-1",
+		Priority:    ERROR,
+		PID:         42,
+		TimeUsec:    1480459022025000,
+	}
+
+	value, err := expandTemplate("{hostname}/{systemdUnit}/{priority}/{pid}/{date:2006-01}", recordLookup(record))
+	if err != nil {
+		t.Fatalf("Unable to expand template %s", err)
+	}
+
+	if value != "ip-10-0-0-1/nginx.service/error/42/2016-11" {
+		t.Errorf("Unexpected value %s", value)
+	}
+
+	_, err = expandTemplate("{nope}", recordLookup(record))
+	if err == nil {
+		t.Error("Unknown placeholder should be an error")
+	}
+
+	_, err = expandTemplate("{hostname", recordLookup(record))
+	if err == nil {
+		t.Error("Unterminated placeholder should be an error")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	q "github.com/advantageous/go-qbit/qbit"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 	lg "github.com/advantageous/go-logback/logging"
@@ -24,10 +25,21 @@ type Runner struct {
 	config          *Config
 	debug           bool
 	instanceId      string
+	// skipCursors are the cursors of the checkpoint, their entries were already sent.
+	skipCursors map[string]bool
 }
 
+// stoppableRepeater is a repeater that retries a batch until it is stopped.
+type stoppableRepeater interface {
+	Stop()
+}
+
+// Stop stops reading the journal and ends the retries of the batch being sent.
 func (r *Runner) Stop() {
 	r.queueManager.Stop()
+	if repeater, ok := r.journalRepeater.(stoppableRepeater); ok {
+		repeater.Stop()
+	}
 }
 func (r *Runner) addToCloudWatchBatch(record *Record) {
 
@@ -45,10 +57,9 @@ func (r *Runner) sendBatch() {
 		r.records = make([]*Record, 0)
 		err := r.journalRepeater.WriteBatch(batchToSend)
 		if err != nil {
-			r.logger.Error("Failed to write to cloudwatch batch size = : %d %s %v",
-				len(r.records), err.Error(), err)
+			r.logger.Errorf("Failed to write batch size = %d : %s %v", len(batchToSend), err.Error(), err)
 		}
-
+		acknowledgeRecords(batchToSend, err)
 	}
 }
 
@@ -109,7 +120,7 @@ func NewRunnerInternal(journal Journal, repeater JournalRepeater, logger lg.Logg
 
 		go func() {
 			<-signalChannel
-			r.queueManager.Stop()
+			r.Stop()
 		}()
 
 		r.readRecords()
@@ -137,9 +148,17 @@ func (r *Runner) readOneRecord() (*Record, bool, error) {
 		if r.debug {
 			r.logger.Info("No errors, reading log")
 		}
+		if len(r.skipCursors) > 0 {
+			if cursor, err := entryCursor(r.journal); err == nil && r.skipCursors[cursor] {
+				delete(r.skipCursors, cursor)
+				acknowledgeEntry(r.journal, nil)
+				return r.readOneRecord()
+			}
+		}
 		record, err := NewRecord(r.journal, r.logger, r.config)
 		record.InstanceId = r.instanceId
 		if err != nil {
+			acknowledgeEntry(r.journal, err)
 			return nil, false, fmt.Errorf("error unmarshalling record: %v", err)
 		}
 		if r.debug {
@@ -174,6 +193,12 @@ func (r *Runner) readRecords() {
 		}
 
 		if !isReadRecord {
+			if finite, ok := r.journal.(FiniteJournal); ok && err == nil && finite.Done() {
+				r.logger.Info("End of journal input")
+				sendQueue.FlushSends()
+				r.queueManager.Stop()
+				break
+			}
 			if r.queueManager.Stopped() {
 				r.logger.Info("Got stop message")
 				break
@@ -186,7 +211,13 @@ func (r *Runner) readRecords() {
 
 func (r *Runner) positionCursor() {
 
-	if r.config.Tail {
+	if checkpoint, ok := r.journalRepeater.(*CheckpointJournalRepeater); ok && checkpoint.Cursor() != "" {
+		r.seekCheckpoint(checkpoint.Cursor())
+		return
+	}
+
+	switch r.config.StartPosition {
+	case START_POSITION_TAIL:
 		err := r.journal.SeekTail()
 		if err != nil {
 			r.logger.Error("Unable to seek to end of systemd journal", err)
@@ -202,15 +233,121 @@ func (r *Runner) positionCursor() {
 		} else {
 			r.logger.Info("Success: Rewind", r.config.Rewind, count)
 		}
-	} else {
-		err := r.journal.SeekHead()
+	case START_POSITION_SINCE:
+		since, err := ParseTimeSpec(r.config.Since, time.Now())
 		if err != nil {
-			r.logger.Error("Unable to seek to head of systemd journal", err)
-			panic("Unable to seek to end of systemd journal")
-		} else {
-			r.logger.Info("Success: Seek to head of systemd journal")
+			r.logger.Error("Unable to parse since", r.config.Since, err)
+			panic("Unable to parse since")
+		}
+		r.seekRealtime(since)
+	case START_POSITION_CURRENT_BOOT, START_POSITION_PREVIOUS_BOOT:
+		r.seekBoot(r.config.StartPosition == START_POSITION_PREVIOUS_BOOT)
+	default:
+		r.seekHead()
+	}
+
+}
+
+// seekCheckpoint resumes after the last entry that was sent, start_position is not used. The
+// journal returns the entry at the cursor first, so it is skipped.
+func (r *Runner) seekCheckpoint(cursor string) {
+	err := r.journal.SeekCursor(cursor)
+	if err != nil {
+		r.logger.Error("Unable to seek systemd journal to checkpoint", cursor, err)
+		panic("Unable to seek systemd journal to checkpoint")
+	}
+	r.skipCursors = map[string]bool{}
+	for _, sourceCursor := range sourceCursors(cursor) {
+		r.skipCursors[sourceCursor] = true
+	}
+	r.logger.Info("Success: Seek systemd journal to checkpoint", cursor)
+}
+
+// readsLocalJournal is true when the journal is the journal of this machine.
+func (r *Runner) readsLocalJournal() bool {
+	return r.config.JournalDir == "" &&
+		(r.config.JournalReader == JOURNAL_READER_SDJOURNAL || r.config.JournalReader == JOURNAL_READER_NATIVE)
+}
+
+// runningBoot is the index of the running boot in boots, len(boots) when it is not in the
+// journal. When the running boot id can not be read it is the newest boot.
+func (r *Runner) runningBoot(boots []JournalBoot) int {
+	bootId, err := ParseBootId(BOOT_CURRENT)
+	if err != nil {
+		r.logger.Warn("Unable to read the running boot, using the newest boot in the journal", err)
+		return len(boots) - 1
+	}
+	for index, boot := range boots {
+		if strings.Replace(strings.ToLower(boot.Id), "-", "", -1) == bootId {
+			return index
 		}
+	}
+	return len(boots)
+}
+
+func (r *Runner) seekHead() {
+	err := r.journal.SeekHead()
+	if err != nil {
+		r.logger.Error("Unable to seek to head of systemd journal", err)
+		panic("Unable to seek to end of systemd journal")
+	} else {
+		r.logger.Info("Success: Seek to head of systemd journal")
+	}
+}
 
+func (r *Runner) seekRealtime(since time.Time) {
+	err := r.journal.SeekRealtimeUsec(uint64(since.UnixNano() / 1000))
+	if err != nil {
+		r.logger.Error("Unable to seek systemd journal to", since, err)
+		panic("Unable to seek systemd journal to time")
+	} else {
+		r.logger.Info("Success: Seek systemd journal to", since.Format(time.RFC3339))
+	}
+}
+
+// seekBoot seeks to the first entry of the running boot, or of the boot before it. A journal
+// in journal_dir or read from an export may come from another machine, there the newest boot
+// in the journal is used. When there is no such boot it starts from the oldest entry.
+func (r *Runner) seekBoot(previous bool) {
+
+	boots, err := r.journal.ListBoots()
+	if err != nil {
+		r.logger.Error("Unable to list the boots of systemd journal", err)
+		panic("Unable to list the boots of systemd journal")
 	}
 
+	index := len(boots) - 1
+	if r.readsLocalJournal() {
+		index = r.runningBoot(boots)
+	}
+	if previous {
+		index--
+	}
+	if index == len(boots) {
+		// The running boot has no entries yet, they all come after the end of the journal.
+		r.logger.Warn("Running boot not found in the journal, seeking to tail")
+		if err = r.journal.SeekTail(); err != nil {
+			r.logger.Error("Unable to seek to end of systemd journal", err)
+			panic("Unable to seek to end of systemd journal")
+		}
+		return
+	}
+	if index < 0 {
+		r.logger.Warn("No boot found for", r.config.StartPosition, "boots:", len(boots), "seeking to head")
+		r.seekHead()
+		return
+	}
+
+	boot := boots[index]
+	if boot.FirstCursor == "" {
+		err = r.journal.SeekRealtimeUsec(boot.FirstUsec)
+	} else {
+		err = r.journal.SeekCursor(boot.FirstCursor)
+	}
+	if err != nil {
+		r.logger.Error("Unable to seek to boot", boot.Id, err)
+		panic("Unable to seek to boot of systemd journal")
+	} else {
+		r.logger.Info("Success: Seek to boot", boot.Id, time.Unix(0, int64(boot.FirstUsec)*1000).Format(time.RFC3339))
+	}
 }
@@ -2,40 +2,227 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	jcw "github.com/advantageous/systemd-cloud-watch/cloud-watch"
 	"os"
+	"strings"
+	"time"
 	lg "github.com/advantageous/go-logback/logging"
 )
 
+const (
+	COMMAND_RUN          = "run"
+	COMMAND_CHECK_CONFIG = "check-config"
+	COMMAND_PRINT_CONFIG = "print-config"
+	COMMAND_BACKFILL     = "backfill"
+)
+
+// unitList is the backfill -unit flag, it can be repeated or comma separated.
+type unitList []string
+
+func (units *unitList) String() string {
+	return strings.Join(*units, ",")
+}
+
+func (units *unitList) Set(value string) error {
+	for _, unit := range strings.Split(value, ",") {
+		if unit = strings.TrimSpace(unit); unit != "" {
+			*units = append(*units, unit)
+		}
+	}
+	return nil
+}
+
 var help = flag.Bool("help", false, "set to true to show this help")
+var stdout = flag.Bool("stdout", false, "set to true to write records to stdout as JSON lines instead of the configured repeater")
+var dryRun = flag.Bool("dry-run", false, "set to true to print the CloudWatch batches instead of sending them")
+var checkAWS = flag.Bool("aws", false, "check-config: also check the AWS credentials can read the log group")
+var format = flag.String("format", jcw.CONFIG_FORMAT_HCL, "print-config: output format, hcl or json")
+var since = flag.String("since", "", "backfill: start time, e.g. -2h, @1480459022 or \"2016-11-29 22:00:00\", defaults to the oldest entry. "+
+	"run: config key since, environment SCW_SINCE")
+var until = flag.String("until", "", "backfill: end time in the same formats as -since, defaults to now")
+var boot = flag.String("boot", "", "backfill: only send entries of this boot id, current for the running boot")
+var units unitList
+var overrides = jcw.RegisterConfigFlags(flag.CommandLine)
+
+func init() {
+	flag.Var(&units, "unit", "backfill: only send entries of this systemd unit, can be repeated")
+}
 
 func main() {
 
 	logger := lg.NewSimpleLogger("main")
 
-	flag.Parse()
+	// The command is optional so "systemd-cloud-watch <config-file>" still runs.
+	command, args := COMMAND_RUN, os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case COMMAND_RUN, COMMAND_CHECK_CONFIG, COMMAND_PRINT_CONFIG, COMMAND_BACKFILL:
+			command, args = args[0], args[1:]
+		}
+	}
+	flag.CommandLine.Parse(args)
 
 	if *help {
 		usage(logger)
 		os.Exit(0)
 	}
 
+	// -since is the backfill start, for run it is the since config key.
+	if command != COMMAND_BACKFILL && *since != "" {
+		overrides["since"] = *since
+	}
+
+	// Without a config file every setting comes from SCW_* environment variables and flags.
 	configFilename := flag.Arg(0)
-	if configFilename == "" {
-		usage(logger)
-		println("config file name must be set!")
-		os.Exit(2)
+
+	config, err := jcw.CreateConfig(configFilename, overrides, logger)
+	if err != nil {
+		exit(logger, err)
 	}
 
-	config := jcw.CreateConfig(configFilename, logger)
-	journal := jcw.CreateJournal(config, logger)
-	repeater := jcw.CreateRepeater(config, logger)
+	switch command {
+	case COMMAND_CHECK_CONFIG:
+		checkConfig(logger, config)
+	case COMMAND_PRINT_CONFIG:
+		err = jcw.PrintConfig(config, *format, os.Stdout)
+		if err != nil {
+			exit(logger, err)
+		}
+	case COMMAND_BACKFILL:
+		backfill(logger, config)
+	default:
+		run(logger, config)
+	}
+}
+
+func run(logger lg.Logger, config *jcw.Config) {
+
+	if *stdout {
+		config.RepeaterType = jcw.REPEATER_STDOUT
+	}
+	if *dryRun {
+		config.DryRun = true
+	}
+
+	journal, err := jcw.CreateJournal(config, logger)
+	if err != nil {
+		exit(logger, err)
+	}
+
+	repeater, err := jcw.CreateRepeater(config, logger)
+	if err != nil {
+		exit(logger, err)
+	}
+	repeater, err = jcw.CreateCheckpointRepeater(repeater, config, logger)
+	if err != nil {
+		exit(logger, err)
+	}
 
 	jcw.NewRunner(journal, repeater, logger, config)
 
+	err = repeater.Close()
+	if err != nil {
+		logger.Error("Unable to close repeater", err)
+	}
+}
+
+// backfill sends a time range of the journal and exits. It reads the journal on its own so
+// the position of a running daemon is not changed.
+func backfill(logger lg.Logger, config *jcw.Config) {
+
+	if *stdout {
+		config.RepeaterType = jcw.REPEATER_STDOUT
+	}
+	if *dryRun {
+		config.DryRun = true
+	}
+
+	now := time.Now()
+	options := jcw.BackfillOptions{Units: units}
+	var err error
+
+	options.Since, err = jcw.ParseTimeSpec(*since, now)
+	if err != nil {
+		exit(logger, err)
+	}
+	options.Until, err = jcw.ParseTimeSpec(*until, now)
+	if err != nil {
+		exit(logger, err)
+	}
+	if options.Until.IsZero() {
+		options.Until = now
+	}
+	options.BootId, err = jcw.ParseBootId(*boot)
+	if err != nil {
+		exit(logger, err)
+	}
+
+	journal, err := jcw.CreateBackfillJournal(config, options, logger)
+	if err != nil {
+		exit(logger, err)
+	}
+
+	// exit does not run deferred calls, the journal is closed before it.
+	sent, err := sendBackfill(journal, logger, config, options)
+	journal.Close()
+	if err != nil {
+		exit(logger, err)
+	}
+	logger.Infof("Backfilled %d records to %s %s", sent, config.LogGroupName, config.LogStreamName)
+}
+
+func sendBackfill(journal jcw.Journal, logger lg.Logger, config *jcw.Config, options jcw.BackfillOptions) (int, error) {
+
+	repeater, err := jcw.CreateRepeater(config, logger)
+	if err != nil {
+		return 0, err
+	}
+
+	sent, err := jcw.Backfill(journal, repeater, logger, config, options)
+	closeErr := repeater.Close()
+	if closeErr != nil {
+		logger.Error("Unable to close repeater", closeErr)
+	}
+	return sent, err
+}
+
+// checkConfig exits 0 when the config is valid, CreateConfig already exited on any problem.
+func checkConfig(logger lg.Logger, config *jcw.Config) {
+
+	if *checkAWS {
+		result, err := jcw.CheckAWSAccess(config)
+		if err != nil {
+			exit(logger, err)
+		}
+		fmt.Fprintln(os.Stdout, result)
+	}
+	fmt.Fprintln(os.Stdout, "config OK")
+}
+
+// exit logs the error and exits, instance metadata failures keep their own exit codes.
+func exit(logger lg.Logger, err error) {
+
+	logger.Error(err.Error())
+
+	if metadataErr, ok := err.(*jcw.MetadataError); ok {
+		switch metadataErr.Field {
+		case jcw.METADATA_REGION:
+			os.Exit(3)
+		case jcw.METADATA_INSTANCE_ID:
+			os.Exit(4)
+		case jcw.METADATA_AVAILABILITY_ZONE:
+			os.Exit(5)
+		case jcw.METADATA_LOCAL_IPV4:
+			os.Exit(6)
+		}
+	}
+	os.Exit(1)
 }
 
 func usage(logger lg.Logger) {
-	logger.Error("Usage: systemd-cloud-watch  <config-file>")
+	logger.Error("Usage: systemd-cloud-watch [run|check-config|print-config|backfill] [-stdout] [-dry-run] [-aws] [-format=hcl|json] " +
+		"[-since=<time>] [-until=<time>] [-boot=<id>|current] [-unit=<unit> ...] " +
+		"[-<config_key>=<value> ...] [config-file]")
 	flag.PrintDefaults()
 }